@@ -0,0 +1,206 @@
+package bgra
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"math/rand"
+	"testing"
+)
+
+// randomImage returns an Image and an *image.RGBA with the same random opaque pixels.
+func randomImage(r *rand.Rand, rect image.Rectangle) (*Image, *image.RGBA) {
+	b, rgba := New(rect), image.NewRGBA(rect)
+	r.Read(rgba.Pix)
+	for i := 0; i < len(rgba.Pix); i += 4 {
+		rgba.Pix[i+3] = 0xFF
+		b.Pix[i], b.Pix[i+1], b.Pix[i+2], b.Pix[i+3] = rgba.Pix[i+2], rgba.Pix[i+1], rgba.Pix[i], 0xFF
+	}
+	return b, rgba
+}
+
+func sameImage(t *testing.T, name string, got, want image.Image) {
+	t.Helper()
+	if got.Bounds() != want.Bounds() {
+		t.Fatalf("%s: bounds %v, want %v", name, got.Bounds(), want.Bounds())
+	}
+	b := want.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			g, w := color.RGBAModel.Convert(got.At(x, y)), color.RGBAModel.Convert(want.At(x, y))
+			if g != w {
+				t.Fatalf("%s: pixel (%d, %d) = %v, want %v", name, x, y, g, w)
+			}
+		}
+	}
+}
+
+func TestImage(t *testing.T) {
+	m := New(image.Rect(-2, 3, 6, 9))
+	c := color.RGBA{R: 10, G: 20, B: 30, A: 40}
+	m.Set(0, 4, c)
+	if got := m.At(0, 4); got != c {
+		t.Errorf("At = %v, want %v", got, c)
+	}
+	if i := m.PixOffset(0, 4); !bytes.Equal(m.Pix[i:i+4], []byte{30, 20, 10, 40}) {
+		t.Errorf("Pix = %v, want BGRA order", m.Pix[i:i+4])
+	}
+	// Set converts non-premultiplied colors
+	m.Set(1, 4, color.NRGBA{R: 255, A: 128})
+	if got := m.RGBAAt(1, 4); got != (color.RGBA{R: 128, A: 128}) {
+		t.Errorf("RGBAAt = %v", got)
+	}
+	m.Set(100, 100, c)
+	if got := m.At(100, 100); got != (color.RGBA{}) {
+		t.Errorf("At outside of the image = %v", got)
+	}
+
+	sub := m.SubImage(image.Rect(0, 4, 2, 5)).(*Image)
+	if sub.RGBAAt(0, 4) != c || sub.Bounds() != image.Rect(0, 4, 2, 5) {
+		t.Errorf("SubImage = %v", sub)
+	}
+	if sub.Opaque() || m.Opaque() {
+		t.Error("Opaque() = true, want false")
+	}
+	sub.SetRGBA(0, 4, color.RGBA{A: 255})
+	sub.SetRGBA(1, 4, color.RGBA{A: 255})
+	if !sub.Opaque() {
+		t.Error("Opaque() = false, want true")
+	}
+	if s := m.SubImage(image.Rect(100, 100, 101, 101)); !s.Bounds().Empty() {
+		t.Errorf("SubImage outside of the image = %v", s.Bounds())
+	}
+}
+
+func TestDraw(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	srcB, srcRGBA := randomImage(r, image.Rect(0, 0, 37, 23))
+	translucent := image.NewRGBA(srcRGBA.Rect)
+	copy(translucent.Pix, srcRGBA.Pix)
+	translucent.Pix[3] = 0x80
+
+	testCases := []struct {
+		name string
+		src  image.Image
+		op   draw.Op
+	}{
+		{"BGRA Src", srcB, draw.Src},
+		{"BGRA Over", srcB, draw.Over},
+		{"RGBA Src", srcRGBA, draw.Src},
+		{"RGBA Over", srcRGBA, draw.Over},
+		{"translucent Over", translucent, draw.Over},
+		{"uniform", image.NewUniform(color.RGBA{1, 2, 3, 255}), draw.Src},
+	}
+	for _, tc := range testCases {
+		for _, rect := range []image.Rectangle{image.Rect(0, 0, 40, 40), image.Rect(3, 5, 20, 30), image.Rect(-5, -5, 10, 10)} {
+			for _, sp := range []image.Point{{0, 0}, {4, 2}, {-3, 1}} {
+				// both destinations start out the same
+				dstB, dstRGBA := randomImage(r, image.Rect(-2, -2, 30, 25))
+				wantB := New(dstB.Rect)
+				copy(wantB.Pix, dstB.Pix)
+				wantRGBA := image.NewRGBA(dstRGBA.Rect)
+				copy(wantRGBA.Pix, dstRGBA.Pix)
+
+				Draw(dstB, rect, tc.src, sp, tc.op)
+				Draw(dstRGBA, rect, tc.src, sp, tc.op)
+				// draw.Draw only has generic code for Image
+				draw.Draw(wantB, rect, tc.src, sp, tc.op)
+				draw.Draw(wantRGBA, rect, tc.src, sp, tc.op)
+				sameImage(t, tc.name+" to BGRA", dstB, wantB)
+				sameImage(t, tc.name+" to RGBA", dstRGBA, wantRGBA)
+			}
+		}
+	}
+}
+
+func TestDrawOverlapping(t *testing.T) {
+	m, rgba := randomImage(rand.New(rand.NewSource(2)), image.Rect(0, 0, 16, 16))
+	Draw(m, image.Rect(2, 3, 16, 16), m, image.Pt(0, 0), draw.Src)
+	draw.Draw(rgba, image.Rect(2, 3, 16, 16), rgba, image.Pt(0, 0), draw.Src)
+	sameImage(t, "scroll down", m, rgba)
+	Draw(m, image.Rect(0, 0, 12, 12), m, image.Pt(3, 4), draw.Src)
+	draw.Draw(rgba, image.Rect(0, 0, 12, 12), rgba, image.Pt(3, 4), draw.Src)
+	sameImage(t, "scroll up", m, rgba)
+}
+
+func TestEncodePNG(t *testing.T) {
+	m, rgba := randomImage(rand.New(rand.NewSource(3)), image.Rect(0, 0, 33, 17))
+	var buf bytes.Buffer
+	if err := EncodePNG(&buf, m.SubImage(image.Rect(1, 2, 30, 17))); err != nil {
+		t.Fatal(err)
+	}
+	got, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// PNG does not store the origin
+	want := image.NewRGBA(image.Rect(0, 0, 29, 15))
+	draw.Draw(want, want.Rect, rgba, image.Pt(1, 2), draw.Src)
+	sameImage(t, "PNG", got, want)
+}
+
+func TestEncodeJPEG(t *testing.T) {
+	// a smooth gradient, noise would amplify the rounding differences through quantization
+	m, rgba := New(image.Rect(0, 0, 64, 48)), image.NewRGBA(image.Rect(0, 0, 64, 48))
+	for y := 0; y < 48; y++ {
+		for x := 0; x < 64; x++ {
+			c := color.RGBA{uint8(x * 4), uint8(y * 5), uint8(255 - x*2 - y), 255}
+			m.SetRGBA(x, y, c)
+			rgba.SetRGBA(x, y, c)
+		}
+	}
+	var got, want bytes.Buffer
+	if err := EncodeJPEG(&got, m, &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatal(err)
+	}
+	if err := jpeg.Encode(&want, rgba, &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatal(err)
+	}
+	gotImg, err := jpeg.Decode(&got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantImg, _ := jpeg.Decode(&want)
+	// both are 4:2:0, only the rounding of the color conversion differs
+	b := wantImg.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			g, w := gotImg.(*image.YCbCr).YCbCrAt(x, y), wantImg.(*image.YCbCr).YCbCrAt(x, y)
+			if diff(g.Y, w.Y) > 2 || diff(g.Cb, w.Cb) > 2 || diff(g.Cr, w.Cr) > 2 {
+				t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, g, w)
+			}
+		}
+	}
+}
+
+func diff(a, b uint8) int {
+	if a > b {
+		return int(a - b)
+	}
+	return int(b - a)
+}
+
+func benchmarkEncode(b *testing.B, m image.Image, encode func(*bytes.Buffer, image.Image) error) {
+	var buf bytes.Buffer
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		if err := encode(&buf, m); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncodeJPEG(b *testing.B) {
+	m, _ := randomImage(rand.New(rand.NewSource(1)), image.Rect(0, 0, 1920, 1080))
+	benchmarkEncode(b, m, func(buf *bytes.Buffer, m image.Image) error { return EncodeJPEG(buf, m, nil) })
+}
+
+// BenchmarkEncodeJPEGGeneric is what encoding an Image without EncodeJPEG costs.
+func BenchmarkEncodeJPEGGeneric(b *testing.B) {
+	m, _ := randomImage(rand.New(rand.NewSource(1)), image.Rect(0, 0, 1920, 1080))
+	benchmarkEncode(b, m, func(buf *bytes.Buffer, m image.Image) error { return jpeg.Encode(buf, m, nil) })
+}
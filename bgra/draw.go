@@ -0,0 +1,76 @@
+package bgra
+
+import (
+	"image"
+	"image/draw"
+
+	"github.com/kirides/screencapture/swizzle"
+)
+
+// Draw is like draw.Draw, with fast paths for copying between Image and *image.RGBA in any direction.
+// They are taken for draw.Src and for draw.Over with an opaque source,
+// everything else is left to draw.Draw.
+func Draw(dst draw.Image, r image.Rectangle, src image.Image, sp image.Point, op draw.Op) {
+	r, sp = clip(dst.Bounds(), r, src.Bounds(), sp)
+	if r.Empty() {
+		return
+	}
+	dstPix, dstStride, dstBGRA, ok := pixels(dst, r.Min)
+	if !ok {
+		draw.Draw(dst, r, src, sp, op)
+		return
+	}
+	srcPix, srcStride, srcBGRA, ok := pixels(src, sp)
+	if !ok || (op != draw.Src && !opaque(src, image.Rectangle{sp, sp.Add(r.Size())})) {
+		draw.Draw(dst, r, src, sp, op)
+		return
+	}
+
+	size := image.Rectangle{Max: r.Size()}
+	if dstBGRA != srcBGRA {
+		swizzle.CopyBGRA(dstPix, dstStride, srcPix, srcStride, size)
+		return
+	}
+	n := size.Dx() * 4
+	if dst == src && r.Min.Y > sp.Y {
+		// overlapping rows, copy bottom up
+		for y := size.Dy() - 1; y >= 0; y-- {
+			copy(dstPix[y*dstStride:][:n], srcPix[y*srcStride:][:n])
+		}
+		return
+	}
+	for y := 0; y < size.Dy(); y++ {
+		copy(dstPix[y*dstStride:][:n], srcPix[y*srcStride:][:n])
+	}
+}
+
+// pixels returns the pixels of m starting at p, if m is an Image or *image.RGBA.
+func pixels(m image.Image, p image.Point) (pix []byte, stride int, isBGRA, ok bool) {
+	switch m := m.(type) {
+	case *Image:
+		return m.Pix[m.PixOffset(p.X, p.Y):], m.Stride, true, true
+	case *image.RGBA:
+		return m.Pix[m.PixOffset(p.X, p.Y):], m.Stride, false, true
+	}
+	return nil, 0, false, false
+}
+
+// opaque reports whether the pixels of m, an Image or *image.RGBA, are opaque within r.
+func opaque(m image.Image, r image.Rectangle) bool {
+	switch m := m.(type) {
+	case *Image:
+		return m.SubImage(r).(*Image).Opaque()
+	case *image.RGBA:
+		return m.SubImage(r).(*image.RGBA).Opaque()
+	}
+	return false
+}
+
+// clip clips r against each image's bounds, after translating into the destination image's coordinate space,
+// and shifts sp by the same amount as the change in r.Min, like draw.Draw does.
+func clip(dst, r, src image.Rectangle, sp image.Point) (image.Rectangle, image.Point) {
+	orig := r.Min
+	r = r.Intersect(dst)
+	r = r.Intersect(src.Add(orig.Sub(sp)))
+	return r, sp.Add(r.Min.Sub(orig))
+}
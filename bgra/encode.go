@@ -0,0 +1,65 @@
+package bgra
+
+import (
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"sync"
+
+	"github.com/kirides/screencapture/swizzle"
+)
+
+// jpegYUV is the color conversion of JPEG.
+var jpegYUV = swizzle.YUVOptions{Matrix: swizzle.BT601, FullRange: true}
+
+// ToYCbCr converts p into a 4:2:0 YCbCr image using the color conversion of JPEG.
+// dst is reused if it has the same bounds and subsample ratio, otherwise a new image is returned.
+func (p *Image) ToYCbCr(dst *image.YCbCr) *image.YCbCr {
+	if dst == nil || dst.Rect != p.Rect || dst.SubsampleRatio != image.YCbCrSubsampleRatio420 {
+		dst = image.NewYCbCr(p.Rect, image.YCbCrSubsampleRatio420)
+	}
+	swizzle.BGRAToI420(dst, p.Pix, p.Stride, jpegYUV)
+	return dst
+}
+
+// ToRGBA converts p into an *image.RGBA.
+// dst is reused if it has the same bounds, otherwise a new image is returned.
+func (p *Image) ToRGBA(dst *image.RGBA) *image.RGBA {
+	if dst == nil || dst.Rect != p.Rect {
+		dst = image.NewRGBA(p.Rect)
+	}
+	swizzle.CopyBGRA(dst.Pix, dst.Stride, p.Pix, p.Stride, image.Rectangle{Max: p.Rect.Size()})
+	return dst
+}
+
+var (
+	ycbcrPool sync.Pool
+	rgbaPool  sync.Pool
+)
+
+// EncodeJPEG is like jpeg.Encode. An Image is converted to YCbCr first, which image/jpeg encodes
+// without the per pixel color conversion it does for unknown image types.
+func EncodeJPEG(w io.Writer, m image.Image, o *jpeg.Options) error {
+	p, ok := m.(*Image)
+	if !ok {
+		return jpeg.Encode(w, m, o)
+	}
+	dst, _ := ycbcrPool.Get().(*image.YCbCr)
+	dst = p.ToYCbCr(dst)
+	defer ycbcrPool.Put(dst)
+	return jpeg.Encode(w, dst, o)
+}
+
+// EncodePNG is like png.Encode. An Image is converted to an *image.RGBA first,
+// which image/png encodes without calling At for every pixel.
+func EncodePNG(w io.Writer, m image.Image) error {
+	p, ok := m.(*Image)
+	if !ok {
+		return png.Encode(w, m)
+	}
+	dst, _ := rgbaPool.Get().(*image.RGBA)
+	dst = p.ToRGBA(dst)
+	defer rgbaPool.Put(dst)
+	return png.Encode(w, dst)
+}
@@ -0,0 +1,80 @@
+// Package capture provides a platform-neutral interface over the different
+// screen capturing backends of this module.
+//
+// Backends live in their own packages and register themselves by name,
+// similar to database/sql drivers:
+//
+//	import _ "github.com/kirides/screencapture/capture/dxgi"
+//
+//	src, err := capture.New("dxgi", 0)
+package capture
+
+import (
+	"context"
+	"errors"
+	"image"
+	"time"
+)
+
+// ErrNoImageYet is returned by Source.NextFrame when the display did not
+// change since the last frame. The previously returned frame is still valid.
+var ErrNoImageYet = errors.New("no image yet")
+
+// Frame is a single captured image of a display.
+type Frame struct {
+	// Image holds the captured pixels, starting at (0, 0).
+	//
+	// It is owned by the Source and re-used for subsequent frames,
+	// so it is only valid until the next call to NextFrame or Close.
+	Image *image.RGBA
+
+	// Seq increases with every frame returned by a Source, starting at 1.
+	// Gaps mean that frames were dropped, e.g. by DetectChanges.
+	Seq uint64
+	// Time is when the frame was presented, if the backend knows it, otherwise when it was captured.
+	Time time.Time
+	// AccumulatedFrames is the number of display updates combined into this frame, 0 if unknown.
+	AccumulatedFrames int
+	// RectsCoalesced is set if Dirty was merged into fewer, larger regions,
+	// so they might contain unchanged pixels.
+	RectsCoalesced bool
+	// ProtectedContentMaskedOut is set if protected content, e.g. DRM video, was blacked out.
+	ProtectedContentMaskedOut bool
+
+	// Moves lists regions of the previous frame that were moved to a new position.
+	// They have to be applied in order, before updating the Dirty regions, see Apply.
+	Moves []Move
+	// Dirty lists the regions that changed since the previous frame.
+	// Backends without damage information report the whole image as dirty.
+	Dirty []image.Rectangle
+
+	// Pointer describes the mouse pointer, it is nil if the backend does not report it.
+	Pointer *Pointer
+}
+
+// Move describes a region of the previous frame that got moved, e.g. when scrolling.
+// It mirrors DXGI_OUTDUPL_MOVE_RECT.
+type Move struct {
+	// Src is the top-left corner of the region in the previous frame.
+	Src image.Point
+	// Dst is the region the pixels were moved to.
+	Dst image.Rectangle
+}
+
+// Source captures the contents of a single display.
+//
+// A Source is not safe for concurrent use. Some backends (e.g. DXGI) rely on
+// thread local state, so callers should call runtime.LockOSThread before Open
+// and keep using the Source from the same goroutine.
+type Source interface {
+	// Open acquires the resources needed for capturing.
+	Open() error
+	// NextFrame captures the next frame.
+	// It returns ErrNoImageYet if there was no update since the last frame.
+	NextFrame(ctx context.Context) (*Frame, error)
+	// Bounds reports the position and size of the captured display
+	// in virtual desktop coordinates.
+	Bounds() image.Rectangle
+	// Close releases all resources acquired by Open.
+	Close() error
+}
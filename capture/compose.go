@@ -0,0 +1,58 @@
+package capture
+
+import "image"
+
+// Apply updates prev, the previous frame, to the frame described by moves and dirty.
+//
+// The moves are applied in order, each one reading the pixels as they were before that move,
+// so source and destination may overlap. Afterwards the dirty regions are copied from next.
+// Regions are clipped to the bounds of the images.
+func Apply(prev *image.RGBA, moves []Move, dirty []image.Rectangle, next *image.RGBA) {
+	for _, m := range moves {
+		moveRect(prev, m)
+	}
+	for _, r := range dirty {
+		copyRect(prev, next, r)
+	}
+}
+
+// ApplyTo updates prev to f, using only the moves and dirty regions of f.
+// prev has to hold the previous frame of the same Source.
+func (f *Frame) ApplyTo(prev *image.RGBA) {
+	Apply(prev, f.Moves, f.Dirty, f.Image)
+}
+
+func moveRect(img *image.RGBA, m Move) {
+	delta := m.Src.Sub(m.Dst.Min)
+	// clip the destination, so that the source stays within img as well
+	dst := m.Dst.Intersect(img.Rect).Intersect(img.Rect.Sub(delta))
+	if dst.Empty() || delta == (image.Point{}) {
+		return
+	}
+	n := dst.Dx() * 4
+	row := func(y int) {
+		// copy handles overlapping rows
+		copy(img.Pix[img.PixOffset(dst.Min.X, y):][:n], img.Pix[img.PixOffset(dst.Min.X+delta.X, y+delta.Y):][:n])
+	}
+	if delta.Y < 0 {
+		// moving down, start at the bottom to not overwrite rows before they are read
+		for y := dst.Max.Y - 1; y >= dst.Min.Y; y-- {
+			row(y)
+		}
+		return
+	}
+	for y := dst.Min.Y; y < dst.Max.Y; y++ {
+		row(y)
+	}
+}
+
+func copyRect(dst, src *image.RGBA, r image.Rectangle) {
+	r = r.Intersect(dst.Rect).Intersect(src.Rect)
+	if r.Empty() {
+		return
+	}
+	n := r.Dx() * 4
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		copy(dst.Pix[dst.PixOffset(r.Min.X, y):][:n], src.Pix[src.PixOffset(r.Min.X, y):][:n])
+	}
+}
@@ -0,0 +1,114 @@
+package capture
+
+import (
+	"bytes"
+	"image"
+	"math/rand"
+	"testing"
+)
+
+func randomImage(r *rand.Rand, rect image.Rectangle) *image.RGBA {
+	img := image.NewRGBA(rect)
+	r.Read(img.Pix)
+	return img
+}
+
+// referenceMove applies m using a temporary copy of the source, pixel by pixel.
+func referenceMove(img *image.RGBA, m Move) {
+	tmp := image.NewRGBA(m.Dst)
+	for y := m.Dst.Min.Y; y < m.Dst.Max.Y; y++ {
+		for x := m.Dst.Min.X; x < m.Dst.Max.X; x++ {
+			sp := image.Pt(x, y).Sub(m.Dst.Min).Add(m.Src)
+			if sp.In(img.Rect) {
+				tmp.SetRGBA(x, y, img.RGBAAt(sp.X, sp.Y))
+			} else {
+				tmp.SetRGBA(x, y, img.RGBAAt(x, y))
+			}
+		}
+	}
+	copyRect(img, tmp, m.Dst)
+}
+
+func TestApplyOverlappingMoves(t *testing.T) {
+	rect := image.Rect(0, 0, 40, 30)
+	r := rand.New(rand.NewSource(1))
+	moves := []Move{
+		{Src: image.Pt(0, 5), Dst: image.Rect(0, 0, 40, 25)},   // scroll up
+		{Src: image.Pt(0, 0), Dst: image.Rect(0, 7, 40, 30)},   // scroll down
+		{Src: image.Pt(3, 0), Dst: image.Rect(0, 0, 37, 30)},   // scroll left
+		{Src: image.Pt(0, 0), Dst: image.Rect(2, 0, 40, 30)},   // scroll right
+		{Src: image.Pt(4, 4), Dst: image.Rect(6, 7, 20, 19)},   // diagonal
+		{Src: image.Pt(10, 10), Dst: image.Rect(8, 8, 30, 20)}, // diagonal, up left
+		{Src: image.Pt(1, 1), Dst: image.Rect(1, 1, 9, 9)},     // no-op
+	}
+	for _, m := range moves {
+		got := randomImage(r, rect)
+		want := image.NewRGBA(rect)
+		copy(want.Pix, got.Pix)
+
+		Apply(got, []Move{m}, nil, nil)
+		referenceMove(want, m)
+		if !bytes.Equal(got.Pix, want.Pix) {
+			t.Errorf("move %+v: result differs from reference", m)
+		}
+	}
+}
+
+func TestApplyClipsMoves(t *testing.T) {
+	rect := image.Rect(0, 0, 16, 16)
+	r := rand.New(rand.NewSource(2))
+	for _, m := range []Move{
+		{Src: image.Pt(-4, 2), Dst: image.Rect(0, 0, 10, 10)},
+		{Src: image.Pt(10, 10), Dst: image.Rect(0, 0, 10, 10)},
+		{Src: image.Pt(0, 0), Dst: image.Rect(12, 12, 30, 30)},
+		{Src: image.Pt(40, 40), Dst: image.Rect(0, 0, 4, 4)},
+	} {
+		got := randomImage(r, rect)
+		want := image.NewRGBA(rect)
+		copy(want.Pix, got.Pix)
+
+		Apply(got, []Move{m}, nil, nil)
+		referenceMove(want, m)
+		if !bytes.Equal(got.Pix, want.Pix) {
+			t.Errorf("move %+v: result differs from reference", m)
+		}
+	}
+}
+
+func TestApplyMovesThenDirty(t *testing.T) {
+	rect := image.Rect(0, 0, 32, 24)
+	r := rand.New(rand.NewSource(3))
+	prev := randomImage(r, rect)
+
+	// scroll up by 4 rows, then twice to the left, with new content in the uncovered areas
+	moves := []Move{
+		{Src: image.Pt(0, 4), Dst: image.Rect(0, 0, 32, 20)},
+		{Src: image.Pt(2, 0), Dst: image.Rect(0, 0, 30, 24)},
+		{Src: image.Pt(2, 0), Dst: image.Rect(0, 0, 30, 24)},
+	}
+	dirty := []image.Rectangle{image.Rect(0, 20, 32, 24), image.Rect(28, 0, 32, 24)}
+
+	want := image.NewRGBA(rect)
+	copy(want.Pix, prev.Pix)
+	for _, m := range moves {
+		referenceMove(want, m)
+	}
+	next := randomImage(r, rect)
+	for _, d := range dirty {
+		copyRect(want, next, d)
+	}
+	// next holds the real frame, which equals want outside of the dirty regions
+	for y := 0; y < rect.Dy(); y++ {
+		for x := 0; x < rect.Dx(); x++ {
+			if !image.Pt(x, y).In(dirty[0]) && !image.Pt(x, y).In(dirty[1]) {
+				next.SetRGBA(x, y, want.RGBAAt(x, y))
+			}
+		}
+	}
+
+	frame := &Frame{Image: next, Moves: moves, Dirty: dirty}
+	frame.ApplyTo(prev)
+	if !bytes.Equal(prev.Pix, next.Pix) {
+		t.Errorf("applying the frame did not reproduce it")
+	}
+}
@@ -0,0 +1,145 @@
+package capture
+
+import (
+	"bytes"
+	"context"
+	"image"
+
+	"github.com/kirides/screencapture/region"
+)
+
+// Differ finds the parts of an image that changed since the previous call to Diff,
+// by comparing it tile by tile against a copy of the previous image.
+type Differ struct {
+	tileSize int
+	prev     *image.RGBA
+	checked  []bool
+	changed  []image.Rectangle
+}
+
+// NewDiffer returns a Differ comparing tiles of tileSize x tileSize pixels.
+func NewDiffer(tileSize int) *Differ {
+	if tileSize < 1 {
+		tileSize = 1
+	}
+	return &Differ{tileSize: tileSize}
+}
+
+// Reset forgets the previous image, so the next one is reported as changed entirely.
+func (d *Differ) Reset() {
+	d.prev = nil
+}
+
+// Diff compares img to the previous image and returns the changed tiles, merged into rectangles.
+//
+// moves are applied to the previous image first and only tiles intersecting dirty are compared,
+// everything else is assumed to be unchanged, like in a Frame.
+// The first image, or an image of a different size, is reported as changed entirely.
+// The returned slice is only valid until the next call to Diff.
+func (d *Differ) Diff(img *image.RGBA, moves []Move, dirty []image.Rectangle) []image.Rectangle {
+	if d.prev == nil || d.prev.Rect != img.Rect {
+		d.prev = image.NewRGBA(img.Rect)
+		copyRect(d.prev, img, img.Rect)
+		return append(d.changed[:0], img.Rect)
+	}
+	Apply(d.prev, moves, nil, nil)
+
+	ts := d.tileSize
+	b := img.Rect
+	cols, rows := (b.Dx()+ts-1)/ts, (b.Dy()+ts-1)/ts
+	if len(d.checked) < cols*rows {
+		d.checked = make([]bool, cols*rows)
+	}
+	checked := d.checked[:cols*rows]
+	for i := range checked {
+		checked[i] = false
+	}
+
+	d.changed = d.changed[:0]
+	for _, r := range dirty {
+		r = r.Intersect(b).Sub(b.Min)
+		if r.Empty() {
+			continue
+		}
+		for ty := r.Min.Y / ts; ty <= (r.Max.Y-1)/ts; ty++ {
+			for tx := r.Min.X / ts; tx <= (r.Max.X-1)/ts; tx++ {
+				if checked[ty*cols+tx] {
+					continue
+				}
+				checked[ty*cols+tx] = true
+				tile := image.Rect(tx*ts, ty*ts, tx*ts+ts, ty*ts+ts).Add(b.Min).Intersect(b)
+				if !d.equal(img, tile) {
+					copyRect(d.prev, img, tile)
+					d.changed = append(d.changed, tile)
+				}
+			}
+		}
+	}
+	if len(d.changed) > 1 {
+		d.changed = append(d.changed[:0], region.New(d.changed...).Rects()...)
+	}
+	return d.changed
+}
+
+// equal reports whether img and the previous image are equal within r.
+func (d *Differ) equal(img *image.RGBA, r image.Rectangle) bool {
+	n := r.Dx() * 4
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		if !bytes.Equal(img.Pix[img.PixOffset(r.Min.X, y):][:n], d.prev.Pix[d.prev.PixOffset(r.Min.X, y):][:n]) {
+			return false
+		}
+	}
+	return true
+}
+
+// DetectChanges wraps src, so that its frames only report the tiles that actually changed
+// and NextFrame returns ErrNoImageYet if nothing changed.
+// It is meant for backends without damage information, which report the whole image as dirty.
+func DetectChanges(src Source, tileSize int) Source {
+	return &changeDetector{Source: src, differ: NewDiffer(tileSize)}
+}
+
+type changeDetector struct {
+	Source
+	differ *Differ
+	frame  Frame
+
+	pointer    Pointer
+	hasPointer bool
+}
+
+func (c *changeDetector) Open() error {
+	c.differ.Reset()
+	c.hasPointer = false
+	return c.Source.Open()
+}
+
+func (c *changeDetector) NextFrame(ctx context.Context) (*Frame, error) {
+	f, err := c.Source.NextFrame(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.frame = *f
+	c.frame.Dirty = c.differ.Diff(f.Image, f.Moves, f.Dirty)
+	pointerChanged := c.pointerChanged(f.Pointer)
+	if len(c.frame.Dirty) == 0 && len(c.frame.Moves) == 0 && !pointerChanged {
+		return nil, ErrNoImageYet
+	}
+	return &c.frame, nil
+}
+
+// pointerChanged reports whether p differs from the pointer of the previous frame.
+func (c *changeDetector) pointerChanged(p *Pointer) bool {
+	if p == nil {
+		return false
+	}
+	changed := !c.hasPointer || *p != c.pointer
+	c.pointer, c.hasPointer = *p, true
+	return changed
+}
+
+func (c *changeDetector) SetDrawPointer(enabled bool) {
+	if pd, ok := c.Source.(PointerDrawer); ok {
+		pd.SetDrawPointer(enabled)
+	}
+}
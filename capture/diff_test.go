@@ -0,0 +1,130 @@
+package capture
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestDiffer(t *testing.T) {
+	rect := image.Rect(0, 0, 70, 50)
+	img := randomImage(rand.New(rand.NewSource(1)), rect)
+	d := NewDiffer(16)
+
+	if got := d.Diff(img, nil, []image.Rectangle{rect}); !reflect.DeepEqual(got, []image.Rectangle{rect}) {
+		t.Fatalf("first Diff() = %v, want the whole image", got)
+	}
+	if got := d.Diff(img, nil, []image.Rectangle{rect}); len(got) != 0 {
+		t.Fatalf("Diff() of an unchanged image = %v, want nothing", got)
+	}
+
+	// two pixels in horizontally adjacent tiles and one in the clipped corner tile
+	img.SetRGBA(15, 3, color.RGBA{1, 2, 3, 4})
+	img.SetRGBA(16, 3, color.RGBA{1, 2, 3, 4})
+	img.SetRGBA(69, 49, color.RGBA{1, 2, 3, 4})
+	want := []image.Rectangle{image.Rect(0, 0, 32, 16), image.Rect(64, 48, 70, 50)}
+	if got := d.Diff(img, nil, []image.Rectangle{rect}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Diff() = %v, want %v", got, want)
+	}
+
+	// changes outside of the dirty regions are not seen
+	img.SetRGBA(40, 40, color.RGBA{5, 6, 7, 8})
+	if got := d.Diff(img, nil, []image.Rectangle{image.Rect(0, 0, 20, 20)}); len(got) != 0 {
+		t.Fatalf("Diff() = %v, want nothing", got)
+	}
+
+	d.Reset()
+	if got := d.Diff(img, nil, nil); !reflect.DeepEqual(got, []image.Rectangle{rect}) {
+		t.Fatalf("Diff() after Reset = %v, want the whole image", got)
+	}
+}
+
+func TestDifferMoves(t *testing.T) {
+	rect := image.Rect(0, 0, 64, 64)
+	r := rand.New(rand.NewSource(2))
+	img := randomImage(r, rect)
+	d := NewDiffer(8)
+	d.Diff(img, nil, []image.Rectangle{rect})
+
+	// scroll up by 8 pixels, the last row of tiles has new content
+	moves := []Move{{Src: image.Pt(0, 8), Dst: image.Rect(0, 0, 64, 56)}}
+	Apply(img, moves, nil, nil)
+	r.Read(img.Pix[img.PixOffset(0, 56):])
+
+	want := []image.Rectangle{image.Rect(0, 56, 64, 64)}
+	if got := d.Diff(img, moves, []image.Rectangle{rect}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Diff() = %v, want %v", got, want)
+	}
+}
+
+// staticSource returns the same image for every frame, reporting it as dirty.
+type staticSource struct {
+	fakeSource
+	pointer *Pointer
+}
+
+func (s *staticSource) NextFrame(ctx context.Context) (*Frame, error) {
+	s.frame.Dirty = append(s.frame.Dirty[:0], s.frame.Image.Rect)
+	s.frame.Pointer = s.pointer
+	return &s.frame, nil
+}
+
+func TestDetectChanges(t *testing.T) {
+	inner := &staticSource{fakeSource: fakeSource{bounds: image.Rect(0, 0, 100, 100)}}
+	src := DetectChanges(inner, 32)
+	if err := src.Open(); err != nil {
+		t.Fatal(err)
+	}
+	next := func() (*Frame, error) { return src.NextFrame(context.Background()) }
+
+	if f, err := next(); err != nil || len(f.Dirty) != 1 || f.Dirty[0] != src.Bounds().Sub(src.Bounds().Min) {
+		t.Fatalf("first frame: %v, %v", f, err)
+	}
+	if _, err := next(); !errors.Is(err, ErrNoImageYet) {
+		t.Fatalf("unchanged frame: got %v, want ErrNoImageYet", err)
+	}
+
+	inner.frame.Image.SetRGBA(50, 50, color.RGBA{R: 0xFF, A: 0xFF})
+	f, err := next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []image.Rectangle{image.Rect(32, 32, 64, 64)}; !reflect.DeepEqual(f.Dirty, want) {
+		t.Errorf("Dirty = %v, want %v", f.Dirty, want)
+	}
+
+	// pointer updates are passed on, even if the image did not change
+	inner.pointer = &Pointer{Position: image.Pt(3, 4), Visible: true}
+	if f, err := next(); err != nil || len(f.Dirty) != 0 || f.Pointer == nil {
+		t.Fatalf("pointer frame: %v, %v", f, err)
+	}
+	if _, err := next(); !errors.Is(err, ErrNoImageYet) {
+		t.Fatalf("unchanged pointer: got %v, want ErrNoImageYet", err)
+	}
+
+	// reopening starts over with a full frame
+	src.Close()
+	if err := src.Open(); err != nil {
+		t.Fatal(err)
+	}
+	if f, err := next(); err != nil || len(f.Dirty) != 1 {
+		t.Fatalf("frame after Open: %v, %v", f, err)
+	}
+}
+
+func BenchmarkDifferUnchanged(b *testing.B) {
+	rect := image.Rect(0, 0, 1920, 1080)
+	img := randomImage(rand.New(rand.NewSource(3)), rect)
+	d := NewDiffer(64)
+	dirty := []image.Rectangle{rect}
+	d.Diff(img, nil, dirty)
+	b.SetBytes(int64(len(img.Pix)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		d.Diff(img, nil, dirty)
+	}
+}
@@ -0,0 +1,6 @@
+// Package dxgi implements a capture.Source using the D3D11 IDXGIOutputDuplication API.
+//
+// The backend registers itself as "dxgi" and is only available on windows.
+//
+//	https://docs.microsoft.com/en-us/windows/win32/api/dxgi1_2/nn-dxgi1_2-idxgioutputduplication
+package dxgi
@@ -0,0 +1,181 @@
+package dxgi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"time"
+
+	"github.com/kbinani/screenshot"
+	"github.com/kirides/screencapture/capture"
+	"github.com/kirides/screencapture/d3d"
+	"github.com/kirides/screencapture/hdr"
+	"github.com/kirides/screencapture/swizzle"
+	"github.com/kirides/screencapture/win"
+)
+
+func init() {
+	capture.Register("dxgi", driver{})
+}
+
+type driver struct{}
+
+func (driver) NumDisplays() int { return screenshot.NumActiveDisplays() }
+func (driver) New(display int) (capture.Source, error) {
+	return New(display), nil
+}
+
+// Source captures a display using IDXGIOutputDuplication.
+type Source struct {
+	// DrawPointer enables drawing the mouse pointer onto the captured image.
+	// The pointer is reported in every Frame either way.
+	DrawPointer bool
+	// TimeoutMs is passed to AcquireNextFrame.
+	TimeoutMs uint
+	// HDR configures the tone mapping of HDR desktops.
+	HDR hdr.Options
+
+	display   int
+	device    *d3d.ID3D11Device
+	deviceCtx *d3d.ID3D11DeviceContext
+	ddup      *d3d.OutputDuplicator
+
+	bounds image.Rectangle
+	frame  capture.Frame
+
+	pointer capture.Pointer
+	// shape is the last pointer shape returned by the duplicator
+	shape *image.RGBA
+}
+
+// New returns an unopened Source for the n-th display.
+func New(display int) *Source {
+	return &Source{display: display}
+}
+
+func (s *Source) Open() error {
+	// Make thread PerMonitorV2 Dpi aware if supported on OS
+	// allows to let windows handle BGRA -> RGBA conversion and possibly more things
+	if win.IsValidDpiAwarenessContext(win.DpiAwarenessContextPerMonitorAwareV2) {
+		_, err := win.SetThreadDpiAwarenessContext(win.DpiAwarenessContextPerMonitorAwareV2)
+		if err != nil {
+			fmt.Printf("Could not set thread DPI awareness to PerMonitorAwareV2. %v\n", err)
+		}
+	}
+
+	device, deviceCtx, err := d3d.NewD3D11Device()
+	if err != nil {
+		return fmt.Errorf("could not create D3D11 Device. %w", err)
+	}
+	s.device, s.deviceCtx = device, deviceCtx
+
+	s.bounds = screenshot.GetDisplayBounds(s.display)
+	s.frame.Image = image.NewRGBA(image.Rect(0, 0, s.bounds.Dx(), s.bounds.Dy()))
+	return nil
+}
+
+func (s *Source) NextFrame(ctx context.Context) (*capture.Frame, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	bounds := screenshot.GetDisplayBounds(s.display)
+	if bounds != s.bounds {
+		s.bounds = bounds
+		s.frame.Image = image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+
+		// Throw away old ddup
+		s.releaseDuplication()
+	}
+	// create output duplication if doesn't exist yet (maybe due to resolution change)
+	if s.ddup == nil {
+		ddup, err := d3d.NewIDXGIOutputDuplication(s.device, s.deviceCtx, uint(s.display))
+		if err != nil {
+			return nil, err
+		}
+		ddup.DrawPointer = s.DrawPointer
+		s.ddup = ddup
+	}
+	s.ddup.HDR = s.HDR
+
+	// Grab an image.RGBA from the current output presenter
+	err := s.ddup.GetImage(s.frame.Image, s.TimeoutMs)
+	if err != nil {
+		if errors.Is(err, d3d.ErrNoImageYet) {
+			return nil, capture.ErrNoImageYet
+		}
+		// Retry with new ddup on the next frame, can occur when changing resolution
+		s.releaseDuplication()
+		return nil, err
+	}
+	info := s.ddup.FrameInfo()
+	s.frame.Seq++
+	s.frame.Time = presentTime(info.LastPresentTime)
+	s.frame.AccumulatedFrames = info.AccumulatedFrames
+	s.frame.RectsCoalesced = info.RectsCoalesced
+	s.frame.ProtectedContentMaskedOut = info.ProtectedContentMaskedOut
+	s.frame.Moves = append(s.frame.Moves[:0], info.Moves...)
+	s.frame.Dirty = append(s.frame.Dirty[:0], info.Dirty...)
+	s.updatePointer(s.ddup.PointerInfo())
+	s.frame.Pointer = &s.pointer
+	return &s.frame, nil
+}
+
+// presentTime converts a QueryPerformanceCounter value to a time.Time.
+// It returns the current time if qpc is unknown.
+func presentTime(qpc int64) time.Time {
+	now := time.Now()
+	var counter, freq int64
+	if qpc == 0 || win.QueryPerformanceCounter(&counter) != nil || win.QueryPerformanceFrequency(&freq) != nil || freq == 0 {
+		return now
+	}
+	elapsed := counter - qpc
+	return now.Add(-time.Duration(elapsed/freq*int64(time.Second) + elapsed%freq*int64(time.Second)/freq))
+}
+
+// SetDrawPointer implements capture.PointerDrawer.
+func (s *Source) SetDrawPointer(enabled bool) {
+	s.DrawPointer = enabled
+	if s.ddup != nil {
+		s.ddup.DrawPointer = enabled
+	}
+}
+
+func (s *Source) updatePointer(info *d3d.PointerInfo) {
+	s.pointer.Position = info.Position()
+	s.pointer.HotSpot = info.HotSpot()
+	s.pointer.Visible = info.Visible()
+	if shape := info.Shape(); shape != s.shape {
+		s.shape = shape
+		s.pointer.Shape = nil
+		if shape != nil {
+			s.pointer.Shape = image.NewRGBA(shape.Rect)
+			copy(s.pointer.Shape.Pix, shape.Pix)
+			if s.ddup.NeedsSwizzle() {
+				swizzle.BGRA(s.pointer.Shape.Pix)
+			}
+		}
+	}
+}
+
+func (s *Source) Bounds() image.Rectangle { return s.bounds }
+
+func (s *Source) Close() error {
+	s.releaseDuplication()
+	if s.deviceCtx != nil {
+		s.deviceCtx.Release()
+		s.deviceCtx = nil
+	}
+	if s.device != nil {
+		s.device.Release()
+		s.device = nil
+	}
+	return nil
+}
+
+func (s *Source) releaseDuplication() {
+	if s.ddup != nil {
+		s.ddup.Release()
+		s.ddup = nil
+	}
+}
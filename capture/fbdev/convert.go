@@ -0,0 +1,72 @@
+package fbdev
+
+import (
+	"image"
+
+	"github.com/kirides/screencapture/parallel"
+	"github.com/kirides/screencapture/swizzle"
+)
+
+// convert converts the framebuffer contents in src into opaque RGBA pixels.
+func convert(img *image.RGBA, src []byte, l Layout) {
+	bytesPerPixel := l.BitsPerPixel / 8
+	rowBytes := l.Width * bytesPerPixel
+	switch {
+	case l.BitsPerPixel == 32 && l.isByteAligned(2, 1, 0):
+		parallel.Default.Copy(swizzle.CopyBGRX, img.Pix, img.Stride, src, l.Stride, image.Rect(0, 0, l.Width, l.Height))
+	case l.BitsPerPixel == 32 && l.isByteAligned(0, 1, 2):
+		for y := 0; y < l.Height; y++ {
+			row := img.Pix[y*img.Stride : y*img.Stride+rowBytes]
+			copy(row, src[y*l.Stride:])
+			fillAlpha(row)
+		}
+	default:
+		convertGeneric(img, src, l)
+	}
+}
+
+// isByteAligned reports whether red, green and blue are 8 bit channels at the given byte positions.
+func (l Layout) isByteAligned(r, g, b uint32) bool {
+	return l.Red == Bitfield{r * 8, 8} && l.Green == Bitfield{g * 8, 8} && l.Blue == Bitfield{b * 8, 8}
+}
+
+func fillAlpha(row []byte) {
+	for i := 3; i < len(row); i += 4 {
+		row[i] = 0xFF
+	}
+}
+
+// convertGeneric extracts the channels of every little-endian pixel using the bitfields.
+func convertGeneric(img *image.RGBA, src []byte, l Layout) {
+	bytesPerPixel := l.BitsPerPixel / 8
+	for y := 0; y < l.Height; y++ {
+		in := src[y*l.Stride:]
+		out := img.Pix[y*img.Stride:]
+		for x := 0; x < l.Width; x++ {
+			var v uint32
+			for i := bytesPerPixel - 1; i >= 0; i-- {
+				v = v<<8 | uint32(in[x*bytesPerPixel+i])
+			}
+			out[x*4+0] = channel(v, l.Red)
+			out[x*4+1] = channel(v, l.Green)
+			out[x*4+2] = channel(v, l.Blue)
+			out[x*4+3] = 0xFF
+		}
+	}
+}
+
+// channel extracts f from v and scales it to 8 bits, replicating the high bits into the low bits.
+func channel(v uint32, f Bitfield) uint8 {
+	if f.Length == 0 {
+		return 0
+	}
+	c := (v >> f.Offset) & (1<<f.Length - 1)
+	if f.Length >= 8 {
+		return uint8(c >> (f.Length - 8))
+	}
+	c <<= 8 - f.Length
+	for shift := f.Length; shift < 8; shift += f.Length {
+		c |= c >> shift
+	}
+	return uint8(c)
+}
@@ -0,0 +1,190 @@
+// Package fbdev implements a capture.Source for the linux framebuffer (e.g. /dev/fb0).
+//
+// The geometry and pixel layout is queried from the device, but can also be given
+// explicitly, which allows capturing from ordinary files, e.g. dumps of a framebuffer.
+// The backend registers itself as "fbdev", display N being /dev/fbN.
+package fbdev
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"os"
+	"time"
+
+	"github.com/kirides/screencapture/capture"
+)
+
+func init() {
+	capture.Register("fbdev", driver{})
+}
+
+type driver struct{}
+
+func (driver) NumDisplays() int {
+	n := 0
+	for ; ; n++ {
+		if _, err := os.Stat(devicePath(n)); err != nil {
+			return n
+		}
+	}
+}
+func (driver) New(display int) (capture.Source, error) {
+	return New(devicePath(display)), nil
+}
+
+func devicePath(n int) string { return fmt.Sprintf("/dev/fb%d", n) }
+
+// Bitfield describes the position of a color channel within a pixel, like fb_bitfield.
+type Bitfield struct {
+	Offset uint32
+	Length uint32
+}
+
+// Layout describes the geometry and pixel format of a framebuffer,
+// mirroring fb_var_screeninfo and fb_fix_screeninfo.
+type Layout struct {
+	Width, Height int // xres, yres
+	// Stride is the number of bytes per line, line_length.
+	Stride       int
+	BitsPerPixel int
+	// Offset is the byte offset of the visible area, e.g. when panning.
+	Offset                  int64
+	Red, Green, Blue, Alpha Bitfield
+}
+
+var (
+	// BGRX8888 is the most common 32 bit layout (XRGB8888 in DRM terms).
+	BGRX8888 = Layout{BitsPerPixel: 32, Red: Bitfield{16, 8}, Green: Bitfield{8, 8}, Blue: Bitfield{0, 8}}
+	RGBX8888 = Layout{BitsPerPixel: 32, Red: Bitfield{0, 8}, Green: Bitfield{8, 8}, Blue: Bitfield{16, 8}}
+	BGR888   = Layout{BitsPerPixel: 24, Red: Bitfield{16, 8}, Green: Bitfield{8, 8}, Blue: Bitfield{0, 8}}
+	RGB565   = Layout{BitsPerPixel: 16, Red: Bitfield{11, 5}, Green: Bitfield{5, 6}, Blue: Bitfield{0, 5}}
+)
+
+// WithSize returns a copy of l for a framebuffer of the given size without line padding.
+func (l Layout) WithSize(width, height int) Layout {
+	l.Width, l.Height = width, height
+	l.Stride = width * l.BitsPerPixel / 8
+	return l
+}
+
+func (l Layout) validate() error {
+	switch l.BitsPerPixel {
+	case 16, 24, 32:
+	default:
+		return fmt.Errorf("unsupported bits per pixel: %d", l.BitsPerPixel)
+	}
+	if l.Width <= 0 || l.Height <= 0 {
+		return fmt.Errorf("invalid size %dx%d", l.Width, l.Height)
+	}
+	if l.Stride < l.Width*l.BitsPerPixel/8 {
+		return fmt.Errorf("stride %d too small for %d pixels", l.Stride, l.Width)
+	}
+	for _, f := range []Bitfield{l.Red, l.Green, l.Blue, l.Alpha} {
+		if f.Length > 16 || f.Offset+f.Length > uint32(l.BitsPerPixel) {
+			return fmt.Errorf("invalid bitfield %+v for %d bits per pixel", f, l.BitsPerPixel)
+		}
+	}
+	return nil
+}
+
+// size returns the number of bytes of the visible area.
+func (l Layout) size() int {
+	return (l.Height-1)*l.Stride + l.Width*l.BitsPerPixel/8
+}
+
+// Source captures a framebuffer device or a file containing framebuffer contents.
+type Source struct {
+	path     string
+	explicit *Layout
+
+	f      *os.File
+	layout Layout
+	buf    []byte
+	frame  capture.Frame
+}
+
+// New returns an unopened Source, its layout is queried from the device at path.
+func New(path string) *Source {
+	return &Source{path: path}
+}
+
+// NewWithLayout returns an unopened Source reading path using the given layout.
+// path does not have to be a framebuffer device.
+func NewWithLayout(path string, layout Layout) *Source {
+	return &Source{path: path, explicit: &layout}
+}
+
+func (s *Source) Open() error {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return err
+	}
+	s.f = f
+	if err := s.updateLayout(); err != nil {
+		s.Close()
+		return err
+	}
+	return nil
+}
+
+func (s *Source) updateLayout() error {
+	layout := Layout{}
+	if s.explicit != nil {
+		layout = *s.explicit
+	} else {
+		var err error
+		if layout, err = queryLayout(s.f); err != nil {
+			return err
+		}
+	}
+	if err := layout.validate(); err != nil {
+		return err
+	}
+	if layout.Width != s.layout.Width || layout.Height != s.layout.Height || s.frame.Image == nil {
+		s.frame.Image = image.NewRGBA(image.Rect(0, 0, layout.Width, layout.Height))
+	}
+	if n := layout.size(); cap(s.buf) < n {
+		s.buf = make([]byte, n)
+	}
+	s.buf = s.buf[:layout.size()]
+	s.layout = layout
+	return nil
+}
+
+func (s *Source) NextFrame(ctx context.Context) (*capture.Frame, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if s.f == nil {
+		return nil, errors.New("source is not opened")
+	}
+	if s.explicit == nil {
+		// the resolution or panning might have changed
+		if err := s.updateLayout(); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := s.f.ReadAt(s.buf, s.layout.Offset); err != nil {
+		return nil, fmt.Errorf("failed to read framebuffer. %w", err)
+	}
+	convert(s.frame.Image, s.buf, s.layout)
+	s.frame.Dirty = append(s.frame.Dirty[:0], s.frame.Image.Rect)
+	s.frame.Seq++
+	s.frame.Time = time.Now()
+	return &s.frame, nil
+}
+
+func (s *Source) Bounds() image.Rectangle {
+	return image.Rect(0, 0, s.layout.Width, s.layout.Height)
+}
+
+func (s *Source) Close() error {
+	if s.f == nil {
+		return nil
+	}
+	err := s.f.Close()
+	s.f = nil
+	return err
+}
@@ -0,0 +1,146 @@
+package fbdev
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var testColors = []color.RGBA{
+	{0xFF, 0x00, 0x00, 0xFF},
+	{0x00, 0xFF, 0x00, 0xFF},
+	{0x00, 0x00, 0xFF, 0xFF},
+	{0xFF, 0xFF, 0xFF, 0xFF},
+	{0x00, 0x00, 0x00, 0xFF},
+	{0x84, 0x41, 0x10, 0xFF},
+}
+
+// encode writes c as a little-endian pixel of layout l.
+func encode(c color.RGBA, l Layout) []byte {
+	scale := func(v uint8, f Bitfield) uint32 {
+		return ((uint32(v)*(1<<f.Length-1) + 0x7F) / 0xFF) << f.Offset
+	}
+	v := scale(c.R, l.Red) | scale(c.G, l.Green) | scale(c.B, l.Blue)
+	out := make([]byte, l.BitsPerPixel/8)
+	for i := range out {
+		out[i] = uint8(v >> (8 * uint(i)))
+	}
+	return out
+}
+
+func writeFramebuffer(t *testing.T, l Layout, padding int) string {
+	t.Helper()
+	var data []byte
+	data = append(data, make([]byte, l.Offset)...)
+	for y := 0; y < l.Height; y++ {
+		for x := 0; x < l.Width; x++ {
+			data = append(data, encode(testColors[(x+y)%len(testColors)], l)...)
+		}
+		data = append(data, make([]byte, padding)...)
+	}
+	path := filepath.Join(t.TempDir(), "fb")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLayouts(t *testing.T) {
+	tests := []struct {
+		name    string
+		layout  Layout
+		padding int
+		offset  int64
+	}{
+		{"BGRX8888", BGRX8888, 0, 0},
+		{"RGBX8888 padded", RGBX8888, 8, 0},
+		{"BGR888 padded", BGR888, 3, 0},
+		{"RGB565 panned", RGB565, 2, 64},
+		{"XBGR2101010", Layout{BitsPerPixel: 32, Red: Bitfield{0, 10}, Green: Bitfield{10, 10}, Blue: Bitfield{20, 10}}, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			const w, h = 7, 5
+			l := tt.layout.WithSize(w, h)
+			l.Stride += tt.padding
+			l.Offset = tt.offset
+			path := writeFramebuffer(t, l, tt.padding)
+
+			src := NewWithLayout(path, l)
+			if err := src.Open(); err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			defer src.Close()
+			if got, want := src.Bounds(), image.Rect(0, 0, w, h); got != want {
+				t.Errorf("Bounds() = %v, want %v", got, want)
+			}
+			frame, err := src.NextFrame(context.Background())
+			if err != nil {
+				t.Fatalf("NextFrame: %v", err)
+			}
+			for y := 0; y < h; y++ {
+				for x := 0; x < w; x++ {
+					want := testColors[(x+y)%len(testColors)]
+					if l.Red.Length < 8 {
+						// only compare the significant bits
+						got := frame.Image.RGBAAt(x, y)
+						if got.R>>3 != want.R>>3 || got.G>>2 != want.G>>2 || got.B>>3 != want.B>>3 || got.A != 0xFF {
+							t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, got, want)
+						}
+						continue
+					}
+					if got := frame.Image.RGBAAt(x, y); got != want {
+						t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestChannelScaling(t *testing.T) {
+	tests := []struct {
+		v    uint32
+		f    Bitfield
+		want uint8
+	}{
+		{0x1F, Bitfield{0, 5}, 0xFF},
+		{0x10, Bitfield{0, 5}, 0x84},
+		{0x3F << 5, Bitfield{5, 6}, 0xFF},
+		{0x3FF, Bitfield{0, 10}, 0xFF},
+		{0x200, Bitfield{0, 10}, 0x80},
+		{0x1, Bitfield{0, 1}, 0xFF},
+		{0xFF, Bitfield{0, 0}, 0x00},
+	}
+	for _, tt := range tests {
+		if got := channel(tt.v, tt.f); got != tt.want {
+			t.Errorf("channel(%#x, %+v) = %#02x, want %#02x", tt.v, tt.f, got, tt.want)
+		}
+	}
+}
+
+func TestInvalidLayout(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fb")
+	if err := os.WriteFile(path, make([]byte, 64), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range []Layout{
+		BGRX8888.WithSize(0, 4),
+		Layout{BitsPerPixel: 8}.WithSize(4, 4),
+		{Width: 4, Height: 4, Stride: 8, BitsPerPixel: 32},
+		BGRX8888.WithSize(64, 64), // larger than the file
+	} {
+		src := NewWithLayout(path, l)
+		err := src.Open()
+		if err == nil {
+			_, err = src.NextFrame(context.Background())
+			src.Close()
+		}
+		if err == nil {
+			t.Errorf("layout %+v: got nil error", l)
+		}
+	}
+}
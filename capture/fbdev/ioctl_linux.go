@@ -0,0 +1,92 @@
+package fbdev
+
+import (
+	"fmt"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	_FBIOGET_VSCREENINFO = 0x4600
+	_FBIOGET_FSCREENINFO = 0x4602
+)
+
+type fb_bitfield struct {
+	Offset   uint32
+	Length   uint32
+	MsbRight uint32
+}
+
+type fb_var_screeninfo struct {
+	Xres, Yres               uint32
+	XresVirtual, YresVirtual uint32
+	Xoffset, Yoffset         uint32
+	BitsPerPixel             uint32
+	Grayscale                uint32
+	Red, Green, Blue, Transp fb_bitfield
+	Nonstd                   uint32
+	Activate                 uint32
+	Height, Width            uint32
+	AccelFlags               uint32
+	Pixclock                 uint32
+	LeftMargin, RightMargin  uint32
+	UpperMargin, LowerMargin uint32
+	HsyncLen, VsyncLen       uint32
+	Sync, Vmode, Rotate      uint32
+	Colorspace               uint32
+	Reserved                 [4]uint32
+}
+
+type fb_fix_screeninfo struct {
+	ID           [16]byte
+	SmemStart    uintptr // unsigned long
+	SmemLen      uint32
+	Type         uint32
+	TypeAux      uint32
+	Visual       uint32
+	Xpanstep     uint16
+	Ypanstep     uint16
+	Ywrapstep    uint16
+	LineLength   uint32
+	MmioStart    uintptr // unsigned long
+	MmioLen      uint32
+	Accel        uint32
+	Capabilities uint16
+	Reserved     [2]uint16
+}
+
+func ioctl(f *os.File, req uintptr, arg unsafe.Pointer) error {
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, f.Fd(), req, uintptr(arg))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// queryLayout reads the layout of a framebuffer device.
+func queryLayout(f *os.File) (Layout, error) {
+	var vinfo fb_var_screeninfo
+	if err := ioctl(f, _FBIOGET_VSCREENINFO, unsafe.Pointer(&vinfo)); err != nil {
+		return Layout{}, fmt.Errorf("failed to FBIOGET_VSCREENINFO. %w", err)
+	}
+	var finfo fb_fix_screeninfo
+	if err := ioctl(f, _FBIOGET_FSCREENINFO, unsafe.Pointer(&finfo)); err != nil {
+		return Layout{}, fmt.Errorf("failed to FBIOGET_FSCREENINFO. %w", err)
+	}
+	if vinfo.Grayscale != 0 || vinfo.Red.MsbRight != 0 || vinfo.Green.MsbRight != 0 || vinfo.Blue.MsbRight != 0 {
+		return Layout{}, fmt.Errorf("unsupported framebuffer format")
+	}
+	return Layout{
+		Width:        int(vinfo.Xres),
+		Height:       int(vinfo.Yres),
+		Stride:       int(finfo.LineLength),
+		BitsPerPixel: int(vinfo.BitsPerPixel),
+		Offset:       int64(vinfo.Yoffset)*int64(finfo.LineLength) + int64(vinfo.Xoffset)*int64(vinfo.BitsPerPixel/8),
+		Red:          Bitfield{vinfo.Red.Offset, vinfo.Red.Length},
+		Green:        Bitfield{vinfo.Green.Offset, vinfo.Green.Length},
+		Blue:         Bitfield{vinfo.Blue.Offset, vinfo.Blue.Length},
+		Alpha:        Bitfield{vinfo.Transp.Offset, vinfo.Transp.Length},
+	}, nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package fbdev
+
+import (
+	"errors"
+	"os"
+)
+
+func queryLayout(f *os.File) (Layout, error) {
+	return Layout{}, errors.New("framebuffer devices are only supported on linux, use NewWithLayout")
+}
@@ -0,0 +1,4 @@
+// Package gdi implements a capture.Source using GDI BitBlt.
+//
+// The backend registers itself as "gdi" and is only available on windows.
+package gdi
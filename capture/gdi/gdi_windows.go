@@ -0,0 +1,72 @@
+package gdi
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"time"
+
+	"github.com/kbinani/screenshot"
+	"github.com/kirides/screencapture/capture"
+	forkscreenshot "github.com/kirides/screencapture/screenshot"
+)
+
+func init() {
+	capture.Register("gdi", driver{})
+}
+
+type driver struct{}
+
+func (driver) NumDisplays() int { return screenshot.NumActiveDisplays() }
+func (driver) New(display int) (capture.Source, error) {
+	return New(display), nil
+}
+
+// Source captures a display using "github.com/kbinani/screenshot" (modified to reuse image.RGBA)
+type Source struct {
+	display int
+	bounds  image.Rectangle
+	frame   capture.Frame
+}
+
+// New returns an unopened Source for the n-th display.
+func New(display int) *Source {
+	return &Source{display: display}
+}
+
+func (s *Source) Open() error {
+	if s.display >= screenshot.NumActiveDisplays() {
+		return fmt.Errorf("display %d not available", s.display)
+	}
+	s.bounds = screenshot.GetDisplayBounds(s.display)
+	s.frame.Image = image.NewRGBA(image.Rect(0, 0, s.bounds.Dx(), s.bounds.Dy()))
+	return nil
+}
+
+func (s *Source) NextFrame(ctx context.Context) (*capture.Frame, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	bounds := screenshot.GetDisplayBounds(s.display)
+	if bounds.Size() != s.bounds.Size() {
+		s.frame.Image = image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	}
+	s.bounds = bounds
+
+	err := forkscreenshot.CaptureImg(s.frame.Image, bounds.Min.X, bounds.Min.Y, bounds.Dx(), bounds.Dy())
+	if err != nil {
+		return nil, fmt.Errorf("failed to CaptureImg. %w", err)
+	}
+	// GDI has no damage information
+	s.frame.Dirty = append(s.frame.Dirty[:0], s.frame.Image.Rect)
+	s.frame.Seq++
+	s.frame.Time = time.Now()
+	return &s.frame, nil
+}
+
+func (s *Source) Bounds() image.Rectangle { return s.bounds }
+
+func (s *Source) Close() error {
+	s.frame.Image = nil
+	return nil
+}
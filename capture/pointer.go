@@ -0,0 +1,39 @@
+package capture
+
+import (
+	"image"
+	"image/draw"
+)
+
+// Pointer describes the mouse pointer of a display.
+type Pointer struct {
+	// Position is the top-left corner of Shape, relative to the captured image.
+	Position image.Point
+	// HotSpot is the position within Shape that points at the target.
+	HotSpot image.Point
+	Visible bool
+	// Shape holds the alpha-premultiplied pointer image.
+	// It is owned by the Source and only replaced when the pointer shape changes.
+	Shape *image.RGBA
+}
+
+// Rect returns the region of the image covered by the pointer.
+func (p *Pointer) Rect() image.Rectangle {
+	if p == nil || p.Shape == nil {
+		return image.Rectangle{}
+	}
+	return image.Rectangle{Min: p.Position, Max: p.Position.Add(p.Shape.Rect.Size())}
+}
+
+// DrawPointer composites the pointer onto img, if it is visible.
+func DrawPointer(img *image.RGBA, p *Pointer) {
+	if p == nil || !p.Visible || p.Shape == nil {
+		return
+	}
+	draw.Draw(img, p.Rect(), p.Shape, p.Shape.Rect.Min, draw.Over)
+}
+
+// PointerDrawer is implemented by Sources that can draw the mouse pointer onto the captured image.
+type PointerDrawer interface {
+	SetDrawPointer(enabled bool)
+}
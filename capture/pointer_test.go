@@ -0,0 +1,42 @@
+package capture
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestDrawPointer(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	for i := range img.Pix {
+		img.Pix[i] = 0xFF
+	}
+	shape := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	shape.SetRGBA(0, 0, color.RGBA{0x00, 0x00, 0x00, 0xFF}) // opaque black
+	shape.SetRGBA(1, 0, color.RGBA{0x00, 0x00, 0x00, 0x80}) // half transparent black
+	p := &Pointer{Position: image.Pt(3, 3), Visible: true, Shape: shape}
+
+	if got, want := p.Rect(), image.Rect(3, 3, 5, 5); got != want {
+		t.Errorf("Rect() = %v, want %v", got, want)
+	}
+	DrawPointer(img, p)
+	if got := img.RGBAAt(3, 3); got != (color.RGBA{0x00, 0x00, 0x00, 0xFF}) {
+		t.Errorf("pixel at hotspot = %v, want black", got)
+	}
+
+	p.Position = image.Pt(0, 0)
+	DrawPointer(img, p)
+	if got := img.RGBAAt(1, 0); got != (color.RGBA{0x7F, 0x7F, 0x7F, 0xFF}) {
+		t.Errorf("half transparent pixel = %v, want gray", got)
+	}
+	if got := img.RGBAAt(0, 1); got != (color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}) {
+		t.Errorf("transparent pixel = %v, want white", got)
+	}
+
+	p.Visible = false
+	p.Position = image.Pt(2, 2)
+	DrawPointer(img, p)
+	if got := img.RGBAAt(2, 2); got != (color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}) {
+		t.Errorf("invisible pointer was drawn")
+	}
+}
@@ -0,0 +1,69 @@
+package capture
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Driver creates Sources for a specific capturing backend.
+type Driver interface {
+	// NumDisplays returns the number of displays the backend can capture.
+	NumDisplays() int
+	// New returns a Source for the n-th display. The Source still has to be opened.
+	New(display int) (Source, error)
+}
+
+var (
+	driversMu sync.RWMutex
+	drivers   = make(map[string]Driver)
+)
+
+// Register makes a capturing backend available by the provided name.
+// If Register is called twice with the same name or if driver is nil, it panics.
+func Register(name string, driver Driver) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+	if driver == nil {
+		panic("capture: Register driver is nil")
+	}
+	if _, dup := drivers[name]; dup {
+		panic("capture: Register called twice for driver " + name)
+	}
+	drivers[name] = driver
+}
+
+// Drivers returns a sorted list of the names of the registered backends.
+func Drivers() []string {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+	list := make([]string, 0, len(drivers))
+	for name := range drivers {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// Lookup returns the backend registered under name.
+func Lookup(name string) (Driver, error) {
+	driversMu.RLock()
+	driver, ok := drivers[name]
+	driversMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("capture: unknown driver %q (forgotten import?)", name)
+	}
+	return driver, nil
+}
+
+// New returns an unopened Source of the named backend for the n-th display.
+func New(name string, display int) (Source, error) {
+	driver, err := Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	if display < 0 || display >= driver.NumDisplays() {
+		return nil, fmt.Errorf("capture: display %d not available for driver %q", display, name)
+	}
+	return driver.New(display)
+}
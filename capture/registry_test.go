@@ -0,0 +1,94 @@
+package capture
+
+import (
+	"context"
+	"errors"
+	"image"
+	"testing"
+)
+
+type fakeDriver struct {
+	displays int
+}
+
+func (d fakeDriver) NumDisplays() int { return d.displays }
+func (d fakeDriver) New(display int) (Source, error) {
+	return &fakeSource{bounds: image.Rect(display*640, 0, display*640+640, 480)}, nil
+}
+
+type fakeSource struct {
+	bounds image.Rectangle
+	frame  Frame
+	frames int
+}
+
+func (s *fakeSource) Open() error {
+	s.frame.Image = image.NewRGBA(image.Rect(0, 0, s.bounds.Dx(), s.bounds.Dy()))
+	return nil
+}
+func (s *fakeSource) NextFrame(ctx context.Context) (*Frame, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.frames++
+	if s.frames%2 == 0 {
+		return nil, ErrNoImageYet
+	}
+	return &s.frame, nil
+}
+func (s *fakeSource) Bounds() image.Rectangle { return s.bounds }
+func (s *fakeSource) Close() error            { return nil }
+
+func TestRegistry(t *testing.T) {
+	Register("fake-registry", fakeDriver{displays: 2})
+
+	found := false
+	for _, name := range Drivers() {
+		if name == "fake-registry" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("Drivers() = %v, missing %q", Drivers(), "fake-registry")
+	}
+
+	if _, err := New("does-not-exist", 0); err == nil {
+		t.Errorf("New with unknown driver: got nil error")
+	}
+	if _, err := New("fake-registry", 2); err == nil {
+		t.Errorf("New with out of range display: got nil error")
+	}
+
+	src, err := New("fake-registry", 1)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if err := src.Open(); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer src.Close()
+
+	if got, want := src.Bounds(), image.Rect(640, 0, 1280, 480); got != want {
+		t.Errorf("Bounds() = %v, want %v", got, want)
+	}
+	frame, err := src.NextFrame(context.Background())
+	if err != nil {
+		t.Fatalf("NextFrame: %v", err)
+	}
+	if got, want := frame.Image.Bounds(), image.Rect(0, 0, 640, 480); got != want {
+		t.Errorf("frame bounds = %v, want %v", got, want)
+	}
+	if _, err := src.NextFrame(context.Background()); !errors.Is(err, ErrNoImageYet) {
+		t.Errorf("second NextFrame: got %v, want ErrNoImageYet", err)
+	}
+}
+
+func TestRegisterTwicePanics(t *testing.T) {
+	Register("fake-twice", fakeDriver{})
+	defer func() {
+		if recover() == nil {
+			t.Errorf("registering a driver twice did not panic")
+		}
+	}()
+	Register("fake-twice", fakeDriver{})
+}
@@ -0,0 +1,45 @@
+package synthetic
+
+import (
+	"image"
+	"image/color"
+)
+
+const (
+	cursorWidth  = 11
+	cursorHeight = 16
+)
+
+// cursorPixel returns whether the pixel belongs to the arrow and whether it is part of its outline.
+func cursorPixel(x, y int) (inside, outline bool) {
+	if y < 12 {
+		// the triangular head of the arrow
+		if x > y {
+			return false, false
+		}
+		return true, x == 0 || x == y || y == 11
+	}
+	// the tail
+	if x < 4 || x > 7 {
+		return false, false
+	}
+	return true, x == 4 || x == 7 || y == cursorHeight-1
+}
+
+func drawCursor(img *image.RGBA, at image.Point) {
+	black := color.RGBA{0x00, 0x00, 0x00, 0xFF}
+	white := color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}
+	for y := 0; y < cursorHeight; y++ {
+		for x := 0; x < cursorWidth; x++ {
+			inside, outline := cursorPixel(x, y)
+			if !inside {
+				continue
+			}
+			if outline {
+				img.SetRGBA(at.X+x, at.Y+y, black)
+			} else {
+				img.SetRGBA(at.X+x, at.Y+y, white)
+			}
+		}
+	}
+}
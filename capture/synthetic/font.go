@@ -0,0 +1,67 @@
+package synthetic
+
+// 5x7 bitmap font, one byte per column, least significant bit at the top.
+var font5x7 = map[rune][5]byte{
+	' ': {0x00, 0x00, 0x00, 0x00, 0x00},
+	'.': {0x00, 0x60, 0x60, 0x00, 0x00},
+	'-': {0x08, 0x08, 0x08, 0x08, 0x08},
+	':': {0x00, 0x36, 0x36, 0x00, 0x00},
+	'0': {0x3E, 0x51, 0x49, 0x45, 0x3E},
+	'1': {0x00, 0x42, 0x7F, 0x40, 0x00},
+	'2': {0x42, 0x61, 0x51, 0x49, 0x46},
+	'3': {0x21, 0x41, 0x45, 0x4B, 0x31},
+	'4': {0x18, 0x14, 0x12, 0x7F, 0x10},
+	'5': {0x27, 0x45, 0x45, 0x45, 0x39},
+	'6': {0x3C, 0x4A, 0x49, 0x49, 0x30},
+	'7': {0x01, 0x71, 0x09, 0x05, 0x03},
+	'8': {0x36, 0x49, 0x49, 0x49, 0x36},
+	'9': {0x06, 0x49, 0x49, 0x29, 0x1E},
+	'A': {0x7E, 0x11, 0x11, 0x11, 0x7E},
+	'B': {0x7F, 0x49, 0x49, 0x49, 0x36},
+	'C': {0x3E, 0x41, 0x41, 0x41, 0x22},
+	'D': {0x7F, 0x41, 0x41, 0x22, 0x1C},
+	'E': {0x7F, 0x49, 0x49, 0x49, 0x41},
+	'F': {0x7F, 0x09, 0x09, 0x01, 0x01},
+	'G': {0x3E, 0x41, 0x41, 0x51, 0x32},
+	'H': {0x7F, 0x08, 0x08, 0x08, 0x7F},
+	'I': {0x00, 0x41, 0x7F, 0x41, 0x00},
+	'J': {0x20, 0x40, 0x41, 0x3F, 0x01},
+	'K': {0x7F, 0x08, 0x14, 0x22, 0x41},
+	'L': {0x7F, 0x40, 0x40, 0x40, 0x40},
+	'M': {0x7F, 0x02, 0x04, 0x02, 0x7F},
+	'N': {0x7F, 0x04, 0x08, 0x10, 0x7F},
+	'O': {0x3E, 0x41, 0x41, 0x41, 0x3E},
+	'P': {0x7F, 0x09, 0x09, 0x09, 0x06},
+	'Q': {0x3E, 0x41, 0x51, 0x21, 0x5E},
+	'R': {0x7F, 0x09, 0x19, 0x29, 0x46},
+	'S': {0x46, 0x49, 0x49, 0x49, 0x31},
+	'T': {0x01, 0x01, 0x7F, 0x01, 0x01},
+	'U': {0x3F, 0x40, 0x40, 0x40, 0x3F},
+	'V': {0x1F, 0x20, 0x40, 0x20, 0x1F},
+	'W': {0x7F, 0x20, 0x18, 0x20, 0x7F},
+	'X': {0x63, 0x14, 0x08, 0x14, 0x63},
+	'Y': {0x03, 0x04, 0x78, 0x04, 0x03},
+	'Z': {0x61, 0x51, 0x49, 0x45, 0x43},
+}
+
+const (
+	glyphWidth   = 5
+	glyphHeight  = 7
+	glyphAdvance = glyphWidth + 1
+)
+
+// glyphPixel reports whether the pixel at column x, row y of r is set.
+// Lower case letters are drawn as upper case, unknown runes as blanks.
+func glyphPixel(r rune, x, y int) bool {
+	if r >= 'a' && r <= 'z' {
+		r -= 'a' - 'A'
+	}
+	if x < 0 || x >= glyphWidth || y < 0 || y >= glyphHeight {
+		return false
+	}
+	g, ok := font5x7[r]
+	if !ok {
+		return false
+	}
+	return g[x]&(1<<uint(y)) != 0
+}
@@ -0,0 +1,393 @@
+// Package synthetic implements a capture.Source that renders an animated test pattern.
+//
+// It does not need any display, which makes it useful for developing and testing
+// the streaming and encoding pipeline on headless machines.
+// The backend registers itself as "synthetic" using DefaultConfig.
+//
+// Every frame reports realistic damage information: the moving bars and the cursor
+// produce dirty rectangles, the ticking clock a dirty rectangle once per second and
+// the scrolling text a move rectangle plus a dirty strip for the newly exposed text.
+package synthetic
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"time"
+
+	"github.com/kirides/screencapture/capture"
+)
+
+func init() {
+	capture.Register("synthetic", NewDriver(DefaultConfig))
+}
+
+// Content selects the animated elements of the test pattern.
+type Content uint
+
+const (
+	Bars Content = 1 << iota
+	Clock
+	ScrollText
+	Cursor
+
+	All = Bars | Clock | ScrollText | Cursor
+)
+
+// Config configures the generated test pattern.
+// Zero values are replaced by the values of DefaultConfig.
+type Config struct {
+	Width, Height int
+	// Displays is the number of displays reported by the driver.
+	Displays int
+	Content  Content
+	// Interval is the simulated time between two frames, it drives the clock.
+	Interval time.Duration
+	// Start is the simulated time of the first frame. Defaults to the time of Open.
+	Start time.Time
+	// ScrollSpeed is the number of pixels the text scrolls per frame.
+	ScrollSpeed int
+	Text        string
+}
+
+var DefaultConfig = Config{
+	Width:       1280,
+	Height:      720,
+	Displays:    2,
+	Content:     All,
+	Interval:    time.Second / 15,
+	ScrollSpeed: 4,
+	Text:        "THE QUICK BROWN FOX JUMPS OVER THE LAZY DOG - 0123456789 - ",
+}
+
+func (c Config) withDefaults() Config {
+	if c.Width <= 0 || c.Height <= 0 {
+		c.Width, c.Height = DefaultConfig.Width, DefaultConfig.Height
+	}
+	if c.Displays <= 0 {
+		c.Displays = DefaultConfig.Displays
+	}
+	if c.Content == 0 {
+		c.Content = DefaultConfig.Content
+	}
+	if c.Interval <= 0 {
+		c.Interval = DefaultConfig.Interval
+	}
+	if c.ScrollSpeed <= 0 {
+		c.ScrollSpeed = DefaultConfig.ScrollSpeed
+	}
+	if c.Text == "" {
+		c.Text = DefaultConfig.Text
+	}
+	return c
+}
+
+type driver struct {
+	cfg Config
+}
+
+// NewDriver returns a capture.Driver creating Sources using cfg.
+func NewDriver(cfg Config) capture.Driver {
+	return driver{cfg: cfg.withDefaults()}
+}
+
+func (d driver) NumDisplays() int { return d.cfg.Displays }
+func (d driver) New(display int) (capture.Source, error) {
+	return New(display, d.cfg), nil
+}
+
+type bar struct {
+	rect  image.Rectangle
+	speed int
+	phase int
+	color color.RGBA
+}
+
+// Source renders the test pattern of a single display.
+type Source struct {
+	cfg     Config
+	display int
+	bounds  image.Rectangle
+
+	// scene holds the test pattern without the cursor
+	scene *image.RGBA
+	frame capture.Frame
+	n     int
+	start time.Time
+
+	barBand    image.Rectangle
+	bars       []bar
+	clockRect  image.Rectangle
+	clockScale int
+	lastClock  string
+	tickerBand image.Rectangle
+	textScale  int
+	scrolled   int
+	cursorRect image.Rectangle
+}
+
+// New returns an unopened Source for the n-th display.
+func New(display int, cfg Config) *Source {
+	cfg = cfg.withDefaults()
+	return &Source{
+		cfg:     cfg,
+		display: display,
+		bounds:  image.Rect(display*cfg.Width, 0, (display+1)*cfg.Width, cfg.Height),
+	}
+}
+
+func (s *Source) Open() error {
+	w, h := s.cfg.Width, s.cfg.Height
+	if s.cfg.ScrollSpeed >= w {
+		return fmt.Errorf("scroll speed %d exceeds width %d", s.cfg.ScrollSpeed, w)
+	}
+	r := image.Rect(0, 0, w, h)
+	s.scene = image.NewRGBA(r)
+	s.frame.Image = image.NewRGBA(r)
+	s.n = 0
+	s.scrolled = 0
+	s.lastClock = ""
+	s.cursorRect = image.Rectangle{}
+	s.start = s.cfg.Start
+	if s.start.IsZero() {
+		s.start = time.Now()
+	}
+
+	s.barBand = image.Rect(0, 0, w, h/4)
+	barWidth := maxInt(2, w/32)
+	s.bars = s.bars[:0]
+	colors := []color.RGBA{{0xE0, 0x30, 0x30, 0xFF}, {0x30, 0xE0, 0x30, 0xFF}, {0x30, 0x60, 0xE0, 0xFF}}
+	for i, c := range colors {
+		s.bars = append(s.bars, bar{
+			speed: (i + 1) * maxInt(1, w/320),
+			phase: (s.display*7 + i*13) * barWidth,
+			color: c,
+			rect:  image.Rect(0, s.barBand.Min.Y, barWidth, s.barBand.Max.Y),
+		})
+	}
+
+	s.textScale = maxInt(1, h/180)
+	s.tickerBand = image.Rect(0, h-(glyphHeight+4)*s.textScale, w, h)
+
+	s.clockScale = maxInt(1, h/90)
+	cw, ch := 8*glyphAdvance*s.clockScale, glyphHeight*s.clockScale
+	s.clockRect = image.Rect((w-cw)/2, (h-ch)/2, (w-cw)/2+cw, (h-ch)/2+ch).Intersect(r)
+
+	// static background, slightly different per display
+	for y := 0; y < h; y++ {
+		c := color.RGBA{uint8(0x20 + 0x40*y/h), uint8(0x20 + s.display*0x30), uint8(0x60 - 0x40*y/h), 0xFF}
+		fill(s.scene, image.Rect(0, y, w, y+1), c)
+	}
+	fill(s.scene, s.barBand, color.RGBA{0x18, 0x18, 0x18, 0xFF})
+	fill(s.scene, s.tickerBand, color.RGBA{0x00, 0x00, 0x00, 0xFF})
+	return nil
+}
+
+func (s *Source) NextFrame(ctx context.Context) (*capture.Frame, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if s.scene == nil {
+		return nil, fmt.Errorf("source is not opened")
+	}
+	first := s.n == 0
+	now := s.start.Add(time.Duration(s.n) * s.cfg.Interval)
+
+	s.frame.Moves = s.frame.Moves[:0]
+	s.frame.Dirty = s.frame.Dirty[:0]
+
+	if s.cfg.Content&ScrollText != 0 {
+		s.updateTicker(first)
+	}
+	if s.cfg.Content&Bars != 0 {
+		s.updateBars()
+	}
+	if s.cfg.Content&Clock != 0 {
+		s.updateClock(now)
+	}
+	if s.cfg.Content&Cursor != 0 {
+		s.updateCursor()
+	}
+	s.n++
+
+	img := s.frame.Image
+	if first {
+		s.frame.Moves = s.frame.Moves[:0]
+		s.frame.Dirty = append(s.frame.Dirty[:0], img.Rect)
+	}
+	if len(s.frame.Dirty) == 0 && len(s.frame.Moves) == 0 {
+		return nil, capture.ErrNoImageYet
+	}
+
+	for _, m := range s.frame.Moves {
+		copyRect(img, s.scene, m.Dst)
+	}
+	for _, r := range s.frame.Dirty {
+		copyRect(img, s.scene, r)
+	}
+	if s.cfg.Content&Cursor != 0 {
+		drawCursor(img, s.cursorRect.Min)
+	}
+	s.frame.Seq++
+	s.frame.Time = now
+	s.frame.AccumulatedFrames = 1
+	return &s.frame, nil
+}
+
+func (s *Source) Bounds() image.Rectangle { return s.bounds }
+
+func (s *Source) Close() error {
+	s.scene = nil
+	s.frame.Image = nil
+	return nil
+}
+
+func (s *Source) addDirty(r image.Rectangle) {
+	r = r.Intersect(s.frame.Image.Rect)
+	if !r.Empty() {
+		s.frame.Dirty = append(s.frame.Dirty, r)
+	}
+}
+
+func (s *Source) updateBars() {
+	bg := color.RGBA{0x18, 0x18, 0x18, 0xFF}
+	for i := range s.bars {
+		fill(s.scene, s.bars[i].rect, bg)
+	}
+	for i := range s.bars {
+		b := &s.bars[i]
+		old := b.rect
+		travel := s.barBand.Dx() - old.Dx()
+		x := 0
+		if travel > 0 {
+			t := (b.phase + s.n*b.speed) % (2 * travel)
+			if t > travel {
+				t = 2*travel - t
+			}
+			x = t
+		}
+		b.rect = image.Rect(x, s.barBand.Min.Y, x+old.Dx(), s.barBand.Max.Y)
+		if b.rect != old {
+			s.addDirty(old.Union(b.rect))
+		}
+	}
+	for i := range s.bars {
+		fill(s.scene, s.bars[i].rect, s.bars[i].color)
+	}
+}
+
+func (s *Source) updateClock(now time.Time) {
+	text := now.Format("15:04:05")
+	if text == s.lastClock {
+		return
+	}
+	s.lastClock = text
+	r := s.clockRect
+	fill(s.scene, r, color.RGBA{0x10, 0x10, 0x10, 0xFF})
+	drawText(s.scene, r, text, 0, s.clockScale, color.RGBA{0xFF, 0xD0, 0x40, 0xFF})
+	s.addDirty(r)
+}
+
+func (s *Source) updateTicker(first bool) {
+	band := s.tickerBand
+	if first {
+		drawText(s.scene, band, s.cfg.Text, 0, s.textScale, color.RGBA{0xF0, 0xF0, 0xF0, 0xFF})
+		return
+	}
+	speed := s.cfg.ScrollSpeed
+	s.scrolled += speed
+
+	// scroll the scene, then draw the newly exposed strip
+	for y := band.Min.Y; y < band.Max.Y; y++ {
+		row := s.scene.Pix[s.scene.PixOffset(band.Min.X, y) : s.scene.PixOffset(band.Max.X-1, y)+4]
+		copy(row, row[speed*4:])
+	}
+	strip := image.Rect(band.Max.X-speed, band.Min.Y, band.Max.X, band.Max.Y)
+	fill(s.scene, strip, color.RGBA{0x00, 0x00, 0x00, 0xFF})
+	drawText(s.scene, strip, s.cfg.Text, s.scrolled+strip.Min.X-band.Min.X, s.textScale, color.RGBA{0xF0, 0xF0, 0xF0, 0xFF})
+
+	s.frame.Moves = append(s.frame.Moves, capture.Move{
+		Src: image.Pt(band.Min.X+speed, band.Min.Y),
+		Dst: image.Rect(band.Min.X, band.Min.Y, band.Max.X-speed, band.Max.Y),
+	})
+	s.addDirty(strip)
+}
+
+func (s *Source) updateCursor() {
+	w, h := s.cfg.Width, s.cfg.Height
+	f := float64(s.n)
+	x := w/2 + int(float64(w/2-cursorWidth)*math.Sin(f*0.031+float64(s.display)))
+	y := h/2 + int(float64(h/2-cursorHeight)*math.Sin(f*0.047))
+	old := s.cursorRect
+	s.cursorRect = image.Rect(x, y, x+cursorWidth, y+cursorHeight)
+	if old == s.cursorRect {
+		return
+	}
+	if old.Overlaps(s.cursorRect) {
+		s.addDirty(old.Union(s.cursorRect))
+	} else {
+		s.addDirty(old)
+		s.addDirty(s.cursorRect)
+	}
+	// moved regions carry the previously drawn cursor along with them
+	for _, m := range s.frame.Moves {
+		src := image.Rectangle{Min: m.Src, Max: m.Src.Add(m.Dst.Size())}
+		moved := old.Intersect(src)
+		if !moved.Empty() {
+			s.addDirty(moved.Add(m.Dst.Min.Sub(m.Src)).Intersect(m.Dst))
+		}
+	}
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func fill(img *image.RGBA, r image.Rectangle, c color.RGBA) {
+	r = r.Intersect(img.Rect)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		i := img.PixOffset(r.Min.X, y)
+		for x := r.Min.X; x < r.Max.X; x++ {
+			img.Pix[i+0], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = c.R, c.G, c.B, c.A
+			i += 4
+		}
+	}
+}
+
+func copyRect(dst, src *image.RGBA, r image.Rectangle) {
+	r = r.Intersect(dst.Rect).Intersect(src.Rect)
+	if r.Empty() {
+		return
+	}
+	n := r.Dx() * 4
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		copy(dst.Pix[dst.PixOffset(r.Min.X, y):][:n], src.Pix[src.PixOffset(r.Min.X, y):][:n])
+	}
+}
+
+// drawText draws the pixels of text that fall into r, with r.Min.X showing text column offset.
+// The text repeats endlessly to the right.
+func drawText(img *image.RGBA, r image.Rectangle, text string, offset, scale int, c color.RGBA) {
+	runes := []rune(text)
+	if len(runes) == 0 {
+		return
+	}
+	// text is vertically centered within r
+	top := r.Min.Y + (r.Dy()-glyphHeight*scale)/2
+	r = r.Intersect(img.Rect)
+	for x := r.Min.X; x < r.Max.X; x++ {
+		col := (offset + x - r.Min.X) / scale
+		ch := runes[(col/glyphAdvance)%len(runes)]
+		gx := col % glyphAdvance
+		for y := maxInt(r.Min.Y, top); y < r.Max.Y; y++ {
+			if glyphPixel(ch, gx, (y-top)/scale) {
+				img.SetRGBA(x, y, c)
+			}
+		}
+	}
+}
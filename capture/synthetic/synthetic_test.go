@@ -0,0 +1,111 @@
+package synthetic
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"testing"
+	"time"
+
+	"github.com/kirides/screencapture/capture"
+)
+
+// applyDamage updates prev to the state of frame, using only its moves and dirty regions.
+func applyDamage(prev *image.RGBA, frame *capture.Frame) {
+	for _, m := range frame.Moves {
+		tmp := image.NewRGBA(m.Dst)
+		for y := 0; y < m.Dst.Dy(); y++ {
+			for x := 0; x < m.Dst.Dx(); x++ {
+				tmp.SetRGBA(m.Dst.Min.X+x, m.Dst.Min.Y+y, prev.RGBAAt(m.Src.X+x, m.Src.Y+y))
+			}
+		}
+		copyRect(prev, tmp, m.Dst)
+	}
+	for _, r := range frame.Dirty {
+		copyRect(prev, frame.Image, r)
+	}
+}
+
+func TestDamageReconstructsFrames(t *testing.T) {
+	cfg := Config{Width: 320, Height: 200, Start: time.Date(2021, 11, 1, 12, 0, 0, 0, time.UTC), Interval: time.Second / 4}
+	src := New(1, cfg)
+	if err := src.Open(); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer src.Close()
+
+	if got, want := src.Bounds(), image.Rect(320, 0, 640, 200); got != want {
+		t.Errorf("Bounds() = %v, want %v", got, want)
+	}
+
+	var prev *image.RGBA
+	sawMoves := false
+	for i := 0; i < 200; i++ {
+		frame, err := src.NextFrame(context.Background())
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		if i == 0 {
+			if len(frame.Dirty) != 1 || frame.Dirty[0] != frame.Image.Rect {
+				t.Fatalf("first frame: got dirty %v, want the whole image", frame.Dirty)
+			}
+			prev = image.NewRGBA(frame.Image.Rect)
+		}
+		sawMoves = sawMoves || len(frame.Moves) > 0
+		applyDamage(prev, frame)
+		if !bytes.Equal(prev.Pix, frame.Image.Pix) {
+			t.Fatalf("frame %d: applying moves %v and dirty regions %v did not produce the frame", i, frame.Moves, frame.Dirty)
+		}
+	}
+	if !sawMoves {
+		t.Errorf("scrolling text did not produce any moves")
+	}
+}
+
+func TestNoImageYetWithoutChanges(t *testing.T) {
+	cfg := Config{Width: 320, Height: 200, Content: Clock, Start: time.Date(2021, 11, 1, 12, 0, 0, 0, time.UTC), Interval: time.Second / 10}
+	src := New(0, cfg)
+	if err := src.Open(); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer src.Close()
+
+	updates := 0
+	for i := 0; i < 30; i++ {
+		frame, err := src.NextFrame(context.Background())
+		if errors.Is(err, capture.ErrNoImageYet) {
+			continue
+		}
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		updates++
+		if i > 0 && (len(frame.Dirty) != 1 || frame.Dirty[0] != src.clockRect) {
+			t.Errorf("frame %d: got dirty %v, want clock %v", i, frame.Dirty, src.clockRect)
+		}
+	}
+	// 30 frames at 10 fps span 3 seconds
+	if updates != 3 {
+		t.Errorf("got %d updates, want 3", updates)
+	}
+}
+
+func TestDriverRegistered(t *testing.T) {
+	src, err := capture.New("synthetic", DefaultConfig.Displays-1)
+	if err != nil {
+		t.Fatalf("capture.New: %v", err)
+	}
+	if err := src.Open(); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer src.Close()
+	if _, err := src.NextFrame(context.Background()); err != nil {
+		t.Fatalf("NextFrame: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := src.NextFrame(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("NextFrame with canceled context: got %v", err)
+	}
+}
@@ -0,0 +1,18 @@
+package x11
+
+import "image"
+
+// cursorShape converts the alpha-premultiplied ARGB cursor image of XFixes into an image.RGBA.
+func cursorShape(argb []uint32, width, height int) *image.RGBA {
+	shape := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		row := shape.Pix[y*shape.Stride:]
+		for x, v := range argb[y*width : (y+1)*width] {
+			row[x*4+0] = uint8(v >> 16)
+			row[x*4+1] = uint8(v >> 8)
+			row[x*4+2] = uint8(v)
+			row[x*4+3] = uint8(v >> 24)
+		}
+	}
+	return shape
+}
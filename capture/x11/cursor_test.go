@@ -0,0 +1,23 @@
+package x11
+
+import (
+	"image/color"
+	"testing"
+)
+
+func TestCursorShape(t *testing.T) {
+	argb := []uint32{
+		0xFF102030, 0x00000000,
+		0x80400000, 0xFFFFFFFF,
+	}
+	shape := cursorShape(argb, 2, 2)
+	want := []color.RGBA{
+		{0x10, 0x20, 0x30, 0xFF}, {0x00, 0x00, 0x00, 0x00},
+		{0x40, 0x00, 0x00, 0x80}, {0xFF, 0xFF, 0xFF, 0xFF},
+	}
+	for i, c := range want {
+		if got := shape.RGBAAt(i%2, i/2); got != c {
+			t.Errorf("pixel %d: got %v, want %v", i, got, c)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package x11
+
+import (
+	"image"
+
+	"github.com/jezek/xgb/xproto"
+)
+
+// maxDamageRects limits the number of GetImage requests per frame,
+// more rectangles are merged into their bounding box.
+const maxDamageRects = 32
+
+// damagedRects clips the damaged rectangles, given in root window coordinates, to monitor
+// and appends them to dst in image coordinates. It reports whether they were merged.
+func damagedRects(dst []image.Rectangle, damage []xproto.Rectangle, monitor image.Rectangle) ([]image.Rectangle, bool) {
+	start := len(dst)
+	var bbox image.Rectangle
+	for _, d := range damage {
+		r := image.Rect(int(d.X), int(d.Y), int(d.X)+int(d.Width), int(d.Y)+int(d.Height))
+		r = r.Intersect(monitor).Sub(monitor.Min)
+		if r.Empty() {
+			continue
+		}
+		dst = append(dst, r)
+		bbox = bbox.Union(r)
+	}
+	if len(dst)-start > maxDamageRects {
+		return append(dst[:start], bbox), true
+	}
+	return dst, false
+}
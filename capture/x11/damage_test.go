@@ -0,0 +1,41 @@
+package x11
+
+import (
+	"image"
+	"reflect"
+	"testing"
+
+	"github.com/jezek/xgb/xproto"
+)
+
+func TestDamagedRects(t *testing.T) {
+	monitor := image.Rect(1920, 0, 3840, 1080)
+	damage := []xproto.Rectangle{
+		{X: 0, Y: 0, Width: 100, Height: 100},       // other monitor
+		{X: 1900, Y: 10, Width: 40, Height: 20},     // crosses both monitors
+		{X: 2000, Y: 500, Width: 10, Height: 10},    // inside
+		{X: 3800, Y: 1070, Width: 100, Height: 100}, // bottom right corner
+	}
+	got, coalesced := damagedRects(nil, damage, monitor)
+	want := []image.Rectangle{
+		image.Rect(0, 10, 20, 30),
+		image.Rect(80, 500, 90, 510),
+		image.Rect(1880, 1070, 1920, 1080),
+	}
+	if !reflect.DeepEqual(got, want) || coalesced {
+		t.Errorf("got %v, %v, want %v", got, coalesced, want)
+	}
+
+	damage = damage[:0]
+	for i := 0; i < maxDamageRects+1; i++ {
+		damage = append(damage, xproto.Rectangle{X: int16(1920 + i*10), Y: int16(i), Width: 5, Height: 5})
+	}
+	got, coalesced = damagedRects(got[:1], damage, monitor)
+	want = []image.Rectangle{
+		image.Rect(0, 10, 20, 30),
+		image.Rect(0, 0, maxDamageRects*10+5, maxDamageRects+5),
+	}
+	if !reflect.DeepEqual(got, want) || !coalesced {
+		t.Errorf("too many rectangles: got %v, %v, want %v", got, coalesced, want)
+	}
+}
@@ -0,0 +1,5 @@
+// Package x11 implements a capture.Source for X11 desktops using MIT-SHM.
+//
+// Every RandR monitor is captured as its own display, the primary monitor first.
+// The backend registers itself as "x11" and is only available on linux.
+package x11
@@ -0,0 +1,97 @@
+package x11
+
+import (
+	"fmt"
+	"image"
+
+	"github.com/jezek/xgb"
+	"github.com/jezek/xgb/randr"
+	"github.com/jezek/xgb/xproto"
+)
+
+// Monitor is an active RandR output.
+type Monitor struct {
+	Name    string
+	Primary bool
+	// Bounds are relative to the top-left corner of the primary monitor,
+	// like screenshot.GetDisplayBounds.
+	Bounds image.Rectangle
+
+	// root holds the position within the root window
+	root image.Rectangle
+}
+
+// Monitors returns the active monitors of the X server in $DISPLAY, the primary monitor first.
+func Monitors() ([]Monitor, error) {
+	c, err := xgb.NewConn()
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	return monitors(c, xproto.Setup(c).DefaultScreen(c))
+}
+
+func monitors(c *xgb.Conn, screen *xproto.ScreenInfo) ([]Monitor, error) {
+	whole := []Monitor{{
+		Name:    "screen",
+		Primary: true,
+		Bounds:  image.Rect(0, 0, int(screen.WidthInPixels), int(screen.HeightInPixels)),
+		root:    image.Rect(0, 0, int(screen.WidthInPixels), int(screen.HeightInPixels)),
+	}}
+	if err := randr.Init(c); err != nil {
+		// no RandR, treat the whole screen as a single monitor
+		return whole, nil
+	}
+	if _, err := randr.QueryVersion(c, 1, 3).Reply(); err != nil {
+		return nil, fmt.Errorf("failed to randr.QueryVersion. %w", err)
+	}
+
+	res, err := randr.GetScreenResourcesCurrent(c, screen.Root).Reply()
+	if err != nil {
+		return nil, fmt.Errorf("failed to randr.GetScreenResourcesCurrent. %w", err)
+	}
+	var primaryOutput randr.Output
+	if primary, err := randr.GetOutputPrimary(c, screen.Root).Reply(); err == nil {
+		primaryOutput = primary.Output
+	}
+
+	var list []Monitor
+	seen := make(map[randr.Crtc]bool)
+	for _, output := range res.Outputs {
+		info, err := randr.GetOutputInfo(c, output, res.ConfigTimestamp).Reply()
+		if err != nil {
+			return nil, fmt.Errorf("failed to randr.GetOutputInfo. %w", err)
+		}
+		if info.Connection != randr.ConnectionConnected || info.Crtc == 0 || seen[info.Crtc] {
+			// mirrored outputs share their crtc
+			continue
+		}
+		seen[info.Crtc] = true
+		crtc, err := randr.GetCrtcInfo(c, info.Crtc, res.ConfigTimestamp).Reply()
+		if err != nil {
+			return nil, fmt.Errorf("failed to randr.GetCrtcInfo. %w", err)
+		}
+		if crtc.Mode == 0 || crtc.Width == 0 || crtc.Height == 0 {
+			continue
+		}
+		m := Monitor{
+			Name:    string(info.Name),
+			Primary: output == primaryOutput,
+			root:    image.Rect(int(crtc.X), int(crtc.Y), int(crtc.X)+int(crtc.Width), int(crtc.Y)+int(crtc.Height)),
+		}
+		if m.Primary {
+			list = append([]Monitor{m}, list...)
+		} else {
+			list = append(list, m)
+		}
+	}
+	if len(list) == 0 {
+		return whole, nil
+	}
+	list[0].Primary = true
+	origin := list[0].root.Min
+	for i := range list {
+		list[i].Bounds = list[i].root.Sub(origin)
+	}
+	return list, nil
+}
@@ -0,0 +1,13 @@
+package x11
+
+import (
+	"image"
+
+	"github.com/kirides/screencapture/parallel"
+	"github.com/kirides/screencapture/swizzle"
+)
+
+// copyBGRX copies tightly packed 32 bit BGRX pixels into img and makes them opaque.
+func copyBGRX(img *image.RGBA, src []byte) {
+	parallel.Default.Copy(swizzle.CopyBGRX, img.Pix, img.Stride, src, img.Rect.Dx()*4, img.Rect.Sub(img.Rect.Min))
+}
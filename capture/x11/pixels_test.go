@@ -0,0 +1,24 @@
+package x11
+
+import (
+	"image"
+	"testing"
+)
+
+func TestCopyBGRX(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	// Stride of a sub image is larger than its width
+	sub := img.SubImage(image.Rect(0, 0, 1, 2)).(*image.RGBA)
+	src := []byte{
+		0x01, 0x02, 0x03, 0x00,
+		0x11, 0x12, 0x13, 0x7F,
+	}
+	copyBGRX(sub, src)
+	want := []byte{
+		0x03, 0x02, 0x01, 0xFF, 0x00, 0x00, 0x00, 0x00,
+		0x13, 0x12, 0x11, 0xFF, 0x00, 0x00, 0x00, 0x00,
+	}
+	if string(img.Pix) != string(want) {
+		t.Errorf("got % x, want % x", img.Pix, want)
+	}
+}
@@ -0,0 +1,424 @@
+package x11
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"time"
+
+	"github.com/gen2brain/shm"
+	"github.com/jezek/xgb"
+	"github.com/jezek/xgb/damage"
+	"github.com/jezek/xgb/randr"
+	mshm "github.com/jezek/xgb/shm"
+	"github.com/jezek/xgb/xfixes"
+	"github.com/jezek/xgb/xproto"
+
+	"github.com/kirides/screencapture/capture"
+)
+
+func init() {
+	capture.Register("x11", driver{})
+}
+
+type driver struct{}
+
+func (driver) NumDisplays() int {
+	list, err := Monitors()
+	if err != nil {
+		return 0
+	}
+	return len(list)
+}
+func (driver) New(display int) (capture.Source, error) {
+	return New(display), nil
+}
+
+// Source captures a RandR monitor using XShmGetImage.
+// It falls back to plain GetImage if MIT-SHM is not available, e.g. on remote X servers.
+//
+// If the X server supports the DAMAGE extension, only the damaged regions are read
+// and reported as dirty, and NextFrame returns capture.ErrNoImageYet while nothing changes.
+//
+// The mouse pointer is reported using XFixes, and composited onto the image if DrawPointer is set.
+type Source struct {
+	// DrawPointer enables drawing the mouse pointer onto the captured image.
+	DrawPointer bool
+
+	display int
+	conn    *xgb.Conn
+	screen  *xproto.ScreenInfo
+	monitor Monitor
+	// monitorsChanged is set by RandR notifications
+	monitorsChanged bool
+
+	useShm  bool
+	shmData []byte
+	shmSeg  mshm.Seg
+	// re-used between frames
+	shmCookies []mshm.GetImageCookie
+	cookies    []xproto.GetImageCookie
+
+	useXFixes bool
+	pointer   capture.Pointer
+	// cursorChanged is set by XFixes cursor notifications
+	cursorChanged bool
+	// drawnPointer is the region the pointer was drawn to in the previous frame
+	drawnPointer image.Rectangle
+
+	useDamage bool
+	damage    damage.Damage
+	// damageRegion receives the damaged region on every frame
+	damageRegion xfixes.Region
+	// damaged is set by damage notifications
+	damaged bool
+	// fullFrame requests reading the whole monitor on the next frame
+	fullFrame bool
+
+	frame capture.Frame
+}
+
+// New returns an unopened Source for the n-th monitor.
+func New(display int) *Source {
+	return &Source{display: display}
+}
+
+func (s *Source) Open() error {
+	c, err := xgb.NewConn()
+	if err != nil {
+		return fmt.Errorf("failed to connect to X server. %w", err)
+	}
+	s.conn = c
+	s.screen = xproto.Setup(c).DefaultScreen(c)
+	if err := checkPixmapFormat(c, s.screen); err != nil {
+		s.Close()
+		return err
+	}
+	if err := s.updateMonitor(); err != nil {
+		s.Close()
+		return err
+	}
+	if randr.Init(c) == nil {
+		randr.SelectInput(c, s.screen.Root, randr.NotifyMaskScreenChange|randr.NotifyMaskCrtcChange)
+	}
+	s.useShm = mshm.Init(c) == nil
+	s.useXFixes = s.initXFixes() == nil
+	s.useDamage = s.useXFixes && s.initDamage() == nil
+	s.fullFrame = true
+	s.cursorChanged = true
+	s.drawnPointer = image.Rectangle{}
+	return nil
+}
+
+// SetDrawPointer implements capture.PointerDrawer.
+func (s *Source) SetDrawPointer(enabled bool) { s.DrawPointer = enabled }
+
+func (s *Source) initXFixes() error {
+	c := s.conn
+	if err := xfixes.Init(c); err != nil {
+		return err
+	}
+	if _, err := xfixes.QueryVersion(c, 2, 0).Reply(); err != nil {
+		return err
+	}
+	return xfixes.SelectCursorInputChecked(c, s.screen.Root, xfixes.CursorNotifyMaskDisplayCursor).Check()
+}
+
+func (s *Source) initDamage() error {
+	c := s.conn
+	if err := damage.Init(c); err != nil {
+		return err
+	}
+	if _, err := damage.QueryVersion(c, 1, 1).Reply(); err != nil {
+		return err
+	}
+	var err error
+	if s.damage, err = damage.NewDamageId(c); err != nil {
+		return err
+	}
+	if err = damage.CreateChecked(c, s.damage, xproto.Drawable(s.screen.Root), damage.ReportLevelNonEmpty).Check(); err != nil {
+		return err
+	}
+	if s.damageRegion, err = xfixes.NewRegionId(c); err != nil {
+		return err
+	}
+	return xfixes.CreateRegionChecked(c, s.damageRegion, nil).Check()
+}
+
+// checkPixmapFormat makes sure that ZPixmap images use 32 bits per pixel in BGRX order.
+func checkPixmapFormat(c *xgb.Conn, screen *xproto.ScreenInfo) error {
+	setup := xproto.Setup(c)
+	if setup.ImageByteOrder != xproto.ImageOrderLSBFirst {
+		return errors.New("unsupported image byte order")
+	}
+	for _, f := range setup.PixmapFormats {
+		if f.Depth == screen.RootDepth {
+			if f.BitsPerPixel != 32 {
+				return fmt.Errorf("unsupported pixel format: depth %d with %d bits per pixel", f.Depth, f.BitsPerPixel)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("no pixmap format for depth %d", screen.RootDepth)
+}
+
+func (s *Source) updateMonitor() error {
+	list, err := monitors(s.conn, s.screen)
+	if err != nil {
+		return err
+	}
+	if s.display < 0 || s.display >= len(list) {
+		return fmt.Errorf("monitor %d not available", s.display)
+	}
+	s.monitor = list[s.display]
+	s.monitorsChanged = false
+	s.fullFrame = true
+	size := s.monitor.root.Size()
+	if s.frame.Image == nil || s.frame.Image.Rect.Size() != size {
+		s.frame.Image = image.NewRGBA(image.Rectangle{Max: size})
+		s.detachShm()
+	}
+	return nil
+}
+
+func (s *Source) attachShm() error {
+	size := len(s.frame.Image.Pix)
+	id, err := shm.Get(shm.IPC_PRIVATE, size, shm.IPC_CREAT|0600)
+	if err != nil {
+		return fmt.Errorf("failed to shmget. %w", err)
+	}
+	data, err := shm.At(id, 0, 0)
+	if err != nil {
+		shm.Rm(id)
+		return fmt.Errorf("failed to shmat. %w", err)
+	}
+	seg, err := mshm.NewSegId(s.conn)
+	if err != nil {
+		shm.Dt(data)
+		shm.Rm(id)
+		return err
+	}
+	if err := mshm.AttachChecked(s.conn, seg, uint32(id), false).Check(); err != nil {
+		shm.Dt(data)
+		shm.Rm(id)
+		return fmt.Errorf("failed to shm.Attach. %w", err)
+	}
+	// the segment is destroyed as soon as both, we and the X server, detached
+	shm.Rm(id)
+	s.shmData, s.shmSeg = data, seg
+	return nil
+}
+
+func (s *Source) detachShm() {
+	if s.shmData == nil {
+		return
+	}
+	mshm.Detach(s.conn, s.shmSeg)
+	shm.Dt(s.shmData)
+	s.shmData = nil
+}
+
+func (s *Source) pollEvents() {
+	for {
+		ev, _ := s.conn.PollForEvent()
+		if ev == nil {
+			return
+		}
+		switch ev.(type) {
+		case randr.ScreenChangeNotifyEvent, randr.NotifyEvent:
+			s.monitorsChanged = true
+		case damage.NotifyEvent:
+			s.damaged = true
+		case xfixes.CursorNotifyEvent:
+			s.cursorChanged = true
+		}
+	}
+}
+
+func (s *Source) NextFrame(ctx context.Context) (*capture.Frame, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if s.conn == nil {
+		return nil, errors.New("source is not opened")
+	}
+	s.pollEvents()
+	if s.monitorsChanged {
+		if err := s.updateMonitor(); err != nil {
+			return nil, err
+		}
+	}
+	if s.useShm && s.shmData == nil {
+		if err := s.attachShm(); err != nil {
+			s.useShm = false
+		}
+	}
+
+	img := s.frame.Image
+	s.frame.RectsCoalesced = false
+	if s.fullFrame || !s.useDamage {
+		if s.useDamage {
+			// throw away the damage accumulated until now
+			damage.Subtract(s.conn, s.damage, 0, 0)
+		}
+		s.fullFrame = false
+		s.damaged = false
+		s.frame.Dirty = append(s.frame.Dirty[:0], img.Rect)
+	} else {
+		s.frame.Dirty = s.frame.Dirty[:0]
+		if s.damaged {
+			s.damaged = false
+			// move the damage into our region, anything drawn afterwards triggers a new notification
+			damage.Subtract(s.conn, s.damage, 0, s.damageRegion)
+			reply, err := xfixes.FetchRegion(s.conn, s.damageRegion).Reply()
+			if err != nil {
+				return nil, fmt.Errorf("failed to xfixes.FetchRegion. %w", err)
+			}
+			s.frame.Dirty, s.frame.RectsCoalesced = damagedRects(s.frame.Dirty, reply.Rectangles, s.monitor.root)
+		}
+	}
+
+	s.frame.Pointer = nil
+	if s.useXFixes {
+		pointerChanged, err := s.updatePointer()
+		if err != nil {
+			return nil, err
+		}
+		s.frame.Pointer = &s.pointer
+		if s.DrawPointer && (pointerChanged || len(s.frame.Dirty) != 0) {
+			// restore the pixels below the previously drawn pointer, and make sure
+			// the pointer is never blended twice onto the same pixels
+			s.addDirty(s.drawnPointer)
+			if s.pointer.Visible {
+				s.addDirty(s.pointer.Rect())
+			}
+		}
+	}
+	if len(s.frame.Dirty) == 0 {
+		return nil, capture.ErrNoImageYet
+	}
+
+	if err := s.readRects(img, s.frame.Dirty); err != nil {
+		return nil, err
+	}
+	s.drawnPointer = image.Rectangle{}
+	if s.DrawPointer && s.frame.Pointer != nil && s.pointer.Visible {
+		capture.DrawPointer(img, &s.pointer)
+		s.drawnPointer = s.pointer.Rect().Intersect(img.Rect)
+	}
+	s.frame.Seq++
+	s.frame.Time = time.Now()
+	return &s.frame, nil
+}
+
+func (s *Source) addDirty(r image.Rectangle) {
+	r = r.Intersect(s.frame.Image.Rect)
+	if r.Empty() {
+		return
+	}
+	for _, d := range s.frame.Dirty {
+		if r.In(d) {
+			return
+		}
+	}
+	s.frame.Dirty = append(s.frame.Dirty, r)
+}
+
+// updatePointer updates the pointer position and shape, and reports whether it changed.
+func (s *Source) updatePointer() (bool, error) {
+	old := s.pointer
+	changed := s.cursorChanged
+	if s.cursorChanged {
+		s.cursorChanged = false
+		reply, err := xfixes.GetCursorImage(s.conn).Reply()
+		if err != nil {
+			return false, fmt.Errorf("failed to xfixes.GetCursorImage. %w", err)
+		}
+		s.pointer.Shape = cursorShape(reply.CursorImage, int(reply.Width), int(reply.Height))
+		s.pointer.HotSpot = image.Pt(int(reply.Xhot), int(reply.Yhot))
+	}
+	reply, err := xproto.QueryPointer(s.conn, s.screen.Root).Reply()
+	if err != nil {
+		return false, fmt.Errorf("failed to QueryPointer. %w", err)
+	}
+	at := image.Pt(int(reply.RootX), int(reply.RootY))
+	s.pointer.Visible = reply.SameScreen && at.In(s.monitor.root)
+	s.pointer.Position = at.Sub(s.monitor.root.Min).Sub(s.pointer.HotSpot)
+
+	changed = changed || old.Position != s.pointer.Position || old.Visible != s.pointer.Visible
+	return changed, nil
+}
+
+func (s *Source) copyShmReplies(img *image.RGBA, rects []image.Rectangle, cookies []mshm.GetImageCookie) error {
+	offset := 0
+	for i, r := range rects {
+		if _, err := cookies[i].Reply(); err != nil {
+			return fmt.Errorf("failed to shm.GetImage. %w", err)
+		}
+		copyBGRX(img.SubImage(r).(*image.RGBA), s.shmData[offset:])
+		offset += r.Dx() * r.Dy() * 4
+	}
+	return nil
+}
+
+// readRects reads the given regions of the monitor into img.
+// All requests are sent before waiting for the first reply.
+func (s *Source) readRects(img *image.RGBA, rects []image.Rectangle) error {
+	root := xproto.Drawable(s.screen.Root)
+	origin := s.monitor.root.Min
+	if s.useShm {
+		// requests are sent in batches, as overlapping regions could exceed the segment
+		cookies := s.shmCookies[:0]
+		batch := rects
+		offset := 0
+		for i, r := range rects {
+			size := r.Dx() * r.Dy() * 4
+			if offset+size > len(s.shmData) {
+				if err := s.copyShmReplies(img, batch[:len(cookies)], cookies); err != nil {
+					return err
+				}
+				cookies, batch, offset = cookies[:0], rects[i:], 0
+			}
+			x, y := r.Min.Add(origin).X, r.Min.Add(origin).Y
+			cookies = append(cookies, mshm.GetImage(s.conn, root,
+				int16(x), int16(y), uint16(r.Dx()), uint16(r.Dy()), 0xffffffff,
+				xproto.ImageFormatZPixmap, s.shmSeg, uint32(offset)))
+			offset += size
+		}
+		s.shmCookies = cookies
+		return s.copyShmReplies(img, batch, cookies)
+	}
+
+	cookies := s.cookies[:0]
+	for _, r := range rects {
+		x, y := r.Min.Add(origin).X, r.Min.Add(origin).Y
+		cookies = append(cookies, xproto.GetImage(s.conn, xproto.ImageFormatZPixmap, root,
+			int16(x), int16(y), uint16(r.Dx()), uint16(r.Dy()), 0xffffffff))
+	}
+	s.cookies = cookies
+	for i, r := range rects {
+		reply, err := cookies[i].Reply()
+		if err != nil {
+			return fmt.Errorf("failed to GetImage. %w", err)
+		}
+		copyBGRX(img.SubImage(r).(*image.RGBA), reply.Data)
+	}
+	return nil
+}
+
+func (s *Source) Bounds() image.Rectangle { return s.monitor.Bounds }
+
+func (s *Source) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	s.detachShm()
+	if s.useDamage {
+		damage.Destroy(s.conn, s.damage)
+		xfixes.DestroyRegion(s.conn, s.damageRegion)
+	}
+	s.conn.Close()
+	s.conn = nil
+	return nil
+}
@@ -0,0 +1,93 @@
+package x11
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jezek/xgb/xproto"
+	"github.com/kirides/screencapture/capture"
+)
+
+// Run against Xvfb, e.g.
+//
+//	Xvfb :99 -screen 0 1280x720x24 & DISPLAY=:99 go test ./capture/x11/
+func TestCaptureXvfb(t *testing.T) {
+	if os.Getenv("DISPLAY") == "" {
+		t.Skip("DISPLAY is not set")
+	}
+	list, err := Monitors()
+	if err != nil {
+		t.Fatalf("Monitors: %v", err)
+	}
+	if len(list) == 0 || !list[0].Primary || list[0].Bounds.Min.X != 0 || list[0].Bounds.Min.Y != 0 {
+		t.Fatalf("Monitors: got %+v, want the primary monitor at (0, 0) first", list)
+	}
+
+	src := New(0)
+	if err := src.Open(); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer src.Close()
+	if got, want := src.Bounds(), list[0].Bounds; got != want {
+		t.Errorf("Bounds() = %v, want %v", got, want)
+	}
+
+	frame, err := src.NextFrame(context.Background())
+	if err != nil {
+		t.Fatalf("NextFrame: %v", err)
+	}
+	if frame.Image.Rect.Size() != list[0].Bounds.Size() {
+		t.Fatalf("image size %v, want %v", frame.Image.Rect.Size(), list[0].Bounds.Size())
+	}
+	if frame.Image.Pix[3] != 0xFF {
+		t.Errorf("alpha is %#02x, want 0xff", frame.Image.Pix[3])
+	}
+	pix := &frame.Image.Pix[0]
+	if !src.useDamage {
+		return
+	}
+
+	// draw onto the root window to cause damage
+	c := src.conn
+	gc, err := xproto.NewGcontextId(c)
+	if err != nil {
+		t.Fatalf("NewGcontextId: %v", err)
+	}
+	root := xproto.Drawable(src.screen.Root)
+	xproto.CreateGC(c, gc, root, xproto.GcForeground, []uint32{0x00FF0000})
+	origin := src.monitor.root.Min
+	xproto.PolyFillRectangle(c, root, gc, []xproto.Rectangle{{X: int16(origin.X + 10), Y: int16(origin.Y + 20), Width: 30, Height: 40}})
+	xproto.FreeGC(c, gc)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		frame, err = src.NextFrame(context.Background())
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, capture.ErrNoImageYet) || time.Now().After(deadline) {
+			t.Fatalf("NextFrame after drawing: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// the image is re-used between frames
+	if pix != &frame.Image.Pix[0] {
+		t.Errorf("image was re-allocated")
+	}
+	drawn := image.Rect(10, 20, 40, 60)
+	covered := false
+	for _, r := range frame.Dirty {
+		covered = covered || drawn.In(r)
+	}
+	if !covered {
+		t.Errorf("dirty regions %v do not cover %v", frame.Dirty, drawn)
+	}
+	if got := frame.Image.RGBAAt(20, 30); got != (color.RGBA{0xFF, 0x00, 0x00, 0xFF}) {
+		t.Errorf("pixel at (20, 30) = %v, want red", got)
+	}
+}
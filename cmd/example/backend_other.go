@@ -0,0 +1,7 @@
+//go:build !windows && !linux
+// +build !windows,!linux
+
+package main
+
+// there is no native backend, yet
+const defaultBackend = "synthetic"
@@ -1,19 +0,0 @@
-// +build jpegturbo
-//go:build jpegturbo
-
-package main
-
-import (
-	"image"
-	"io"
-
-	jpegturbo "github.com/pixiv/go-libjpeg/jpeg"
-)
-
-func jpegQuality(q int) *jpegturbo.EncoderOptions {
-	return &jpegturbo.EncoderOptions{Quality: q}
-}
-
-func encodeJpeg(w io.Writer, src image.Image, opts *jpegturbo.EncoderOptions) {
-	jpegturbo.Encode(w, src, opts)
-}
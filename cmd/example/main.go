@@ -1,9 +1,9 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"errors"
+	"flag"
 	"fmt"
 	"image"
 	"net/http"
@@ -13,20 +13,81 @@ import (
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
-	"github.com/kirides/screencapture/d3d"
-	forkscreenshot "github.com/kirides/screencapture/screenshot"
-	"github.com/kirides/screencapture/win"
-	"github.com/nfnt/resize"
-
-	"github.com/kbinani/screenshot"
-	"github.com/mattn/go-mjpeg"
+	"github.com/kirides/screencapture/capture"
+	_ "github.com/kirides/screencapture/capture/dxgi"
+	_ "github.com/kirides/screencapture/capture/fbdev"
+	_ "github.com/kirides/screencapture/capture/gdi"
+	_ "github.com/kirides/screencapture/capture/synthetic"
+	_ "github.com/kirides/screencapture/capture/x11"
+	"github.com/kirides/screencapture/encode"
+	"github.com/kirides/screencapture/scale"
 )
 
 func main() {
-	n := screenshot.NumActiveDisplays()
+	backend := flag.String("backend", defaultBackend, "capture backend, one of: "+strings.Join(capture.Drivers(), ", "))
+	record := flag.Bool("record", false, "record all screens into screen_N.mp4 using ffmpeg instead of streaming")
+	drawPointer := flag.Bool("pointer", false, "draw the mouse pointer, if supported by the backend")
+	diffTile := flag.Int("diff", 64, "tile size for skipping unchanged frames, 0 disables it")
+	sizeFlag := flag.String("size", "", "serve the streams scaled to WxH, e.g. 1280x720, instead of the size of the display")
+	format := flag.String("format", defaultFormat(), "image format of the streams, one of: "+strings.Join(encode.Formats(), ", "))
+	quality := flag.Int("quality", 50, "quality of lossy image formats, from 1 to 100")
+	subsampling := flag.String("subsampling", "420", "chroma subsampling of JPEG images, 420, 422 or 444 for sharp colored text")
+	quant := flag.String("quant", "photo", "quantization tables of JPEG images, photo or screen for sharper text and UI")
+	tiles := flag.Bool("tiles", false, "stream the changed tiles of the frames, text in -tile-format and photos in -format, instead of whole images")
+	tileFormat := flag.String("tile-format", "png", "lossless image format of text tiles, png or webp for smaller tiles")
+	recordFormat := flag.String("record-format", rawVideo, "format of the frames piped into ffmpeg, "+rawVideo+" or an image format")
+	flag.Parse()
+
+	encodeOpts := encode.Options{Quality: *quality}
+	switch *subsampling {
+	case "420":
+		encodeOpts.Subsampling = encode.Subsample420
+	case "422":
+		encodeOpts.Subsampling = encode.Subsample422
+	case "444":
+		encodeOpts.Subsampling = encode.Subsample444
+	default:
+		fmt.Fprintf(os.Stderr, "invalid subsampling %q\n", *subsampling)
+		os.Exit(1)
+	}
+	switch *quant {
+	case "photo":
+	case "screen":
+		encodeOpts.Quant = encode.ScreenQuant
+	default:
+		fmt.Fprintf(os.Stderr, "invalid quantization tables %q\n", *quant)
+		os.Exit(1)
+	}
+	enc, err := encode.New(*format, encodeOpts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	if *record && *recordFormat != rawVideo {
+		if err := checkRecordFormat(*recordFormat); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	var size image.Point
+	if *sizeFlag != "" {
+		if _, err := fmt.Sscanf(*sizeFlag, "%dx%d", &size.X, &size.Y); err != nil || size.X <= 0 || size.Y <= 0 {
+			fmt.Fprintf(os.Stderr, "invalid size %q\n", *sizeFlag)
+			os.Exit(1)
+		}
+	}
+
+	driver, err := capture.Lookup(*backend)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	n := driver.NumDisplays()
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 	http.HandleFunc("/watch", func(w http.ResponseWriter, r *http.Request) {
@@ -51,19 +112,55 @@ func main() {
 		<title> Screen ` + strconv.Itoa(screenNo) + `</title>
 	</head>
 		<body style="margin:0">
-	<img src="/mjpeg` + strconv.Itoa(screenNo) + `" style="max-width: 100vw; max-height: 100vh;object-fit: contain;display: block;margin: 0 auto;" />
+	` + screenElement(screenNo, *tiles) + `
 </body>`))
 	})
 
 	framerate := 15
 	for i := 0; i < n; i++ {
 		fmt.Fprintf(os.Stderr, "Registering stream %d\n", i)
-		stream := mjpeg.NewStream()
+		src, err := driver.New(i)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not create source for display %d. %v\n", i, err)
+			continue
+		}
+		if *diffTile > 0 {
+			src = capture.DetectChanges(src, *diffTile)
+		}
+		if pd, ok := src.(capture.PointerDrawer); ok {
+			pd.SetDrawPointer(*drawPointer)
+		}
+		if *record {
+			go captureScreenTranscode(ctx, src, i, framerate, *recordFormat, encodeOpts)
+			continue
+		}
+		// every display serves its latest frame as a single image as well
+		snap := newSnapshot(encodeOpts)
+		http.Handle(fmt.Sprintf("/snapshot%d", i), snap)
+		if *tiles {
+			// the tiles match the tiles of the change detection
+			tileEnc, err := encode.NewTileEncoder(encode.TileOptions{Size: *diffTile, Lossless: *tileFormat, Lossy: *format, Options: encodeOpts})
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				os.Exit(1)
+			}
+			stream := newTileStream(tileEnc.Size())
+			defer stream.Close()
+			go streamDisplay(ctx, src, framerate, size, snap.sink(tileSink(tileEnc, stream)))
+			http.Handle(fmt.Sprintf("/tiles%d", i), stream)
+			continue
+		}
+		if i > 0 {
+			// every stream needs its own encoder
+			if enc, err = encode.New(*format, encodeOpts); err != nil {
+				fmt.Fprintf(os.Stderr, "Could not create encoder for display %d. %v\n", i, err)
+				continue
+			}
+		}
+		stream := newFrameStream(enc.MIMEType())
 		defer stream.Close()
-		// go streamDisplay(ctx, i, framerate, stream)
-		go streamDisplayDXGI(ctx, i, framerate, stream)
-		// go captureScreenTranscode(ctx, i, framerate)
-		http.HandleFunc(fmt.Sprintf("/mjpeg%d", i), stream.ServeHTTP)
+		go streamDisplay(ctx, src, framerate, size, snap.sink(imageSink(enc, stream)))
+		http.HandleFunc(fmt.Sprintf("/stream%d", i), stream.ServeHTTP)
 	}
 	go func() {
 		http.ListenAndServe("0.0.0.0:8023", nil)
@@ -73,99 +170,73 @@ func main() {
 	<-time.After(time.Second)
 }
 
-// Capture using "github.com/kbinani/screenshot" (modified to reuse image.RGBA)
-func streamDisplay(ctx context.Context, n int, framerate int, out *mjpeg.Stream) {
-	max := screenshot.NumActiveDisplays()
-	if n >= max {
-		fmt.Printf("Not enough displays\n")
-		return
+// screenElement returns the element showing the stream of a screen.
+func screenElement(screen int, tiles bool) string {
+	if tiles {
+		return tilePage(fmt.Sprintf("/tiles%d", screen))
 	}
-	buf := &bufferFlusher{}
-	opts := jpegQuality(75)
-	limiter := NewFrameLimiter(framerate)
+	return `<img src="/stream` + strconv.Itoa(screen) + `" style="max-width: 100vw; max-height: 100vh;object-fit: contain;display: block;margin: 0 auto;" />`
+}
 
-	var err error
-	finalBounds := screenshot.GetDisplayBounds(n)
-	imgBuf := image.NewRGBA(finalBounds)
+// defaultFormat returns the JPEG encoder using libjpeg-turbo, if it was built with the jpegturbo tag,
+// or the one using all cores.
+func defaultFormat() string {
+	if _, err := encode.New("jpegturbo", encode.Options{}); err == nil {
+		return "jpegturbo"
+	}
+	return "jpegparallel"
+}
 
-	lastBounds := finalBounds
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		default:
-			limiter.Wait()
-		}
-		bounds := screenshot.GetDisplayBounds(n)
+// frameSink encodes and publishes a frame, of which the dirty rectangles changed since the previous one.
+type frameSink func(img *image.RGBA, dirty []image.Rectangle) error
 
-		x, y, hw, hh := bounds.Min.X, 0, bounds.Dx(), bounds.Dy()
-		newBounds := image.Rect(0, 0, int(hw), int(hh))
-		if newBounds != lastBounds {
-			lastBounds = newBounds
-			imgBuf = image.NewRGBA(lastBounds)
-		}
-		err = forkscreenshot.CaptureImg(imgBuf, int(x), int(y), int(hw), int(hh))
-		if err != nil {
-			fmt.Printf("Err CaptureImg: %v\n", err)
-			continue
-		}
+// imageSink encodes every frame as a whole image.
+func imageSink(enc encode.Encoder, out *frameStream) frameSink {
+	var buf encode.Buffer
+	return func(img *image.RGBA, _ []image.Rectangle) error {
 		buf.Reset()
-
-		encodeJpeg(buf, imgBuf, opts)
+		if err := enc.Encode(&buf, img); err != nil {
+			return err
+		}
 		out.Update(buf.Bytes())
+		return nil
 	}
 }
 
-// Capture using IDXGIOutputDuplication
-//     https://docs.microsoft.com/en-us/windows/win32/api/dxgi1_2/nn-dxgi1_2-idxgioutputduplication
-func streamDisplayDXGI(ctx context.Context, n int, framerate int, out *mjpeg.Stream) {
-	max := screenshot.NumActiveDisplays()
-	if n >= max {
-		fmt.Printf("Not enough displays\n")
-		return
+// tileSink encodes the changed tiles of every frame.
+func tileSink(enc *encode.TileEncoder, out *tileStream) frameSink {
+	return func(img *image.RGBA, dirty []image.Rectangle) error {
+		tiles, err := enc.Encode(img, dirty)
+		if err != nil {
+			return err
+		}
+		if len(tiles) > 0 {
+			out.Update(img.Rect.Size(), tiles)
+		}
+		return nil
 	}
+}
 
+// Capture using any capture.Source, e.g. GDI BitBlt or IDXGIOutputDuplication
+// Frames are scaled to size before they are encoded, unless it is zero.
+func streamDisplay(ctx context.Context, src capture.Source, framerate int, size image.Point, sink frameSink) {
 	// Keep this thread, so windows/d3d11/dxgi can use their threadlocal caches, if any
 	runtime.LockOSThread()
 
-	// Make thread PerMonitorV2 Dpi aware if supported on OS
-	// allows to let windows handle BGRA -> RGBA conversion and possibly more things
-	if win.IsValidDpiAwarenessContext(win.DpiAwarenessContextPerMonitorAwareV2) {
-		_, err := win.SetThreadDpiAwarenessContext(win.DpiAwarenessContextPerMonitorAwareV2)
-		if err != nil {
-			fmt.Printf("Could not set thread DPI awareness to PerMonitorAwareV2. %v\n", err)
-		} else {
-			fmt.Printf("Enabled PerMonitorAwareV2 DPI awareness.\n")
-		}
-	}
-
-	// Setup D3D11 stuff
-	device, deviceCtx, err := d3d.NewD3D11Device()
-	if err != nil {
-		fmt.Printf("Could not create D3D11 Device. %v\n", err)
+	if err := src.Open(); err != nil {
+		fmt.Printf("Could not open capture source. %v\n", err)
 		return
 	}
-	defer device.Release()
-	defer deviceCtx.Release()
+	defer src.Close()
 
-	var ddup *d3d.OutputDuplicator
-	defer func() {
-		if ddup != nil {
-			ddup.Release()
-			ddup = nil
-		}
-	}()
-
-	buf := &bufferFlusher{Buffer: bytes.Buffer{}}
-	opts := jpegQuality(50)
 	limiter := NewFrameLimiter(framerate)
-	// Create image that can contain the wanted output (desktop)
-	finalBounds := screenshot.GetDisplayBounds(n)
-	imgBuf := image.NewRGBA(finalBounds)
-	lastBounds := finalBounds
 
-	// TODO: This is just there, so that people can see how resizing might look
-	_ = resize.Resize(1920, 1080, imgBuf, resize.Bicubic)
+	var scaler scale.Scaler
+	var scaled *image.RGBA
+	if size != (image.Point{}) {
+		scaled = image.NewRGBA(image.Rectangle{Max: size})
+	}
+	var dirty []image.Rectangle
 
 	for {
 		select {
@@ -174,50 +245,42 @@ func streamDisplayDXGI(ctx context.Context, n int, framerate int, out *mjpeg.Str
 		default:
 			limiter.Wait()
 		}
-		bounds := screenshot.GetDisplayBounds(n)
-		newBounds := image.Rect(0, 0, int(bounds.Dx()), int(bounds.Dy()))
-		if newBounds != lastBounds {
-			lastBounds = newBounds
-			imgBuf = image.NewRGBA(lastBounds)
-
-			// Throw away old ddup
-			if ddup != nil {
-				ddup.Release()
-				ddup = nil
-			}
-		}
-		// create output duplication if doesn't exist yet (maybe due to resolution change)
-		if ddup == nil {
-			ddup, err = d3d.NewIDXGIOutputDuplication(device, deviceCtx, uint(n))
-			if err != nil {
-				fmt.Printf("err: %v\n", err)
-				continue
-			}
-		}
 
-		// Grab an image.RGBA from the current output presenter
-		err = ddup.GetImage(imgBuf, 0)
+		frame, err := src.NextFrame(ctx)
 		if err != nil {
-			if errors.Is(err, d3d.ErrNoImageYet) {
+			if errors.Is(err, capture.ErrNoImageYet) {
 				// don't update
 				continue
 			}
-			fmt.Printf("Err ddup.GetImage: %v\n", err)
-			// Retry with new ddup, can occur when changing resolution
-			ddup.Release()
-			ddup = nil
+			fmt.Printf("Err NextFrame: %v\n", err)
+			continue
+		}
+		// the moved regions changed as well
+		dirty = append(dirty[:0], frame.Dirty...)
+		for _, m := range frame.Moves {
+			dirty = append(dirty, m.Dst)
+		}
+		img := frame.Image
+		if scaled != nil {
+			scaler.Scale(scaled, img)
+			for i, r := range dirty {
+				dirty[i] = scaleRect(r, img.Rect.Size(), size)
+			}
+			img = scaled
+		}
+		if err := sink(img, dirty); err != nil {
+			fmt.Printf("Err Encode: %v\n", err)
 			continue
 		}
-		buf.Reset()
-		encodeJpeg(buf, imgBuf, opts)
-		out.Update(buf.Bytes())
 	}
 }
 
-// Workaround for jpeg.Encode(), which requires a Flush()
-// method to not call `bufio.NewWriter`
-type bufferFlusher struct {
-	bytes.Buffer
+// scaleRect maps r of an image of size from onto the image scaled to size to,
+// grown by the pixels the scaling filter reads around it.
+func scaleRect(r image.Rectangle, from, to image.Point) image.Rectangle {
+	mx, my := to.X/from.X+1, to.Y/from.Y+1
+	return image.Rect(
+		r.Min.X*to.X/from.X-mx, r.Min.Y*to.Y/from.Y-my,
+		(r.Max.X*to.X+from.X-1)/from.X+mx, (r.Max.Y*to.Y+from.Y-1)/from.Y+my,
+	).Intersect(image.Rectangle{Max: to})
 }
-
-func (*bufferFlusher) Flush() error { return nil }
@@ -10,42 +10,65 @@ import (
 	"runtime"
 	"time"
 
-	"github.com/kirides/screencapture/d3d"
-
-	"github.com/kbinani/screenshot"
+	"github.com/kirides/screencapture/capture"
+	"github.com/kirides/screencapture/encode"
+	"github.com/kirides/screencapture/swizzle"
 )
 
-func captureScreenTranscode(ctx context.Context, n int, framerate int) {
-	max := screenshot.NumActiveDisplays()
-	if n >= max {
-		fmt.Printf("Not enough displays\n")
-		return
+// rawVideo pipes the frames into ffmpeg as uncompressed yuv420p.
+const rawVideo = "yuv420p"
+
+// ffmpegCodecs are the ffmpeg decoders of the image formats, by MIME type.
+var ffmpegCodecs = map[string]string{
+	"image/jpeg": "mjpeg",
+	"image/png":  "png",
+	"image/bmp":  "bmp",
+	"image/qoi":  "qoi",
+}
+
+// checkRecordFormat returns an error if the frames cannot be piped into ffmpeg in the image format.
+func checkRecordFormat(format string) error {
+	enc, err := encode.New(format, encode.Options{})
+	if err != nil {
+		return err
 	}
-	screenBounds := screenshot.GetDisplayBounds(n)
-	transcoder := newVideotranscoder(fmt.Sprintf("screen_%d.mp4", n), screenBounds.Dx(), screenBounds.Dy(), float32(framerate))
+	if _, ok := ffmpegCodecs[enc.MIMEType()]; !ok {
+		return fmt.Errorf("cannot record %s images with ffmpeg", format)
+	}
+	return nil
+}
 
+// captureScreenTranscode records the frames of src into screen_n.mp4.
+// Frames are piped into ffmpeg as raw video, or encoded in the image format, if it is not rawVideo.
+func captureScreenTranscode(ctx context.Context, src capture.Source, n int, framerate int, format string, opts encode.Options) {
 	// Keep this thread, so windows/d3d11/dxgi can use their threadlocal caches, if any
 	runtime.LockOSThread()
-	// Setup D3D11 stuff
-	device, deviceCtx, err := d3d.NewD3D11Device()
-	if err != nil {
-		fmt.Printf("Could not create D3D11 Device. %v\n", err)
-		return
-	}
-	defer device.Release()
-	defer deviceCtx.Release()
 
-	ddup, err := d3d.NewIDXGIOutputDuplication(device, deviceCtx, uint(n))
-	if err != nil {
-		fmt.Printf("Err NewIDXGIOutputDuplication: %v\n", err)
+	if err := src.Open(); err != nil {
+		fmt.Printf("Could not open capture source. %v\n", err)
 		return
 	}
-	defer ddup.Release()
+	defer src.Close()
+
+	screenBounds := src.Bounds()
+	var enc encode.Encoder
+	codec := ""
+	if format != rawVideo {
+		var err error
+		if enc, err = encode.New(format, opts); err != nil {
+			fmt.Printf("Could not create encoder. %v\n", err)
+			return
+		}
+		codec = ffmpegCodecs[enc.MIMEType()]
+	}
+	transcoder := newVideotranscoder(fmt.Sprintf("screen_%d.mp4", n), screenBounds.Dx(), screenBounds.Dy(), float32(framerate), codec)
+	var encoded encode.Buffer
 
 	limiter := NewFrameLimiter(framerate)
 
-	// Create image that can contain the wanted output (desktop)
-	imgBuf := image.NewRGBA(screenBounds)
+	// Frames are converted to yuv420p, which ffmpeg encodes directly, so only 1.5 bytes per pixel go through the pipe
+	yuv := image.NewYCbCr(image.Rect(0, 0, screenBounds.Dx(), screenBounds.Dy()), image.YCbCrSubsampleRatio420)
+	yuvOpts := swizzle.YUVOptions{Matrix: swizzle.BT709}
 
 	defer transcoder.Close()
 	t1 := time.Now()
@@ -62,19 +85,43 @@ func captureScreenTranscode(ctx context.Context, n int, framerate int) {
 		default:
 			limiter.Wait()
 		}
-		// Grab an image.RGBA from the current output presenter
-		err = ddup.GetImage(imgBuf, 0)
-		if err != nil && !errors.Is(err, d3d.ErrNoImageYet) {
-			fmt.Printf("Err ddup.GetImage: %v\n", err)
+		frame, err := src.NextFrame(ctx)
+		if err != nil && !errors.Is(err, capture.ErrNoImageYet) {
+			fmt.Printf("Err NextFrame: %v\n", err)
 			return
 		}
+		if err == nil {
+			if frame.Image.Rect != yuv.Rect {
+				fmt.Printf("Resolution changed to %v, stopping recording\n", frame.Image.Rect.Size())
+				return
+			}
+			// the previous image is written again if there is no new one, to keep the timing
+			if enc != nil {
+				encoded.Reset()
+				if err := enc.Encode(&encoded, frame.Image); err != nil {
+					fmt.Printf("Failed to encode image: %v\n", err)
+					return
+				}
+			} else {
+				swizzle.RGBAToI420(yuv, frame.Image.Pix, frame.Image.Stride, yuvOpts)
+			}
+		}
 
 		numFrames++
 
-		n, err := transcoder.Write(imgBuf.Pix)
-		if err != nil || n != len(imgBuf.Pix) {
-			fmt.Printf("Failed to write image: %v\n", err)
-			return
+		planes := [][]byte{yuv.Y, yuv.Cb, yuv.Cr}
+		if enc != nil {
+			if encoded.Len() == 0 {
+				continue
+			}
+			planes = [][]byte{encoded.Bytes()}
+		}
+		for _, plane := range planes {
+			n, err := transcoder.Write(plane)
+			if err != nil || n != len(plane) {
+				fmt.Printf("Failed to write image: %v\n", err)
+				return
+			}
 		}
 	}
 }
@@ -85,13 +132,20 @@ type videotranscoder struct {
 	in io.WriteCloser
 }
 
-func newVideotranscoder(filePath string, width, height int, framerate float32) *videotranscoder {
-	cmd := exec.Command("ffmpeg",
-		"-y",
-		"-vsync", "0",
+// newVideotranscoder starts ffmpeg reading raw yuv420p frames, or images decoded by codec if it is set.
+func newVideotranscoder(filePath string, width, height int, framerate float32, codec string) *videotranscoder {
+	input := []string{
 		"-f", "rawvideo",
 		"-video_size", fmt.Sprintf("%dx%d", width, height),
-		"-pixel_format", "rgba",
+		"-pixel_format", "yuv420p",
+		"-color_range", "tv",
+		"-colorspace", "bt709",
+	}
+	if codec != "" {
+		input = []string{"-f", "image2pipe", "-c:v", codec}
+	}
+	args := append([]string{"-y", "-vsync", "0"}, input...)
+	args = append(args,
 		"-framerate", fmt.Sprintf("%f", framerate),
 		"-i", "-",
 		// "-vf", "scale=-1:1080",
@@ -100,6 +154,7 @@ func newVideotranscoder(filePath string, width, height int, framerate float32) *
 		"-tune", "zerolatency",
 		filePath,
 	)
+	cmd := exec.Command("ffmpeg", args...)
 
 	wc, err := cmd.StdinPipe()
 	if err != nil {
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"image"
+	"image/draw"
+	"net/http"
+	"sync"
+
+	"github.com/kirides/screencapture/encode"
+)
+
+// snapshot keeps a copy of the latest frame and serves it as a single image,
+// lossless WebP unless the format query parameter selects another registered format.
+type snapshot struct {
+	opts encode.Options
+
+	// mu guards the latest frame, it is only held for copying pixels so the capture is never blocked by an encode
+	mu  sync.Mutex
+	img *image.RGBA
+	seq uint64
+
+	// encodeMu guards the frame being encoded and the encoders, requests are encoded one at a time
+	encodeMu sync.Mutex
+	frame    *image.RGBA
+	frameSeq uint64
+	encoders map[string]encode.Encoder
+	buf      encode.Buffer
+}
+
+func newSnapshot(opts encode.Options) *snapshot {
+	return &snapshot{opts: opts, encoders: make(map[string]encode.Encoder)}
+}
+
+// Update copies the dirty rectangles of img, or all of it if its size changed.
+func (s *snapshot) Update(img *image.RGBA, dirty []image.Rectangle) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.img == nil || s.img.Rect != img.Rect {
+		s.img = image.NewRGBA(img.Rect)
+		dirty = []image.Rectangle{img.Rect}
+	}
+	for _, r := range dirty {
+		draw.Draw(s.img, r, img, r.Min, draw.Src)
+	}
+	s.seq++
+}
+
+// sink passes every frame to next after copying it.
+func (s *snapshot) sink(next frameSink) frameSink {
+	return func(img *image.RGBA, dirty []image.Rectangle) error {
+		s.Update(img, dirty)
+		return next(img, dirty)
+	}
+}
+
+// latest copies the latest frame into s.frame, unless it did not change since the previous request.
+// It reports false if there is no frame yet.
+func (s *snapshot) latest() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.img == nil {
+		return false
+	}
+	if s.frame == nil || s.frame.Rect != s.img.Rect {
+		s.frame = image.NewRGBA(s.img.Rect)
+	} else if s.frameSeq == s.seq {
+		return true
+	}
+	copy(s.frame.Pix, s.img.Pix)
+	s.frameSeq = s.seq
+	return true
+}
+
+func (s *snapshot) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "webp"
+	}
+	s.encodeMu.Lock()
+	defer s.encodeMu.Unlock()
+	if !s.latest() {
+		http.Error(w, "no frame was captured yet", http.StatusServiceUnavailable)
+		return
+	}
+	enc, ok := s.encoders[format]
+	if !ok {
+		var err error
+		if enc, err = encode.New(format, s.opts); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.encoders[format] = enc
+	}
+	s.buf.Reset()
+	if err := enc.Encode(&s.buf, s.frame); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", enc.MIMEType())
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(s.buf.Bytes())
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"sync"
+)
+
+// frameStream serves the latest encoded frame to every client as a multipart/x-mixed-replace stream,
+// which browsers show like a video for any image format they support.
+type frameStream struct {
+	contentType string
+
+	mu      sync.Mutex
+	frame   []byte
+	clients map[chan struct{}]struct{}
+}
+
+func newFrameStream(contentType string) *frameStream {
+	return &frameStream{contentType: contentType, clients: make(map[chan struct{}]struct{})}
+}
+
+// Update copies b as the latest frame and notifies the clients. b can be reused afterwards.
+func (s *frameStream) Update(b []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.frame = append(s.frame[:0], b...)
+	for c := range s.clients {
+		select {
+		case c <- struct{}{}:
+		default:
+			// the client did not take the previous frame yet, it gets the latest one anyway
+		}
+	}
+}
+
+// Close ends the streams of all clients.
+func (s *frameStream) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.clients {
+		close(c)
+	}
+	s.clients = nil
+}
+
+func (s *frameStream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c := make(chan struct{}, 1)
+	s.mu.Lock()
+	if s.clients == nil {
+		s.mu.Unlock()
+		http.Error(w, "stream was closed", http.StatusServiceUnavailable)
+		return
+	}
+	s.clients[c] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		if s.clients != nil {
+			delete(s.clients, c)
+		}
+		s.mu.Unlock()
+	}()
+
+	m := multipart.NewWriter(w)
+	defer m.Close()
+	w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary="+m.Boundary())
+	w.Header().Set("Connection", "close")
+	h := textproto.MIMEHeader{}
+	h.Set("Content-Type", s.contentType)
+	var frame []byte
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case _, ok := <-c:
+			if !ok {
+				return
+			}
+		}
+		s.mu.Lock()
+		frame = append(frame[:0], s.frame...)
+		s.mu.Unlock()
+
+		h.Set("Content-Length", strconv.Itoa(len(frame)))
+		part, err := m.CreatePart(h)
+		if err != nil {
+			return
+		}
+		if _, err := part.Write(frame); err != nil {
+			return
+		}
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/binary"
+	"image"
+	"net/http"
+	"sync"
+
+	"github.com/kirides/screencapture/encode"
+)
+
+// tileStream serves the tiles of a frame to every client as a stream of updates.
+// A client first gets every tile, then the tiles that changed since its previous update,
+// so a slow client skips intermediate versions of a tile instead of falling behind.
+//
+// An update is encoded in big endian as
+//
+//	width, height, count uint16
+//	count times: x, y uint16, mimeLength uint8, mime [mimeLength]byte, dataLength uint32, data [dataLength]byte
+type tileStream struct {
+	tileSize int
+
+	mu      sync.Mutex
+	size    image.Point
+	cols    int
+	cells   []encode.Tile
+	clients map[*tileClient]struct{}
+}
+
+// tileClient holds the cells that changed since the previous update of a client.
+type tileClient struct {
+	notify chan struct{}
+	dirty  []bool
+}
+
+func newTileStream(tileSize int) *tileStream {
+	return &tileStream{tileSize: tileSize, clients: make(map[*tileClient]struct{})}
+}
+
+// Update copies the tiles of a frame of the given size and notifies the clients. The tiles can be reused afterwards.
+func (s *tileStream) Update(size image.Point, tiles []encode.Tile) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.clients == nil {
+		return
+	}
+	if size != s.size {
+		// every tile of the new size follows
+		s.size = size
+		s.cols = (size.X + s.tileSize - 1) / s.tileSize
+		s.cells = make([]encode.Tile, s.cols*((size.Y+s.tileSize-1)/s.tileSize))
+		for c := range s.clients {
+			c.dirty = make([]bool, len(s.cells))
+		}
+	}
+	for _, t := range tiles {
+		i := t.Rect.Min.Y/s.tileSize*s.cols + t.Rect.Min.X/s.tileSize
+		cell := &s.cells[i]
+		cell.Rect, cell.Class, cell.MIMEType = t.Rect, t.Class, t.MIMEType
+		cell.Data = append(cell.Data[:0], t.Data...)
+		for c := range s.clients {
+			c.dirty[i] = true
+		}
+	}
+	for c := range s.clients {
+		select {
+		case c.notify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Close ends the streams of all clients.
+func (s *tileStream) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.clients {
+		close(c.notify)
+	}
+	s.clients = nil
+}
+
+// appendUpdate appends an update of the dirty cells of c and clears them.
+func (s *tileStream) appendUpdate(dst []byte, c *tileClient) []byte {
+	be := binary.BigEndian
+	start := len(dst)
+	dst = append(dst, 0, 0, 0, 0, 0, 0)
+	be.PutUint16(dst[start:], uint16(s.size.X))
+	be.PutUint16(dst[start+2:], uint16(s.size.Y))
+	n := 0
+	for i, dirty := range c.dirty {
+		cell := &s.cells[i]
+		if !dirty || cell.Data == nil {
+			continue
+		}
+		c.dirty[i] = false
+		var hdr [4]byte
+		be.PutUint16(hdr[:], uint16(cell.Rect.Min.X))
+		be.PutUint16(hdr[2:], uint16(cell.Rect.Min.Y))
+		dst = append(dst, hdr[:]...)
+		dst = append(dst, byte(len(cell.MIMEType)))
+		dst = append(dst, cell.MIMEType...)
+		be.PutUint32(hdr[:], uint32(len(cell.Data)))
+		dst = append(dst, hdr[:]...)
+		dst = append(dst, cell.Data...)
+		n++
+	}
+	be.PutUint16(dst[start+4:], uint16(n))
+	return dst
+}
+
+func (s *tileStream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c := &tileClient{notify: make(chan struct{}, 1)}
+	s.mu.Lock()
+	if s.clients == nil {
+		s.mu.Unlock()
+		http.Error(w, "stream was closed", http.StatusServiceUnavailable)
+		return
+	}
+	// a new client gets every tile first
+	c.dirty = make([]bool, len(s.cells))
+	for i := range c.dirty {
+		c.dirty[i] = true
+	}
+	c.notify <- struct{}{}
+	s.clients[c] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		if s.clients != nil {
+			delete(s.clients, c)
+		}
+		s.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	var update []byte
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case _, ok := <-c.notify:
+			if !ok {
+				return
+			}
+		}
+		s.mu.Lock()
+		update = s.appendUpdate(update[:0], c)
+		s.mu.Unlock()
+
+		if _, err := w.Write(update); err != nil {
+			return
+		}
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+}
+
+// tilePage returns the elements drawing the updates of the tileStream at src onto a canvas.
+func tilePage(src string) string {
+	return `<canvas id="screen" data-src="` + src + `" style="max-width: 100vw; max-height: 100vh;object-fit: contain;display: block;margin: 0 auto;"></canvas>
+<script>
+(async () => {
+	const canvas = document.getElementById('screen');
+	const ctx = canvas.getContext('2d');
+	const reader = (await fetch(canvas.dataset.src)).body.getReader();
+	let buf = new Uint8Array(0);
+	const take = async n => {
+		while (buf.length < n) {
+			const { value, done } = await reader.read();
+			if (done) throw new Error('stream ended');
+			const b = new Uint8Array(buf.length + value.length);
+			b.set(buf);
+			b.set(value, buf.length);
+			buf = b;
+		}
+		const v = buf.subarray(0, n);
+		buf = buf.subarray(n);
+		return v;
+	};
+	const view = b => new DataView(b.buffer, b.byteOffset, b.byteLength);
+	for (;;) {
+		const h = view(await take(6));
+		const width = h.getUint16(0), height = h.getUint16(2), n = h.getUint16(4);
+		const tiles = [];
+		for (let i = 0; i < n; i++) {
+			const t = view(await take(5));
+			const mime = new TextDecoder().decode(await take(t.getUint8(4)));
+			const length = view(await take(4)).getUint32(0);
+			const blob = new Blob([await take(length)], { type: mime });
+			tiles.push({ x: t.getUint16(0), y: t.getUint16(2), bitmap: createImageBitmap(blob) });
+		}
+		if (canvas.width !== width || canvas.height !== height) {
+			canvas.width = width;
+			canvas.height = height;
+		}
+		for (const t of tiles) {
+			ctx.drawImage(await t.bitmap, t.x, t.y);
+		}
+	}
+})();
+</script>`
+}
@@ -1,3 +1,6 @@
+//go:build windows
+// +build windows
+
 package d3d
 
 import (
@@ -18,22 +21,6 @@ var (
 	iid_ID3D11InfoQueue, _ = windows.GUIDFromString("{6543dbb6-1b48-42f5-ab82-e97ec74326f6}")
 )
 
-const (
-	D3D11_USAGE_DEFAULT = 0
-	D3D11_USAGE_STAGING = 3
-
-	D3D11_CPU_ACCESS_READ = 0x20000
-
-	D3D11_RLDO_SUMMARY         = 0x1
-	D3D11_RLDO_DETAIL          = 0x2
-	D3D11_RLDO_IGNORE_INTERNAL = 0x4
-
-	D3D11_CREATE_DEVICE_DEBUG        = 0x2
-	D3D11_CREATE_DEVICE_BGRA_SUPPORT = 0x20
-
-	D3D11_SDK_VERSION = 7
-)
-
 func _D3D11CreateDevice(ppDevice **ID3D11Device, ppDeviceContext **ID3D11DeviceContext) error {
 	var factory1 *IDXGIFactory1
 	if err := _CreateDXGIFactory1(&factory1); err != nil {
@@ -1,5 +1,21 @@
 package d3d
 
+const (
+	D3D11_USAGE_DEFAULT = 0
+	D3D11_USAGE_STAGING = 3
+
+	D3D11_CPU_ACCESS_READ = 0x20000
+
+	D3D11_RLDO_SUMMARY         = 0x1
+	D3D11_RLDO_DETAIL          = 0x2
+	D3D11_RLDO_IGNORE_INTERNAL = 0x4
+
+	D3D11_CREATE_DEVICE_DEBUG        = 0x2
+	D3D11_CREATE_DEVICE_BGRA_SUPPORT = 0x20
+
+	D3D11_SDK_VERSION = 7
+)
+
 type _D3D11_BOX struct {
 	Left, Top, Front, Right, Bottom, Back uint32
 }
@@ -1,3 +1,6 @@
+//go:build windows
+// +build windows
+
 package d3d
 
 type iD3D11DeviceChildVtbl struct {
@@ -0,0 +1,54 @@
+package d3d
+
+// The interfaces below describe the parts of the COM objects OutputDuplicator relies on.
+// On windows they are implemented by thin wrappers around the COM objects (duplication_windows.go),
+// which keeps the frame handling independent of the platform.
+
+type outputDuplication interface {
+	GetDesc(desc *_DXGI_OUTDUPL_DESC) int32
+	MapDesktopSurface(pLockedRect *DXGI_MAPPED_RECT) int32
+	UnMapDesktopSurface() int32
+	// AcquireNextFrame stores the desktop image in ppDesktopResource, which has to be released by the caller
+	AcquireNextFrame(timeoutMs uint, pFrameInfo *_DXGI_OUTDUPL_FRAME_INFO, ppDesktopResource *resource) uint32
+	ReleaseFrame()
+	GetFrameMoveRects(buffer []_DXGI_OUTDUPL_MOVE_RECT, rectsRequired *uint32) int32
+	GetFrameDirtyRects(buffer []RECT, rectsRequired *uint32) int32
+	GetFramePointerShape(pointerShapeBufferSize uint32,
+		pPointerShapeBuffer []byte,
+		pPointerShapeBufferSizeRequired *uint32,
+		pPointerShapeInfo *_DXGI_OUTDUPL_POINTER_SHAPE_INFO) int32
+	Release() uint32
+}
+
+// resource is an IDXGIResource
+type resource interface {
+	// QueryTexture2D is QueryInterface(iid_ID3D11Texture2D, ...)
+	QueryTexture2D(ppTexture2D *texture2D) int32
+	Release() int32
+}
+
+// texture2D is an ID3D11Texture2D
+type texture2D interface {
+	GetDesc(desc *_D3D11_TEXTURE2D_DESC) int32
+	// QuerySurface is QueryInterface(iid_IDXGISurface, ...)
+	QuerySurface(ppSurface *surface) int32
+	Release() int32
+}
+
+// surface is an IDXGISurface
+type surface interface {
+	Map(pLockedRect *DXGI_MAPPED_RECT, mapFlags uint32) int32
+	Unmap() int32
+	Release() int32
+}
+
+// device is an ID3D11Device
+type device interface {
+	CreateTexture2D(desc *_D3D11_TEXTURE2D_DESC, ppTexture2D *texture2D) int32
+}
+
+// deviceContext is an ID3D11DeviceContext
+type deviceContext interface {
+	CopyResource2D(dst, src texture2D) int32
+	CopySubresourceRegion2D(dst texture2D, dstSubResource, dstX, dstY, dstZ uint32, src texture2D, srcSubResource uint32, pSrcBox *_D3D11_BOX) int32
+}
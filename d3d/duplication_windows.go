@@ -0,0 +1,66 @@
+package d3d
+
+type comDuplication struct {
+	*IDXGIOutputDuplication
+}
+
+func (obj comDuplication) AcquireNextFrame(timeoutMs uint, pFrameInfo *_DXGI_OUTDUPL_FRAME_INFO, ppDesktopResource *resource) uint32 {
+	var desktop *IDXGIResource
+	hr := obj.IDXGIOutputDuplication.AcquireNextFrame(timeoutMs, pFrameInfo, &desktop)
+	if desktop != nil {
+		*ppDesktopResource = comResource{desktop}
+	}
+	return hr
+}
+
+type comResource struct {
+	*IDXGIResource
+}
+
+func (obj comResource) QueryTexture2D(ppTexture2D *texture2D) int32 {
+	var tex *ID3D11Texture2D
+	hr := obj.QueryInterface(iid_ID3D11Texture2D, &tex)
+	if !failed(hr) {
+		*ppTexture2D = comTexture2D{tex}
+	}
+	return hr
+}
+
+type comTexture2D struct {
+	*ID3D11Texture2D
+}
+
+func (obj comTexture2D) QuerySurface(ppSurface *surface) int32 {
+	var s *IDXGISurface
+	hr := obj.QueryInterface(iid_IDXGISurface, &s)
+	if !failed(hr) {
+		*ppSurface = s
+	}
+	return hr
+}
+
+type comDevice struct {
+	obj *ID3D11Device
+}
+
+func (d comDevice) CreateTexture2D(desc *_D3D11_TEXTURE2D_DESC, ppTexture2D *texture2D) int32 {
+	var tex *ID3D11Texture2D
+	hr := d.obj.CreateTexture2D(desc, &tex)
+	if !failed(hr) {
+		*ppTexture2D = comTexture2D{tex}
+	}
+	return hr
+}
+
+type comDeviceContext struct {
+	obj *ID3D11DeviceContext
+}
+
+func (ctx comDeviceContext) CopyResource2D(dst, src texture2D) int32 {
+	return ctx.obj.CopyResource2D(dst.(comTexture2D).ID3D11Texture2D, src.(comTexture2D).ID3D11Texture2D)
+}
+
+func (ctx comDeviceContext) CopySubresourceRegion2D(dst texture2D, dstSubResource, dstX, dstY, dstZ uint32, src texture2D, srcSubResource uint32, pSrcBox *_D3D11_BOX) int32 {
+	return ctx.obj.CopySubresourceRegion2D(dst.(comTexture2D).ID3D11Texture2D, dstSubResource, dstX, dstY, dstZ,
+		src.(comTexture2D).ID3D11Texture2D, srcSubResource, pSrcBox)
+}
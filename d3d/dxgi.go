@@ -1,6 +1,10 @@
+//go:build windows
+// +build windows
+
 package d3d
 
 import (
+	"errors"
 	"fmt"
 	"syscall"
 	"unsafe"
@@ -24,12 +28,6 @@ var (
 	iid_IDXGISurface, _ = windows.GUIDFromString("{cafcb56c-6ac3-4889-bf47-9e23bbd260ec}")
 )
 
-const (
-	DXGI_MAP_READ    = 1 << 0
-	DXGI_MAP_WRITE   = 1 << 1
-	DXGI_MAP_DISCARD = 1 << 2
-)
-
 type IDXGIFactory1 struct {
 	vtbl *iDXGIFactory1Vtbl
 }
@@ -72,7 +70,24 @@ func _CreateDXGIFactory1(ppFactory **IDXGIFactory1) error {
 }
 
 // NewXASession casts your ppv from above to a *XASession
+//
+// HDR desktops are duplicated as DXGI_FORMAT_R16G16B16A16_FLOAT and tone mapped by GetImage, see OutputDuplicator.HDR.
 func NewIDXGIOutputDuplication(device *ID3D11Device, deviceCtx *ID3D11DeviceContext, output uint) (*OutputDuplicator, error) {
+	return NewIDXGIOutputDuplicationFormats(device, deviceCtx, output,
+		DXGI_FORMAT_R8G8B8A8_UNORM,
+		DXGI_FORMAT_R16G16B16A16_FLOAT,
+		// using the former, we don't have to swizzle ourselves
+		// DXGI_FORMAT_B8G8R8A8_UNORM,
+	)
+}
+
+// NewIDXGIOutputDuplicationFormats is like NewIDXGIOutputDuplication, but passes formats to DuplicateOutput1,
+// which picks the one closest to the format of the desktop.
+// DXGI_FORMAT_R10G10B10A2_UNORM is treated as HDR10, BT.2020 primaries encoded with the PQ curve.
+func NewIDXGIOutputDuplicationFormats(device *ID3D11Device, deviceCtx *ID3D11DeviceContext, output uint, formats ...DXGI_FORMAT) (*OutputDuplicator, error) {
+	if len(formats) == 0 {
+		return nil, errors.New("no formats to duplicate the output with")
+	}
 	var hr int32
 
 	// DEBUG
@@ -121,11 +136,7 @@ func NewIDXGIOutputDuplication(device *ID3D11Device, deviceCtx *ID3D11DeviceCont
 	}
 	defer dxgiOutput5.Release()
 	var dup *IDXGIOutputDuplication
-	hr = dxgiOutput5.DuplicateOutput1(dxgiDevice1, 0, []DXGI_FORMAT{
-		DXGI_FORMAT_R8G8B8A8_UNORM,
-		// using the former, we don't have to swizzle ourselves
-		// DXGI_FORMAT_B8G8R8A8_UNORM,
-	}, &dup)
+	hr = dxgiOutput5.DuplicateOutput1(dxgiDevice1, 0, formats, &dup)
 	needsSwizzle := false
 	if failed(hr) {
 		needsSwizzle = true
@@ -143,7 +154,12 @@ func NewIDXGIOutputDuplication(device *ID3D11Device, deviceCtx *ID3D11DeviceCont
 		}
 	}
 
-	return &OutputDuplicator{device: device, deviceCtx: deviceCtx, outputDuplication: dup, needsSwizzle: needsSwizzle}, nil
+	return &OutputDuplicator{
+		device:            comDevice{device},
+		deviceCtx:         comDeviceContext{deviceCtx},
+		outputDuplication: comDuplication{dup},
+		needsSwizzle:      needsSwizzle,
+	}, nil
 }
 
 type IDXGIAdapter1 struct {
@@ -358,8 +374,6 @@ type IDXGIOutput5 struct {
 	vtbl *iDXGIOutput5Vtbl
 }
 
-type DXGI_FORMAT uint32
-
 func (obj *IDXGIOutput5) DuplicateOutput1(device1 *IDXGIDevice1, flags uint, pSupportedFormats []DXGI_FORMAT, ppOutputDuplication **IDXGIOutputDuplication) int32 {
 	pFormats := &pSupportedFormats[0]
 	ret, _, _ := syscall.Syscall6(
@@ -2,6 +2,14 @@ package d3d
 
 //go:generate stringer -type=_DXGI_OUTDUPL_POINTER_SHAPE_TYPE -output=dxgi_types_string.go
 
+type DXGI_FORMAT uint32
+
+const (
+	DXGI_MAP_READ    = 1 << 0
+	DXGI_MAP_WRITE   = 1 << 1
+	DXGI_MAP_DISCARD = 1 << 2
+)
+
 type _DXGI_RATIONAL struct {
 	Numerator   uint32
 	Denominator uint32
@@ -58,8 +66,10 @@ type DXGI_MAPPED_RECT struct {
 }
 
 const (
-	DXGI_FORMAT_R8G8B8A8_UNORM DXGI_FORMAT = 28
-	DXGI_FORMAT_B8G8R8A8_UNORM DXGI_FORMAT = 87
+	DXGI_FORMAT_R16G16B16A16_FLOAT DXGI_FORMAT = 10
+	DXGI_FORMAT_R10G10B10A2_UNORM  DXGI_FORMAT = 24
+	DXGI_FORMAT_R8G8B8A8_UNORM     DXGI_FORMAT = 28
+	DXGI_FORMAT_B8G8R8A8_UNORM     DXGI_FORMAT = 87
 )
 
 type _DXGI_OUTDUPL_POINTER_SHAPE_TYPE uint32
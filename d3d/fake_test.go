@@ -0,0 +1,200 @@
+package d3d
+
+import (
+	"unsafe"
+)
+
+// fakeFrame is the scripted result of a single AcquireNextFrame call.
+type fakeFrame struct {
+	hr    HRESULT
+	info  _DXGI_OUTDUPL_FRAME_INFO
+	moves []_DXGI_OUTDUPL_MOVE_RECT
+	dirty []RECT
+	// pix replaces the desktop image, BGRA or the format of the duplication, if set
+	pix []byte
+}
+
+// fakeDuplication plays back frames and records the calls made to it and the objects it hands out.
+type fakeDuplication struct {
+	width, height int
+	// pitch is the size of a texture row in bytes, which can be larger than width*bpp
+	pitch   int
+	format  DXGI_FORMAT
+	bpp     int
+	frames  []fakeFrame
+	desktop *fakeTexture
+	calls   []string
+
+	moveRectCalls, dirtyRectCalls int
+	current                       *fakeFrame
+}
+
+func newFakeDuplication(width, height int, frames ...fakeFrame) *fakeDuplication {
+	f := &fakeDuplication{width: width, height: height, pitch: width * 4, bpp: 4, frames: frames}
+	f.desktop = f.newTexture("desktop")
+	return f
+}
+
+// withPitch pads the rows of all textures to pitch bytes.
+func (f *fakeDuplication) withPitch(pitch int) *fakeDuplication {
+	f.pitch = pitch
+	f.desktop = f.newTexture("desktop")
+	return f
+}
+
+// withFormat duplicates the desktop in format, using bpp bytes per pixel.
+func (f *fakeDuplication) withFormat(format DXGI_FORMAT, bpp int) *fakeDuplication {
+	f.format, f.bpp = format, bpp
+	return f.withPitch(f.width * bpp)
+}
+
+func (f *fakeDuplication) newDuplicator() *OutputDuplicator {
+	return &OutputDuplicator{device: f, deviceCtx: f, outputDuplication: f, needsSwizzle: true}
+}
+
+func (f *fakeDuplication) call(name string) { f.calls = append(f.calls, name) }
+
+func (f *fakeDuplication) newTexture(name string) *fakeTexture {
+	return &fakeTexture{f: f, name: name, pix: allocSurface(f.pitch * f.height)}
+}
+
+func (f *fakeDuplication) GetDesc(desc *_DXGI_OUTDUPL_DESC) int32 {
+	desc.ModeDesc.Width = uint32(f.width)
+	desc.ModeDesc.Height = uint32(f.height)
+	desc.ModeDesc.Format = uint32(f.format)
+	return 0
+}
+func (f *fakeDuplication) MapDesktopSurface(pLockedRect *DXGI_MAPPED_RECT) int32 {
+	return hr(DXGI_ERROR_UNSUPPORTED)
+}
+func (f *fakeDuplication) UnMapDesktopSurface() int32 { return hr(DXGI_ERROR_INVALID_CALL) }
+
+func (f *fakeDuplication) AcquireNextFrame(timeoutMs uint, pFrameInfo *_DXGI_OUTDUPL_FRAME_INFO, ppDesktopResource *resource) uint32 {
+	f.call("AcquireNextFrame")
+	if len(f.frames) == 0 {
+		return uint32(DXGI_ERROR_WAIT_TIMEOUT)
+	}
+	frame := f.frames[0]
+	f.frames = f.frames[1:]
+	f.current = &frame
+	if failed(int32(frame.hr)) {
+		return uint32(frame.hr)
+	}
+	if frame.pix != nil {
+		for y := 0; y < f.height; y++ {
+			copy(f.desktop.pix[y*f.pitch:][:f.width*f.bpp], frame.pix[y*f.width*f.bpp:])
+		}
+	}
+	*pFrameInfo = frame.info
+	if pFrameInfo.TotalMetadataBufferSize == 0 {
+		pFrameInfo.TotalMetadataBufferSize = uint32(len(frame.moves)*24 + len(frame.dirty)*16)
+	}
+	*ppDesktopResource = &fakeResource{f}
+	return 0
+}
+
+func (f *fakeDuplication) ReleaseFrame() { f.call("ReleaseFrame") }
+
+func (f *fakeDuplication) GetFrameMoveRects(buffer []_DXGI_OUTDUPL_MOVE_RECT, rectsRequired *uint32) int32 {
+	f.moveRectCalls++
+	*rectsRequired = uint32(len(f.current.moves))
+	if len(buffer) < len(f.current.moves) {
+		return hr(DXGI_ERROR_MORE_DATA)
+	}
+	copy(buffer, f.current.moves)
+	return 0
+}
+
+func (f *fakeDuplication) GetFrameDirtyRects(buffer []RECT, rectsRequired *uint32) int32 {
+	f.dirtyRectCalls++
+	*rectsRequired = uint32(len(f.current.dirty))
+	if len(buffer) < len(f.current.dirty) {
+		return hr(DXGI_ERROR_MORE_DATA)
+	}
+	copy(buffer, f.current.dirty)
+	return 0
+}
+
+func (f *fakeDuplication) GetFramePointerShape(pointerShapeBufferSize uint32, pPointerShapeBuffer []byte, pPointerShapeBufferSizeRequired *uint32, pPointerShapeInfo *_DXGI_OUTDUPL_POINTER_SHAPE_INFO) int32 {
+	return hr(DXGI_ERROR_NOT_FOUND)
+}
+
+func (f *fakeDuplication) Release() uint32 {
+	f.call("Release")
+	return 0
+}
+
+func (f *fakeDuplication) CreateTexture2D(desc *_D3D11_TEXTURE2D_DESC, ppTexture2D *texture2D) int32 {
+	f.call("CreateTexture2D")
+	*ppTexture2D = f.newTexture("staged")
+	return 0
+}
+
+func (f *fakeDuplication) CopyResource2D(dst, src texture2D) int32 {
+	f.call("CopyResource2D")
+	copy(dst.(*fakeTexture).pix, src.(*fakeTexture).pix)
+	return 0
+}
+
+func (f *fakeDuplication) CopySubresourceRegion2D(dst texture2D, dstSubResource, dstX, dstY, dstZ uint32, src texture2D, srcSubResource uint32, pSrcBox *_D3D11_BOX) int32 {
+	f.call("CopySubresourceRegion2D")
+	d, s := dst.(*fakeTexture), src.(*fakeTexture)
+	stride := f.pitch
+	w := int(pSrcBox.Right-pSrcBox.Left) * f.bpp
+	for y := 0; y < int(pSrcBox.Bottom-pSrcBox.Top); y++ {
+		so := (int(pSrcBox.Top)+y)*stride + int(pSrcBox.Left)*f.bpp
+		do := (int(dstY)+y)*stride + int(dstX)*f.bpp
+		copy(d.pix[do:do+w], s.pix[so:so+w])
+	}
+	return 0
+}
+
+type fakeResource struct {
+	f *fakeDuplication
+}
+
+func (r *fakeResource) QueryTexture2D(ppTexture2D *texture2D) int32 {
+	r.f.call("QueryTexture2D")
+	*ppTexture2D = r.f.desktop
+	return 0
+}
+func (r *fakeResource) Release() int32 {
+	r.f.call("Resource.Release")
+	return 0
+}
+
+// fakeTexture is a texture and its surface, which is allocated outside of the Go heap and never freed
+type fakeTexture struct {
+	f    *fakeDuplication
+	name string
+	pix  []byte
+}
+
+func (t *fakeTexture) GetDesc(desc *_D3D11_TEXTURE2D_DESC) int32 {
+	desc.Width = uint32(t.f.width)
+	desc.Height = uint32(t.f.height)
+	return 0
+}
+func (t *fakeTexture) QuerySurface(ppSurface *surface) int32 {
+	*ppSurface = t
+	return 0
+}
+func (t *fakeTexture) Map(pLockedRect *DXGI_MAPPED_RECT, mapFlags uint32) int32 {
+	t.f.call("Map")
+	pLockedRect.Pitch = int32(t.f.pitch)
+	pLockedRect.PBits = uintptr(unsafe.Pointer(&t.pix[0]))
+	return 0
+}
+func (t *fakeTexture) Unmap() int32 {
+	t.f.call("Unmap")
+	return 0
+}
+func (t *fakeTexture) Release() int32 {
+	if t.name == "desktop" {
+		t.f.call("Texture.Release")
+	}
+	return 0
+}
+
+// hr converts an HRESULT into the value returned by the COM methods.
+func hr(e HRESULT) int32 { return int32(e) }
@@ -4,9 +4,13 @@ import (
 	"errors"
 	"fmt"
 	"image"
-
 	"unsafe"
 
+	"github.com/kirides/screencapture/bgra"
+	"github.com/kirides/screencapture/capture"
+	"github.com/kirides/screencapture/hdr"
+	"github.com/kirides/screencapture/parallel"
+	"github.com/kirides/screencapture/region"
 	"github.com/kirides/screencapture/swizzle"
 )
 
@@ -14,32 +18,90 @@ type PointerInfo struct {
 	pos POINT
 
 	size           POINT
+	hotSpot        POINT
 	shapeInBuffer  []byte
 	shapeOutBuffer *image.RGBA
 	visible        bool
 }
 
+// Position returns the top-left corner of the pointer shape, relative to the output.
+func (p *PointerInfo) Position() image.Point { return image.Pt(int(p.pos.X), int(p.pos.Y)) }
+
+// HotSpot returns the position within the pointer shape that points at the target.
+func (p *PointerInfo) HotSpot() image.Point { return image.Pt(int(p.hotSpot.X), int(p.hotSpot.Y)) }
+
+func (p *PointerInfo) Visible() bool { return p.visible }
+
+// Shape returns the pointer shape in the byte order of the duplicated output.
+// A new image is allocated whenever the shape changes, it is nil until the first shape is known.
+func (p *PointerInfo) Shape() *image.RGBA { return p.shapeOutBuffer }
+
+// PointerInfo returns the state of the pointer, which is updated with every frame.
+func (dup *OutputDuplicator) PointerInfo() *PointerInfo { return &dup.pointerInfo }
+
+// NeedsSwizzle reports whether the output is duplicated in BGRA byte order.
+func (dup *OutputDuplicator) NeedsSwizzle() bool { return dup.needsSwizzle }
+
+// Format returns the format of the duplicated desktop image, it is known after the first frame.
+func (dup *OutputDuplicator) Format() DXGI_FORMAT { return dup.format }
+
 type OutputDuplicator struct {
-	device            *ID3D11Device
-	deviceCtx         *ID3D11DeviceContext
-	outputDuplication *IDXGIOutputDuplication
+	device            device
+	deviceCtx         deviceContext
+	outputDuplication outputDuplication
 
-	stagedTex  *ID3D11Texture2D
-	surface    *IDXGISurface
+	stagedTex  texture2D
+	surface    surface
 	mappedRect DXGI_MAPPED_RECT
 	size       POINT
 
 	pointerInfo PointerInfo
+	// DrawPointer draws the pointer onto the returned images, PointerInfo is updated either way.
 	DrawPointer bool
+	// HDR configures the tone mapping of HDR desktop images to 8 bits per component.
+	HDR hdr.Options
 
 	// TODO: handle DPI? Do we need it?
 	dirtyRects    []RECT
 	movedRects    []_DXGI_OUTDUPL_MOVE_RECT
 	acquiredFrame bool
 	needsSwizzle  bool // in case we use DuplicateOutput1, swizzle is not neccessery
+	format        DXGI_FORMAT
+
+	converter   *hdr.Converter
+	converterOf hdr.Options
+
+	// fullFrame is set if the whole surface was updated by the last Snapshot
+	fullFrame bool
+	// frame holds the previous frame in the byte order of the surface, for applying moved rects
+	frame *image.RGBA
+	moves []capture.Move
+	dirty []image.Rectangle
+	// coalesced is set if dirty was merged by updateDamage
+	coalesced bool
+
+	frameInfo    _DXGI_OUTDUPL_FRAME_INFO
+	info         FrameInfo
+	drawnPointer image.Rectangle
+}
+
+// FrameInfo describes the last frame returned by GetImage.
+type FrameInfo struct {
+	// LastPresentTime is the QueryPerformanceCounter value of the last update of the desktop image.
+	LastPresentTime           int64
+	AccumulatedFrames         int
+	RectsCoalesced            bool
+	ProtectedContentMaskedOut bool
+	// Moves and Dirty describe the changes to the previous image, including the drawn pointer.
+	// They are only valid until the next call to GetImage.
+	Moves []capture.Move
+	Dirty []image.Rectangle
 }
 
-func (dup *OutputDuplicator) initializeStage(texture *ID3D11Texture2D) int32 {
+// FrameInfo returns the metadata of the last frame returned by GetImage.
+func (dup *OutputDuplicator) FrameInfo() *FrameInfo { return &dup.info }
+
+func (dup *OutputDuplicator) initializeStage(texture texture2D) int32 {
 
 	/*
 		TODO: Only do this on changes!
@@ -64,7 +126,7 @@ func (dup *OutputDuplicator) initializeStage(texture *ID3D11Texture2D) int32 {
 		return hr
 	}
 
-	hr = dup.stagedTex.QueryInterface(iid_IDXGISurface, &dup.surface)
+	hr = dup.stagedTex.QuerySurface(&dup.surface)
 	if failed(hr) {
 		return hr
 	}
@@ -74,6 +136,15 @@ func (dup *OutputDuplicator) initializeStage(texture *ID3D11Texture2D) int32 {
 }
 
 func (dup *OutputDuplicator) Release() {
+	dup.releaseStage()
+	if dup.outputDuplication != nil {
+		dup.outputDuplication.Release()
+		dup.outputDuplication = nil
+	}
+}
+
+// releaseStage releases the staged texture, the next Snapshot copies the whole desktop image into a new one.
+func (dup *OutputDuplicator) releaseStage() {
 	if dup.stagedTex != nil {
 		dup.stagedTex.Release()
 		dup.stagedTex = nil
@@ -82,10 +153,6 @@ func (dup *OutputDuplicator) Release() {
 		dup.surface.Release()
 		dup.surface = nil
 	}
-	if dup.outputDuplication != nil {
-		dup.outputDuplication.Release()
-		dup.outputDuplication = nil
-	}
 }
 
 var ErrNoImageYet = errors.New("no image yet")
@@ -99,7 +166,10 @@ func (dup *OutputDuplicator) ReleaseFrame() {
 	}
 }
 
-// returns DXGI_FORMAT_B8G8R8A8_UNORM data
+// returns DXGI_FORMAT_B8G8R8A8_UNORM data, or the format returned by Format if DuplicateOutput1 is used
+//
+// Moved rects are not applied to the mapped surface, only the dirty rects of the frame
+// are guaranteed to be up to date. GetImage composes the whole frame.
 func (dup *OutputDuplicator) Snapshot(timeoutMs uint) (unmapFn, *DXGI_MAPPED_RECT, *POINT, error) {
 	var hr int32
 	desc := _DXGI_OUTDUPL_DESC{}
@@ -108,16 +178,19 @@ func (dup *OutputDuplicator) Snapshot(timeoutMs uint) (unmapFn, *DXGI_MAPPED_REC
 		return nil, nil, nil, fmt.Errorf("failed to get the description. %w", HRESULT(hr))
 	}
 
+	dup.format = DXGI_FORMAT(desc.ModeDesc.Format)
+	dup.fullFrame = false
 	if desc.DesktopImageInSystemMemory != 0 {
 		// TODO: Figure out WHEN exactly this can occur, and if we can make use of it
 		dup.size = POINT{int32(desc.ModeDesc.Width), int32(desc.ModeDesc.Height)}
 		hr = dup.outputDuplication.MapDesktopSurface(&dup.mappedRect)
 		if !failed(hr) {
+			dup.fullFrame = true
 			return dup.outputDuplication.UnMapDesktopSurface, &dup.mappedRect, &dup.size, nil
 		}
 	}
 
-	var desktop *IDXGIResource
+	var desktop resource
 	var frameInfo _DXGI_OUTDUPL_FRAME_INFO
 
 	// Release a possible previous frame
@@ -132,28 +205,28 @@ func (dup *OutputDuplicator) Snapshot(timeoutMs uint) (unmapFn, *DXGI_MAPPED_REC
 		}
 		return nil, nil, nil, fmt.Errorf("failed to AcquireNextFrame. %w", HRESULT(hrF))
 	}
+	dup.frameInfo = frameInfo
 	// If we do not release the frame ASAP, we only get FPS / 2 frames :/
 	// Something wrong here?
 	defer dup.ReleaseFrame()
 	defer desktop.Release()
 
-	if dup.DrawPointer {
-		if err := dup.updatePointer(&frameInfo); err != nil {
-			return nil, nil, nil, err
-		}
+	if err := dup.updatePointer(&frameInfo); err != nil {
+		return nil, nil, nil, err
 	}
 
 	if frameInfo.AccumulatedFrames == 0 {
 		return nil, nil, nil, ErrNoImageYet
 	}
-	var desktop2d *ID3D11Texture2D
-	hr = desktop.QueryInterface(iid_ID3D11Texture2D, &desktop2d)
+	var desktop2d texture2D
+	hr = desktop.QueryTexture2D(&desktop2d)
 	if failed(hr) {
 		return nil, nil, nil, fmt.Errorf("failed to QueryInterface(iid_ID3D11Texture2D, ...). %w", HRESULT(hr))
 	}
 	defer desktop2d.Release()
 
-	if dup.stagedTex == nil {
+	newStage := dup.stagedTex == nil
+	if newStage {
 		hr = dup.initializeStage(desktop2d)
 		if failed(hr) {
 			return nil, nil, nil, fmt.Errorf("failed to InitializeStage. %w", HRESULT(hr))
@@ -195,27 +268,30 @@ func (dup *OutputDuplicator) Snapshot(timeoutMs uint) (unmapFn, *DXGI_MAPPED_REC
 			break
 		}
 
-		box := _D3D11_BOX{
-			Front: 0,
-			Back:  1,
-		}
-		if len(dup.movedRects) == 0 {
-			for i := 0; i < len(dup.dirtyRects); i++ {
-				box.Left = uint32(dup.dirtyRects[i].Left)
-				box.Top = uint32(dup.dirtyRects[i].Top)
-				box.Right = uint32(dup.dirtyRects[i].Right)
-				box.Bottom = uint32(dup.dirtyRects[i].Bottom)
+		if newStage {
+			// the stage does not contain a previous frame yet
+			dup.deviceCtx.CopyResource2D(dup.stagedTex, desktop2d)
+			dup.fullFrame = true
+		} else {
+			// moved rects are applied on the CPU, by GetImage
+			dup.updateDamage()
+			box := _D3D11_BOX{
+				Front: 0,
+				Back:  1,
+			}
+			for _, r := range dup.dirty {
+				box.Left = uint32(r.Min.X)
+				box.Top = uint32(r.Min.Y)
+				box.Right = uint32(r.Max.X)
+				box.Bottom = uint32(r.Max.Y)
 
 				dup.deviceCtx.CopySubresourceRegion2D(dup.stagedTex, 0, box.Left, box.Top, 0, desktop2d, 0, &box)
 			}
-		} else {
-			// TODO: handle moved rects, then dirty rects
-			// for now, just update the whole image instead
-			dup.deviceCtx.CopyResource2D(dup.stagedTex, desktop2d)
 		}
 	} else {
 		// no frame metadata, copy whole image
 		dup.deviceCtx.CopyResource2D(dup.stagedTex, desktop2d)
+		dup.fullFrame = true
 		if !dup.needsSwizzle {
 			dup.needsSwizzle = true
 		}
@@ -230,6 +306,22 @@ func (dup *OutputDuplicator) Snapshot(timeoutMs uint) (unmapFn, *DXGI_MAPPED_REC
 }
 
 func (dup *OutputDuplicator) GetImage(img *image.RGBA, timeoutMs uint) error {
+	return dup.getImage(img.Pix, img.Stride, img.Rect.Size(), dup.needsSwizzle, timeoutMs)
+}
+
+// GetBGRAImage is like GetImage, but keeps the BGRA byte order of the desktop surface,
+// so no conversion is needed unless the duplication provides RGBA.
+func (dup *OutputDuplicator) GetBGRAImage(img *bgra.Image, timeoutMs uint) error {
+	return dup.getImage(img.Pix, img.Stride, img.Rect.Size(), !dup.needsSwizzle, timeoutMs)
+}
+
+// getImage copies the desktop image into the pixels of an image of the given size, swapping R and B if swap is set.
+func (dup *OutputDuplicator) getImage(pix []byte, stride int, imgSize image.Point, swap bool, timeoutMs uint) error {
+	if dup.converter != nil && dup.converterOf != dup.HDR {
+		// the previous frame was tone mapped differently, start over with the whole desktop image
+		dup.converter = nil
+		dup.releaseStage()
+	}
 	unmap, mappedRect, size, err := dup.Snapshot(timeoutMs)
 	if err != nil {
 		return err
@@ -237,15 +329,51 @@ func (dup *OutputDuplicator) GetImage(img *image.RGBA, timeoutMs uint) error {
 	defer unmap()
 	hMem := mappedRect.PBits
 
-	bitmapDataSize := int32(((int64(size.X)*32 + 31) / 32) * 4 * int64(size.Y))
+	bounds := image.Rect(0, 0, int(size.X), int(size.Y))
+	pitch := int(mappedRect.Pitch)
+	conv := dup.hdrConverter()
+	bpp := 4
+	if conv != nil {
+		bpp = conv.Format().BytesPerPixel()
+	}
+	surfaceSize := (bounds.Dy()-1)*pitch + bounds.Dx()*bpp
+	surface := &image.RGBA{
+		Pix:    unsafe.Slice((*byte)(unsafe.Pointer(hMem)), surfaceSize),
+		Stride: pitch,
+		Rect:   bounds,
+	}
 
-	// copy source bytes into image.RGBA.Pix using memory interpretation
-	imageBytes := ((*[1 << 30]byte)(unsafe.Pointer(hMem)))[:bitmapDataSize:bitmapDataSize]
-	copy(img.Pix[:bitmapDataSize], imageBytes)
-	dup.drawPointer(img)
-	if dup.needsSwizzle {
-		swizzle.BGRA(img.Pix)
+	if dup.frame == nil || dup.frame.Rect != bounds {
+		dup.frame = image.NewRGBA(bounds)
+		dup.fullFrame = true
 	}
+	if dup.fullFrame {
+		dup.moves = dup.moves[:0]
+		dup.dirty = append(dup.dirty[:0], bounds)
+		dup.coalesced = false
+	}
+	if conv != nil {
+		// the frame holds tone mapped pixels, only the dirty rects of the surface are converted
+		capture.Apply(dup.frame, dup.moves, nil, nil)
+		for _, d := range dup.dirty {
+			d = d.Intersect(bounds)
+			kernel := conv.ToRGBA
+			if dup.needsSwizzle {
+				kernel = conv.ToBGRA
+			}
+			parallel.Default.Copy(kernel, dup.frame.Pix, dup.frame.Stride, surface.Pix, pitch, d)
+		}
+	} else {
+		capture.Apply(dup.frame, dup.moves, dup.dirty, surface)
+	}
+	r := bounds.Intersect(image.Rectangle{Max: imgSize})
+	if swap {
+		parallel.Default.Copy(swizzle.CopyBGRA, pix, stride, dup.frame.Pix, dup.frame.Stride, r)
+	} else {
+		parallel.Default.Copy(copyRows, pix, stride, dup.frame.Pix, dup.frame.Stride, r)
+	}
+	dup.drawPointer(pix, stride, r, swap)
+	dup.updateFrameInfo(bounds)
 
 	// manual swizzle B <-> R
 
@@ -260,6 +388,90 @@ func (dup *OutputDuplicator) GetImage(img *image.RGBA, timeoutMs uint) error {
 	return nil
 }
 
+// hdrConverter returns the converter for the format of the desktop image, or nil if it has 8 bits per component.
+func (dup *OutputDuplicator) hdrConverter() *hdr.Converter {
+	var f hdr.Format
+	switch dup.format {
+	case DXGI_FORMAT_R16G16B16A16_FLOAT:
+		f = hdr.ScRGB
+	case DXGI_FORMAT_R10G10B10A2_UNORM:
+		f = hdr.PQ10
+	default:
+		return nil
+	}
+	if dup.converter == nil || dup.converter.Format() != f {
+		dup.converter = hdr.NewConverter(f, dup.HDR)
+		dup.converterOf = dup.HDR
+	}
+	return dup.converter
+}
+
+// copyRows copies the pixels of r between buffers with the same byte order.
+func copyRows(dst []byte, dstStride int, src []byte, srcStride int, r image.Rectangle) {
+	n := r.Dx() * 4
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		copy(dst[y*dstStride+r.Min.X*4:][:n], src[y*srcStride+r.Min.X*4:])
+	}
+}
+
+// Frames with lots of dirty rects are copied using fewer, larger boxes.
+const (
+	maxCopyRects    = 32
+	maxCopyOverdraw = 0.5
+)
+
+// updateDamage converts the moved and dirty rects of the last frame.
+func (dup *OutputDuplicator) updateDamage() {
+	dup.moves = dup.moves[:0]
+	for _, m := range dup.movedRects {
+		dup.moves = append(dup.moves, capture.Move{
+			Src: image.Pt(int(m.Src.X), int(m.Src.Y)),
+			Dst: image.Rect(int(m.Dest.Left), int(m.Dest.Top), int(m.Dest.Right), int(m.Dest.Bottom)),
+		})
+	}
+	dup.dirty = dup.dirty[:0]
+	for _, r := range dup.dirtyRects {
+		dup.dirty = append(dup.dirty, image.Rect(int(r.Left), int(r.Top), int(r.Right), int(r.Bottom)))
+	}
+	dup.coalesced = len(dup.dirty) > maxCopyRects
+	if dup.coalesced {
+		dup.dirty = region.New(dup.dirty...).Cover(maxCopyRects, maxCopyOverdraw)
+	}
+}
+
+// updateFrameInfo fills dup.info for the image just composed.
+func (dup *OutputDuplicator) updateFrameInfo(bounds image.Rectangle) {
+	info := &dup.info
+	info.LastPresentTime = dup.frameInfo.LastPresentTime
+	info.AccumulatedFrames = int(dup.frameInfo.AccumulatedFrames)
+	info.RectsCoalesced = dup.frameInfo.RectsCoalesced != 0 || dup.coalesced
+	info.ProtectedContentMaskedOut = dup.frameInfo.ProtectedContentMaskedOut != 0
+	info.Moves = append(info.Moves[:0], dup.moves...)
+	info.Dirty = append(info.Dirty[:0], dup.dirty...)
+
+	// the pointer is not part of the composed frame, but of the returned image
+	old := dup.drawnPointer
+	dup.drawnPointer = image.Rectangle{}
+	if dup.DrawPointer && dup.pointerInfo.shapeOutBuffer != nil {
+		dup.drawnPointer = image.Rectangle{Min: dup.pointerInfo.Position(), Max: dup.pointerInfo.Position().Add(image.Pt(int(dup.pointerInfo.size.X), int(dup.pointerInfo.size.Y)))}.Intersect(bounds)
+	}
+	if old.Empty() && dup.drawnPointer.Empty() {
+		return
+	}
+	// moves carry the previously drawn pointer along
+	for _, m := range info.Moves {
+		delta := m.Dst.Min.Sub(m.Src)
+		if r := old.Add(delta).Intersect(m.Dst); !r.Empty() {
+			info.Dirty = append(info.Dirty, r)
+		}
+	}
+	for _, r := range []image.Rectangle{old, dup.drawnPointer} {
+		if !r.Empty() {
+			info.Dirty = append(info.Dirty, r)
+		}
+	}
+}
+
 func (dup *OutputDuplicator) updatePointer(info *_DXGI_OUTDUPL_FRAME_INFO) error {
 	if info.LastMouseUpdateTime == 0 {
 		return nil
@@ -283,6 +495,7 @@ func (dup *OutputDuplicator) updatePointer(info *_DXGI_OUTDUPL_FRAME_INFO) error
 		if hr != 0 {
 			return fmt.Errorf("unable to obtain frame pointer shape")
 		}
+		dup.pointerInfo.hotSpot = pointerInfo.HotSpot
 		neededSize := pointerInfo.Width * pointerInfo.Height * 4
 		dup.pointerInfo.shapeOutBuffer = image.NewRGBA(image.Rect(0, 0, int(pointerInfo.Width), int(pointerInfo.Height)))
 		if len(dup.pointerInfo.shapeOutBuffer.Pix) < int(neededSize) {
@@ -374,23 +587,30 @@ func (dup *OutputDuplicator) updatePointer(info *_DXGI_OUTDUPL_FRAME_INFO) error
 	return nil
 }
 
-func (dup *OutputDuplicator) drawPointer(img *image.RGBA) error {
+// drawPointer draws the pointer shape, which has the byte order of the desktop surface, onto the pixels within r.
+func (dup *OutputDuplicator) drawPointer(pix []byte, stride int, r image.Rectangle, swap bool) {
 	if !dup.DrawPointer {
-		return nil
+		return
 	}
 
+	pos := dup.pointerInfo.Position()
 	for j := 0; j < int(dup.pointerInfo.size.Y); j++ {
 		for i := 0; i < int(dup.pointerInfo.size.X); i++ {
-			col := dup.pointerInfo.shapeOutBuffer.At(i, j)
-			_, _, _, a := col.RGBA()
-			if a == 0 {
+			if !pos.Add(image.Pt(i, j)).In(r) {
+				continue
+			}
+			s := dup.pointerInfo.shapeOutBuffer.Pix[dup.pointerInfo.shapeOutBuffer.PixOffset(i, j):]
+			if s[3] == 0 {
 				// just dont draw invisible pixel?
 				// TODO: correctly apply mask
 				continue
 			}
 
-			img.Set(int(dup.pointerInfo.pos.X)+i, int(dup.pointerInfo.pos.Y)+j, col)
+			d := pix[(pos.Y+j)*stride+(pos.X+i)*4:]
+			d[0], d[1], d[2], d[3] = s[0], s[1], s[2], s[3]
+			if swap {
+				d[0], d[2] = s[2], s[0]
+			}
 		}
 	}
-	return nil
 }
@@ -0,0 +1,414 @@
+package d3d
+
+import (
+	"errors"
+	"image"
+	"reflect"
+	"testing"
+
+	"github.com/kirides/screencapture/bgra"
+	"github.com/kirides/screencapture/capture"
+	"github.com/kirides/screencapture/hdr"
+)
+
+func testDesktop(w, h int, seed byte) []byte {
+	pix := make([]byte, w*h*4)
+	for i := range pix {
+		pix[i] = byte(i) + seed
+	}
+	return pix
+}
+
+func TestSnapshotWaitTimeout(t *testing.T) {
+	f := newFakeDuplication(4, 4, fakeFrame{hr: DXGI_ERROR_WAIT_TIMEOUT})
+	dup := f.newDuplicator()
+
+	for i := 0; i < 2; i++ {
+		if _, _, _, err := dup.Snapshot(0); err != ErrNoImageYet {
+			t.Fatalf("Snapshot() = %v, want ErrNoImageYet", err)
+		}
+	}
+	// a failed acquire still has to be released before the next one
+	want := []string{"AcquireNextFrame", "ReleaseFrame", "AcquireNextFrame"}
+	if !reflect.DeepEqual(f.calls, want) {
+		t.Errorf("calls = %v, want %v", f.calls, want)
+	}
+}
+
+func TestSnapshotAcquireError(t *testing.T) {
+	f := newFakeDuplication(4, 4, fakeFrame{hr: DXGI_ERROR_ACCESS_LOST})
+	dup := f.newDuplicator()
+
+	_, _, _, err := dup.Snapshot(0)
+	if !errors.Is(err, DXGI_ERROR_ACCESS_LOST) {
+		t.Fatalf("Snapshot() = %v, want DXGI_ERROR_ACCESS_LOST", err)
+	}
+}
+
+func TestSnapshotNoAccumulatedFrames(t *testing.T) {
+	f := newFakeDuplication(4, 4, fakeFrame{info: _DXGI_OUTDUPL_FRAME_INFO{LastMouseUpdateTime: 1}})
+	dup := f.newDuplicator()
+
+	if _, _, _, err := dup.Snapshot(0); err != ErrNoImageYet {
+		t.Fatalf("Snapshot() = %v, want ErrNoImageYet", err)
+	}
+	want := []string{"AcquireNextFrame", "Resource.Release", "ReleaseFrame"}
+	if !reflect.DeepEqual(f.calls, want) {
+		t.Errorf("calls = %v, want %v", f.calls, want)
+	}
+}
+
+func TestSnapshotPointer(t *testing.T) {
+	// a pointer move without a desktop update, the pointer is reported without drawing it
+	f := newFakeDuplication(4, 4, fakeFrame{info: _DXGI_OUTDUPL_FRAME_INFO{
+		LastMouseUpdateTime: 1,
+		PointerPosition:     _DXGI_OUTDUPL_POINTER_POSITION{Position: POINT{2, 3}, Visible: 1},
+	}})
+	dup := f.newDuplicator()
+
+	if _, _, _, err := dup.Snapshot(0); err != ErrNoImageYet {
+		t.Fatalf("Snapshot() = %v, want ErrNoImageYet", err)
+	}
+	if p := dup.PointerInfo(); !p.Visible() || p.Position() != image.Pt(2, 3) {
+		t.Errorf("pointer visible %v at %v, want visible at (2,3)", p.Visible(), p.Position())
+	}
+}
+
+func TestSnapshotReleaseOrder(t *testing.T) {
+	f := newFakeDuplication(4, 4, fakeFrame{
+		info:  _DXGI_OUTDUPL_FRAME_INFO{AccumulatedFrames: 1},
+		dirty: []RECT{{0, 0, 4, 4}},
+	})
+	dup := f.newDuplicator()
+
+	unmap, _, size, err := dup.Snapshot(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *size != (POINT{4, 4}) {
+		t.Errorf("size = %v, want 4x4", *size)
+	}
+	unmap()
+	want := []string{
+		"AcquireNextFrame",
+		"QueryTexture2D",
+		"CreateTexture2D",
+		"CopyResource2D",
+		"Map",
+		"Texture.Release",
+		"Resource.Release",
+		"ReleaseFrame",
+		"Unmap",
+	}
+	if !reflect.DeepEqual(f.calls, want) {
+		t.Errorf("calls = %v, want %v", f.calls, want)
+	}
+	dup.Release()
+	if dup.stagedTex != nil || dup.surface != nil || dup.outputDuplication != nil {
+		t.Errorf("Release() did not reset the duplicator")
+	}
+}
+
+func TestSnapshotMoreData(t *testing.T) {
+	dirty := []RECT{{0, 0, 2, 1}, {1, 1, 3, 3}, {3, 0, 4, 4}}
+	moves := []_DXGI_OUTDUPL_MOVE_RECT{
+		{Src: POINT{0, 0}, Dest: RECT{1, 0, 3, 2}},
+		{Src: POINT{2, 2}, Dest: RECT{0, 2, 2, 4}},
+	}
+	f := newFakeDuplication(4, 4,
+		fakeFrame{info: _DXGI_OUTDUPL_FRAME_INFO{AccumulatedFrames: 1}, dirty: []RECT{{0, 0, 4, 4}}},
+		fakeFrame{info: _DXGI_OUTDUPL_FRAME_INFO{AccumulatedFrames: 1}, dirty: dirty},
+		fakeFrame{info: _DXGI_OUTDUPL_FRAME_INFO{AccumulatedFrames: 1}, dirty: dirty[:1], moves: moves},
+	)
+	dup := f.newDuplicator()
+
+	// the first frame initializes the stage with a full copy
+	unmap, _, _, err := dup.Snapshot(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unmap()
+	f.calls, f.dirtyRectCalls, f.moveRectCalls = nil, 0, 0
+
+	unmap, _, _, err = dup.Snapshot(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unmap()
+	if !reflect.DeepEqual(dup.dirtyRects, dirty) {
+		t.Errorf("dirtyRects = %v, want %v", dup.dirtyRects, dirty)
+	}
+	if len(dup.movedRects) != 0 {
+		t.Errorf("movedRects = %v, want none", dup.movedRects)
+	}
+	if f.dirtyRectCalls != 2 {
+		t.Errorf("GetFrameDirtyRects called %d times, want 2", f.dirtyRectCalls)
+	}
+	if n := countCalls(f.calls, "CopySubresourceRegion2D"); n != len(dirty) {
+		t.Errorf("copied %d regions, want %d", n, len(dirty))
+	}
+
+	f.calls, f.dirtyRectCalls, f.moveRectCalls = nil, 0, 0
+	unmap, _, _, err = dup.Snapshot(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unmap()
+	if !reflect.DeepEqual(dup.movedRects, moves) {
+		t.Errorf("movedRects = %v, want %v", dup.movedRects, moves)
+	}
+	if !reflect.DeepEqual(dup.dirtyRects, dirty[:1]) {
+		t.Errorf("dirtyRects = %v, want %v", dup.dirtyRects, dirty[:1])
+	}
+	if f.moveRectCalls != 2 || f.dirtyRectCalls != 1 {
+		t.Errorf("GetFrameMoveRects/GetFrameDirtyRects called %d/%d times, want 2/1", f.moveRectCalls, f.dirtyRectCalls)
+	}
+	// moves are applied by GetImage, only the dirty rects are copied
+	if n := countCalls(f.calls, "CopySubresourceRegion2D"); n != 1 || countCalls(f.calls, "CopyResource2D") != 0 {
+		t.Errorf("calls = %v, want a single CopySubresourceRegion2D", f.calls)
+	}
+}
+
+func countCalls(calls []string, name string) int {
+	n := 0
+	for _, c := range calls {
+		if c == name {
+			n++
+		}
+	}
+	return n
+}
+
+func TestGetImage(t *testing.T) {
+	const w, h = 5, 3
+	first, second := testDesktop(w, h, 0), testDesktop(w, h, 100)
+	f := newFakeDuplication(w, h,
+		fakeFrame{info: _DXGI_OUTDUPL_FRAME_INFO{AccumulatedFrames: 1}, pix: first, dirty: []RECT{{0, 0, w, h}}},
+		fakeFrame{info: _DXGI_OUTDUPL_FRAME_INFO{AccumulatedFrames: 2}, pix: second, dirty: []RECT{{1, 1, 3, 2}}},
+	)
+	dup := f.newDuplicator()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+
+	// expected image: first frame, with the dirty rect of the second frame, as RGBA
+	want := append([]byte(nil), first...)
+	for x := 1; x < 3; x++ {
+		i := (1*w + x) * 4
+		copy(want[i:i+4], second[i:i+4])
+	}
+	for i := 0; i < len(want); i += 4 {
+		want[i], want[i+2] = want[i+2], want[i]
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := dup.GetImage(img, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !reflect.DeepEqual(img.Pix, want) {
+		t.Errorf("Pix = %v, want %v", img.Pix, want)
+	}
+	if err := dup.GetImage(img, 0); err != ErrNoImageYet {
+		t.Errorf("GetImage() = %v, want ErrNoImageYet", err)
+	}
+}
+
+func TestGetBGRAImage(t *testing.T) {
+	const w, h = 5, 3
+	first, second := testDesktop(w, h, 0), testDesktop(w, h, 100)
+	f := newFakeDuplication(w, h,
+		fakeFrame{info: _DXGI_OUTDUPL_FRAME_INFO{AccumulatedFrames: 1}, pix: first, dirty: []RECT{{0, 0, w, h}}},
+		fakeFrame{info: _DXGI_OUTDUPL_FRAME_INFO{AccumulatedFrames: 1}, pix: second, dirty: []RECT{{0, 0, w, h}}},
+	)
+	dup := f.newDuplicator()
+	img := bgra.New(image.Rect(0, 0, w, h))
+
+	// the desktop is BGRA, so it is copied as is
+	if err := dup.GetBGRAImage(img, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(img.Pix, first) {
+		t.Errorf("Pix = %v, want %v", img.Pix, first)
+	}
+
+	// RGBA from DuplicateOutput1 is swizzled
+	dup.needsSwizzle = false
+	if err := dup.GetBGRAImage(img, 0); err != nil {
+		t.Fatal(err)
+	}
+	want := append([]byte(nil), second...)
+	for i := 0; i < len(want); i += 4 {
+		want[i], want[i+2] = want[i+2], want[i]
+	}
+	if !reflect.DeepEqual(img.Pix, want) {
+		t.Errorf("Pix = %v, want %v", img.Pix, want)
+	}
+}
+
+func TestGetImageMoves(t *testing.T) {
+	const w, h = 6, 8
+	first := testDesktop(w, h, 0)
+	// scroll up by 3 rows, with new content in the last rows
+	second := testDesktop(w, h, 50)
+	copy(second, first[3*w*4:])
+
+	f := newFakeDuplication(w, h,
+		fakeFrame{info: _DXGI_OUTDUPL_FRAME_INFO{AccumulatedFrames: 1}, pix: first, dirty: []RECT{{0, 0, w, h}}},
+		fakeFrame{
+			info:  _DXGI_OUTDUPL_FRAME_INFO{LastPresentTime: 42, AccumulatedFrames: 2, ProtectedContentMaskedOut: 1},
+			pix:   second,
+			moves: []_DXGI_OUTDUPL_MOVE_RECT{{Src: POINT{0, 3}, Dest: RECT{0, 0, w, h - 3}}},
+			dirty: []RECT{{0, h - 3, w, h}},
+		},
+	)
+	dup := f.newDuplicator()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+
+	for i := 0; i < 2; i++ {
+		if err := dup.GetImage(img, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := append([]byte(nil), second...)
+	for i := 0; i < len(want); i += 4 {
+		want[i], want[i+2] = want[i+2], want[i]
+	}
+	if !reflect.DeepEqual(img.Pix, want) {
+		t.Errorf("Pix = %v, want %v", img.Pix, want)
+	}
+	wantInfo := FrameInfo{
+		LastPresentTime:           42,
+		AccumulatedFrames:         2,
+		ProtectedContentMaskedOut: true,
+		Moves:                     []capture.Move{{Src: image.Pt(0, 3), Dst: image.Rect(0, 0, w, h-3)}},
+		Dirty:                     []image.Rectangle{image.Rect(0, h-3, w, h)},
+	}
+	if info := dup.FrameInfo(); !reflect.DeepEqual(*info, wantInfo) {
+		t.Errorf("FrameInfo() = %+v, want %+v", *info, wantInfo)
+	}
+}
+
+func TestGetImageHDR(t *testing.T) {
+	const w, h = 6, 8
+	// scRGB has 8 bytes per pixel
+	first := testDesktop(w*2, h, 0)
+	second := testDesktop(w*2, h, 50)
+	copy(second, first[3*w*8:])
+
+	f := newFakeDuplication(w, h,
+		fakeFrame{info: _DXGI_OUTDUPL_FRAME_INFO{AccumulatedFrames: 1}, pix: first, dirty: []RECT{{0, 0, w, h}}},
+		fakeFrame{
+			info:  _DXGI_OUTDUPL_FRAME_INFO{AccumulatedFrames: 1},
+			pix:   second,
+			moves: []_DXGI_OUTDUPL_MOVE_RECT{{Src: POINT{0, 3}, Dest: RECT{0, 0, w, h - 3}}},
+			dirty: []RECT{{0, h - 3, w, h}},
+		},
+		fakeFrame{info: _DXGI_OUTDUPL_FRAME_INFO{AccumulatedFrames: 1}, pix: second, dirty: []RECT{{0, 0, 1, 1}}},
+	).withFormat(DXGI_FORMAT_R16G16B16A16_FLOAT, 8)
+	dup := f.newDuplicator()
+	dup.needsSwizzle = false
+	dup.HDR = hdr.Options{ToneMap: hdr.Hable, SDRWhite: 100}
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+
+	check := func(opts hdr.Options) {
+		t.Helper()
+		want := image.NewRGBA(img.Rect)
+		hdr.NewConverter(hdr.ScRGB, opts).ToRGBA(want.Pix, want.Stride, second, w*8, want.Rect)
+		if !reflect.DeepEqual(img.Pix, want.Pix) {
+			t.Errorf("Pix = %v, want %v", img.Pix, want.Pix)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if err := dup.GetImage(img, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if dup.Format() != DXGI_FORMAT_R16G16B16A16_FLOAT {
+		t.Errorf("Format() = %v, want DXGI_FORMAT_R16G16B16A16_FLOAT", dup.Format())
+	}
+	check(dup.HDR)
+
+	// other options convert the whole desktop image again
+	dup.HDR.ToneMap = hdr.BT2390
+	if err := dup.GetImage(img, 0); err != nil {
+		t.Fatal(err)
+	}
+	check(dup.HDR)
+	if n := countCalls(f.calls, "CreateTexture2D"); n != 2 {
+		t.Errorf("CreateTexture2D called %d times, want 2", n)
+	}
+}
+
+func TestGetImagePitch(t *testing.T) {
+	const w, h = 5, 4
+	first, second := testDesktop(w, h, 0), testDesktop(w, h, 0)
+	for x := 1; x < 4; x++ {
+		second[(2*w+x)*4] = 0xEE
+	}
+	for _, pitch := range []int{w * 4, w*4 + 3, w*4 + 12} {
+		f := newFakeDuplication(w, h,
+			fakeFrame{info: _DXGI_OUTDUPL_FRAME_INFO{AccumulatedFrames: 1}, pix: first, dirty: []RECT{{0, 0, w, h}}},
+			fakeFrame{info: _DXGI_OUTDUPL_FRAME_INFO{AccumulatedFrames: 1}, pix: second, dirty: []RECT{{1, 2, 4, 3}}},
+		).withPitch(pitch)
+		dup := f.newDuplicator()
+		// the destination has padded rows, too
+		img := image.NewRGBA(image.Rect(0, 0, w+3, h+1)).SubImage(image.Rect(2, 1, w+2, h+1)).(*image.RGBA)
+
+		for i := 0; i < 2; i++ {
+			if err := dup.GetImage(img, 0); err != nil {
+				t.Fatal(err)
+			}
+		}
+		want := image.NewRGBA(image.Rect(0, 0, w, h))
+		copy(want.Pix, second)
+		for i := 0; i < len(want.Pix); i += 4 {
+			want.Pix[i], want.Pix[i+2] = want.Pix[i+2], want.Pix[i]
+		}
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				if got, want := img.RGBAAt(x+2, y+1), want.RGBAAt(x, y); got != want {
+					t.Fatalf("pitch %d: pixel (%d, %d) = %v, want %v", pitch, x, y, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestGetImageManyDirtyRects(t *testing.T) {
+	const w, h = 40, 12
+	first, second := testDesktop(w, h, 0), testDesktop(w, h, 0)
+	var dirty []RECT
+	for y := 0; y < 10; y++ {
+		for x := 0; x < 40; x += 4 {
+			dirty = append(dirty, RECT{int32(x), int32(y), int32(x + 3), int32(y + 1)})
+			for i := 0; i < 3; i++ {
+				second[(y*w+x+i)*4] = 0xAA
+			}
+		}
+	}
+	f := newFakeDuplication(w, h,
+		fakeFrame{info: _DXGI_OUTDUPL_FRAME_INFO{AccumulatedFrames: 1}, pix: first, dirty: []RECT{{0, 0, w, h}}},
+		fakeFrame{info: _DXGI_OUTDUPL_FRAME_INFO{AccumulatedFrames: 1}, pix: second, dirty: dirty},
+	)
+	dup := f.newDuplicator()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+
+	for i := 0; i < 2; i++ {
+		f.calls = nil
+		if err := dup.GetImage(img, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := countCalls(f.calls, "CopySubresourceRegion2D"); n == 0 || n > maxCopyRects {
+		t.Errorf("copied %d boxes for %d dirty rects, want at most %d", n, len(dirty), maxCopyRects)
+	}
+	if info := dup.FrameInfo(); !info.RectsCoalesced || len(info.Dirty) > maxCopyRects {
+		t.Errorf("FrameInfo() = %+v, want at most %d coalesced rects", *info, maxCopyRects)
+	}
+	want := append([]byte(nil), second...)
+	for i := 0; i < len(want); i += 4 {
+		want[i], want[i+2] = want[i+2], want[i]
+	}
+	if !reflect.DeepEqual(img.Pix, want) {
+		t.Errorf("Pix = %v, want %v", img.Pix, want)
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !windows
+// +build !windows
+
+package d3d
+
+import "syscall"
+
+// allocSurface returns n bytes outside of the Go heap, like the memory of a mapped texture,
+// so the pointer arithmetic on the mapped surface passes the checks of the race detector.
+func allocSurface(n int) []byte {
+	b, err := syscall.Mmap(-1, 0, n, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_ANON|syscall.MAP_PRIVATE)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
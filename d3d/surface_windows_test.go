@@ -0,0 +1,18 @@
+package d3d
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// allocSurface returns n bytes outside of the Go heap, like the memory of a mapped texture,
+// so the pointer arithmetic on the mapped surface passes the checks of the race detector.
+func allocSurface(n int) []byte {
+	addr, err := windows.VirtualAlloc(0, uintptr(n), windows.MEM_COMMIT|windows.MEM_RESERVE, windows.PAGE_READWRITE)
+	if err != nil {
+		panic(err)
+	}
+	// the address is read as a pointer, as it is not a Go pointer that was converted to an uintptr
+	return unsafe.Slice((*byte)(*(*unsafe.Pointer)(unsafe.Pointer(&addr))), n)
+}
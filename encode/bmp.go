@@ -0,0 +1,59 @@
+package encode
+
+import (
+	"encoding/binary"
+	"image"
+	"io"
+)
+
+// bmpHeaderSize is the size of the BITMAPFILEHEADER and the BITMAPINFOHEADER.
+const bmpHeaderSize = 14 + 40
+
+// bmpEncoder encodes uncompressed 32 bit BMP images, with the rows stored top-down.
+// The fourth byte of a pixel holds the alpha, but is ignored by most readers.
+type bmpEncoder struct {
+	buf []byte
+}
+
+func newBMP(Options) Encoder {
+	return &bmpEncoder{}
+}
+
+func (e *bmpEncoder) MIMEType() string { return "image/bmp" }
+
+func (e *bmpEncoder) Encode(w io.Writer, m image.Image) error {
+	b := m.Bounds()
+	rowSize := b.Dx() * 4
+	size := bmpHeaderSize + rowSize*b.Dy()
+	if cap(e.buf) < size {
+		e.buf = make([]byte, size)
+	}
+	buf := e.buf[:size]
+
+	le := binary.LittleEndian
+	copy(buf, "BM")
+	le.PutUint32(buf[2:], uint32(size))
+	le.PutUint32(buf[6:], 0)
+	le.PutUint32(buf[10:], bmpHeaderSize)
+	info := buf[14:bmpHeaderSize]
+	le.PutUint32(info[0:], 40)
+	le.PutUint32(info[4:], uint32(b.Dx()))
+	// a negative height stores the rows top-down
+	le.PutUint32(info[8:], uint32(-b.Dy()))
+	le.PutUint16(info[12:], 1)
+	le.PutUint16(info[14:], 32)
+	// BI_RGB
+	le.PutUint32(info[16:], 0)
+	le.PutUint32(info[20:], uint32(rowSize*b.Dy()))
+	// 72 DPI
+	le.PutUint32(info[24:], 2835)
+	le.PutUint32(info[28:], 2835)
+	le.PutUint32(info[32:], 0)
+	le.PutUint32(info[36:], 0)
+
+	for y := 0; y < b.Dy(); y++ {
+		nrgbaRow(buf[bmpHeaderSize+y*rowSize:][:rowSize], m, b.Min.Y+y, true)
+	}
+	_, err := w.Write(buf)
+	return err
+}
@@ -0,0 +1,243 @@
+package encode
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+
+	"github.com/kirides/screencapture/bgra"
+)
+
+// TileClass is the kind of content of a tile, which decides how it is encoded.
+type TileClass int
+
+const (
+	// TileFlat is a tile of a single color.
+	TileFlat TileClass = iota
+	// TileText is a tile of few colors or sharp edges on flat areas, like text and UI, which is encoded losslessly.
+	TileText
+	// TilePhoto is a tile of many colors and smooth gradients, like photos and video, which is encoded lossy.
+	TilePhoto
+)
+
+func (c TileClass) String() string {
+	switch c {
+	case TileFlat:
+		return "flat"
+	case TileText:
+		return "text"
+	case TilePhoto:
+		return "photo"
+	}
+	return fmt.Sprintf("TileClass(%d)", int(c))
+}
+
+// MaxTileColors is the number of colors counted by a Classifier, tiles with up to MaxTileColors can be palettized.
+const MaxTileColors = 256
+
+const (
+	// textColors is the number of colors up to which a tile is text, regardless of its edges,
+	// unless it is so small that they are more than a quarter of its pixels.
+	textColors = 64
+	// gradientDiff and edgeDiff are the differences of neighboring pixels up to which they are a gradient
+	// and from which they are an edge.
+	gradientDiff = 16
+	edgeDiff     = 64
+	// photoGradients is the fraction of gradients from which a tile is a photo.
+	photoGradients = 0.25
+	// textEdges is the fraction of edges from which a tile that is not a photo is text.
+	textEdges = 0.02
+)
+
+// TileStats are the statistics a tile is classified by.
+type TileStats struct {
+	// Pixels is the number of pixels of the tile.
+	Pixels int
+	// Colors is the number of distinct colors, MaxTileColors+1 if there are more.
+	Colors int
+	// Edges is the fraction of neighboring pixels that differ strongly, like the outlines of text.
+	Edges float64
+	// Gradients is the fraction of neighboring pixels that differ slightly, like smooth shading and noise.
+	Gradients float64
+}
+
+// Class classifies the tile: a single color is flat, few colors or strong edges on flat areas are text,
+// and many colors with smooth gradients are a photo.
+func (s TileStats) Class() TileClass {
+	switch {
+	case s.Colors <= 1:
+		return TileFlat
+	case s.Colors <= textColors && s.Colors*4 <= s.Pixels:
+		return TileText
+	case s.Gradients >= photoGradients:
+		return TilePhoto
+	case s.Edges >= textEdges:
+		return TileText
+	}
+	return TilePhoto
+}
+
+// Classifier computes the statistics of tiles.
+// It keeps its buffers between calls, so it must not be used concurrently.
+type Classifier struct {
+	// slots is an open addressing hash table of the colors, holding their index in palette plus one
+	slots   [4 * MaxTileColors]uint16
+	palette [MaxTileColors]uint32
+	colors  int
+
+	// pix holds the pixels of the last tile, starting at its top-left corner
+	pix    []byte
+	stride int
+	bgr    bool
+	rect   image.Rectangle
+	rgba   *image.RGBA
+}
+
+// Stats returns the statistics of the pixels of m within r.
+func (c *Classifier) Stats(m image.Image, r image.Rectangle) TileStats {
+	r = r.Intersect(m.Bounds())
+	c.pixels(m, r)
+	c.colors = 0
+	for i := range c.slots {
+		c.slots[i] = 0
+	}
+	w, h := r.Dx(), r.Dy()
+	var edges, gradients, pairs int
+	for y := 0; y < h; y++ {
+		row := c.pix[y*c.stride:][:w*4]
+		var up []byte
+		if y > 0 {
+			up = c.pix[(y-1)*c.stride:][:w*4]
+		}
+		for x := 0; x < len(row); x += 4 {
+			if c.colors <= MaxTileColors {
+				c.add(pixel(row[x:]))
+			}
+			var d [2]int
+			n := 0
+			if x > 0 {
+				d[n] = diff(row[x:], row[x-4:])
+				n++
+			}
+			if up != nil {
+				d[n] = diff(row[x:], up[x:])
+				n++
+			}
+			for _, d := range d[:n] {
+				switch {
+				case d >= edgeDiff:
+					edges++
+				case d > 0 && d <= gradientDiff:
+					gradients++
+				}
+			}
+			pairs += n
+		}
+	}
+	s := TileStats{Pixels: w * h, Colors: c.colors}
+	if pairs > 0 {
+		s.Edges = float64(edges) / float64(pairs)
+		s.Gradients = float64(gradients) / float64(pairs)
+	}
+	return s
+}
+
+// pixels points c.pix at the pixels of m within r, drawing them into c.rgba if m is neither RGBA nor BGRA.
+func (c *Classifier) pixels(m image.Image, r image.Rectangle) {
+	c.rect, c.bgr = r, false
+	switch p := m.(type) {
+	case *image.RGBA:
+		c.pix, c.stride = p.Pix[p.PixOffset(r.Min.X, r.Min.Y):], p.Stride
+	case *bgra.Image:
+		c.pix, c.stride, c.bgr = p.Pix[p.PixOffset(r.Min.X, r.Min.Y):], p.Stride, true
+	default:
+		if c.rgba == nil || c.rgba.Rect.Dx() < r.Dx() || c.rgba.Rect.Dy() < r.Dy() {
+			c.rgba = image.NewRGBA(image.Rectangle{Max: r.Size()})
+		}
+		draw.Draw(c.rgba, image.Rectangle{Max: r.Size()}, m, r.Min, draw.Src)
+		c.pix, c.stride = c.rgba.Pix, c.rgba.Stride
+	}
+}
+
+// tile returns the pixels of the last tile as an image, without copying them.
+func (c *Classifier) tile(rgba *image.RGBA, bgr *bgra.Image) image.Image {
+	if c.bgr {
+		*bgr = bgra.Image{Pix: c.pix, Stride: c.stride, Rect: c.rect}
+		return bgr
+	}
+	*rgba = image.RGBA{Pix: c.pix, Stride: c.stride, Rect: c.rect}
+	return rgba
+}
+
+// paletted converts the last tile into dst, which requires it to have at most MaxTileColors colors.
+func (c *Classifier) paletted(dst *image.Paletted) *image.Paletted {
+	w, h := c.rect.Dx(), c.rect.Dy()
+	if cap(dst.Pix) < w*h {
+		dst.Pix = make([]uint8, w*h)
+	}
+	dst.Pix, dst.Stride, dst.Rect = dst.Pix[:w*h], w, c.rect
+	dst.Palette = dst.Palette[:0]
+	for _, p := range c.palette[:c.colors] {
+		rgba := color.RGBA{uint8(p), uint8(p >> 8), uint8(p >> 16), uint8(p >> 24)}
+		if c.bgr {
+			rgba.R, rgba.B = rgba.B, rgba.R
+		}
+		dst.Palette = append(dst.Palette, rgba)
+	}
+	for y := 0; y < h; y++ {
+		row := c.pix[y*c.stride:][:w*4]
+		out := dst.Pix[y*w:][:w]
+		for x := range out {
+			out[x] = uint8(c.index(pixel(row[x*4:])))
+		}
+	}
+	return dst
+}
+
+// add inserts p into the colors, counting up to MaxTileColors+1.
+func (c *Classifier) add(p uint32) {
+	i := c.slot(p)
+	if c.slots[i] != 0 {
+		return
+	}
+	if c.colors < MaxTileColors {
+		c.palette[c.colors] = p
+		c.slots[i] = uint16(c.colors + 1)
+	}
+	c.colors++
+}
+
+// index returns the index of p in the palette, which must contain it.
+func (c *Classifier) index(p uint32) int {
+	return int(c.slots[c.slot(p)]) - 1
+}
+
+// slot returns the slot of p or the empty slot it belongs into.
+func (c *Classifier) slot(p uint32) int {
+	const mask = len(c.slots) - 1
+	i := int(p*0x9e3779b1>>16) & mask
+	for c.slots[i] != 0 && c.palette[c.slots[i]-1] != p {
+		i = (i + 1) & mask
+	}
+	return i
+}
+
+func pixel(p []byte) uint32 {
+	return uint32(p[0]) | uint32(p[1])<<8 | uint32(p[2])<<16 | uint32(p[3])<<24
+}
+
+// diff returns the largest difference of the color components of two pixels.
+func diff(a, b []byte) int {
+	m := 0
+	for i := 0; i < 3; i++ {
+		d := int(a[i]) - int(b[i])
+		if d < 0 {
+			d = -d
+		}
+		if d > m {
+			m = d
+		}
+	}
+	return m
+}
@@ -0,0 +1,184 @@
+// Package encode encodes captured frames into image formats, selected by name.
+//
+// JPEG, PNG, lossless WebP, QOI and BMP are always available, JPEG using libjpeg-turbo when built with the jpegturbo tag
+// and using all cores with the jpegparallel format. The chroma subsampling and quantization tables of JPEG
+// can be tuned for screen content, see Options.
+//
+// A TileEncoder encodes the changed tiles of frames, choosing a lossless or lossy format for every tile by its content.
+// Encoders keep their conversion and output buffers between calls, so they should be reused for every frame of a stream.
+package encode
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"sort"
+	"sync"
+)
+
+// Encoder encodes images into a single format.
+// It keeps buffers between calls, so it must not be used concurrently.
+type Encoder interface {
+	// Encode writes m to w.
+	Encode(w io.Writer, m image.Image) error
+	// MIMEType returns the media type of the encoded images, e.g. "image/jpeg".
+	MIMEType() string
+}
+
+// Options configures an Encoder. The zero value selects the defaults of every format.
+type Options struct {
+	// Quality of lossy formats from 1 to 100, DefaultQuality if zero.
+	Quality int
+	// Subsampling is the chroma subsampling of JPEG images.
+	Subsampling Subsampling
+	// Quant holds the quantization tables of JPEG images, PhotoQuant if nil.
+	// Like the tables of the specification, they are scaled by the quality and used unchanged at quality 50.
+	Quant *QuantTables
+}
+
+// Subsampling is the resolution of the chroma of JPEG images relative to the luma.
+type Subsampling int
+
+const (
+	// Subsample420 stores one chroma sample per 2x2 pixels, the default.
+	Subsample420 Subsampling = iota
+	// Subsample422 stores one chroma sample per 2x1 pixels.
+	Subsample422
+	// Subsample444 stores the chroma of every pixel, which keeps colored text sharp.
+	Subsample444
+)
+
+func (s Subsampling) String() string {
+	switch s {
+	case Subsample420:
+		return "4:2:0"
+	case Subsample422:
+		return "4:2:2"
+	case Subsample444:
+		return "4:4:4"
+	}
+	return fmt.Sprintf("Subsampling(%d)", int(s))
+}
+
+// factors returns the horizontal and vertical sampling factors of luma, chroma is sampled once per MCU.
+func (s Subsampling) factors() (hs, vs int) {
+	switch s {
+	case Subsample422:
+		return 2, 1
+	case Subsample444:
+		return 1, 1
+	}
+	return 2, 2
+}
+
+func (s Subsampling) ratio() image.YCbCrSubsampleRatio {
+	switch s {
+	case Subsample422:
+		return image.YCbCrSubsampleRatio422
+	case Subsample444:
+		return image.YCbCrSubsampleRatio444
+	}
+	return image.YCbCrSubsampleRatio420
+}
+
+// QuantTables are the luminance and chrominance quantization tables of JPEG images, in row-major order.
+type QuantTables struct {
+	Luma, Chroma [64]uint8
+}
+
+// scaled returns the tables scaled for quality like libjpeg and image/jpeg do, in zigzag order.
+func (q *QuantTables) scaled(quality int) (zigzag [2][64]byte) {
+	scale := 200 - 2*quality
+	if quality < 50 {
+		scale = 5000 / quality
+	}
+	for t, table := range [2]*[64]uint8{&q.Luma, &q.Chroma} {
+		for k, i := range unzig {
+			x := (int(table[i])*scale + 50) / 100
+			if x < 1 {
+				x = 1
+			} else if x > 255 {
+				x = 255
+			}
+			zigzag[t][k] = byte(x)
+		}
+	}
+	return zigzag
+}
+
+// DefaultQuality is the quality of lossy formats if none is set.
+const DefaultQuality = 75
+
+// quality returns the quality clamped to 1 to 100.
+func (o Options) quality() int {
+	switch {
+	case o.Quality == 0:
+		return DefaultQuality
+	case o.Quality < 1:
+		return 1
+	case o.Quality > 100:
+		return 100
+	}
+	return o.Quality
+}
+
+// Buffer is a bytes.Buffer for the encoded frames, which should be reset and reused for every frame.
+// It implements Flush, so image/jpeg writes into it directly instead of through a new bufio.Writer.
+type Buffer struct {
+	bytes.Buffer
+}
+
+// Flush does nothing.
+func (*Buffer) Flush() error { return nil }
+
+var (
+	formatsMu sync.RWMutex
+	formats   = make(map[string]func(Options) Encoder)
+)
+
+// Register makes an image format available by the provided name.
+// If Register is called twice with the same name or if newEncoder is nil, it panics.
+func Register(name string, newEncoder func(Options) Encoder) {
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+	if newEncoder == nil {
+		panic("encode: Register newEncoder is nil")
+	}
+	if _, dup := formats[name]; dup {
+		panic("encode: Register called twice for format " + name)
+	}
+	formats[name] = newEncoder
+}
+
+// Formats returns a sorted list of the names of the registered formats.
+func Formats() []string {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	list := make([]string, 0, len(formats))
+	for name := range formats {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// New returns an Encoder of the format registered under name.
+func New(name string, o Options) (Encoder, error) {
+	formatsMu.RLock()
+	newEncoder, ok := formats[name]
+	formatsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("encode: unknown format %q (forgotten build tag?)", name)
+	}
+	return newEncoder(o), nil
+}
+
+func init() {
+	Register("jpeg", newJPEG)
+	Register("jpegparallel", newJPEGParallel)
+	Register("png", newPNG)
+	Register("webp", newWebP)
+	Register("qoi", newQOI)
+	Register("bmp", newBMP)
+}
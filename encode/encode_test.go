@@ -0,0 +1,340 @@
+package encode
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math/rand"
+	"testing"
+
+	"github.com/kirides/screencapture/bgra"
+	"golang.org/x/image/webp"
+)
+
+// testImages returns an opaque screen-like image, with flat areas, gradients and noise, as the supported image types
+// and as a type without a fast path.
+func testImages() []image.Image {
+	r := rand.New(rand.NewSource(1))
+	rgba := image.NewRGBA(image.Rect(-3, 2, 77, 45))
+	for y := rgba.Rect.Min.Y; y < rgba.Rect.Max.Y; y++ {
+		for x := rgba.Rect.Min.X; x < rgba.Rect.Max.X; x++ {
+			c := color.RGBA{200, 200, 210, 255}
+			switch {
+			case x > 40:
+				n := uint8(r.Intn(16))
+				c = color.RGBA{uint8(x*2) + n, 100 + n, uint8(y*4) + n, 255}
+			case y > 20:
+				c = color.RGBA{uint8(x * 3), uint8(y * 5), uint8(x + y), 255}
+			}
+			rgba.SetRGBA(x, y, c)
+		}
+	}
+	sub := rgba.SubImage(image.Rect(-1, 3, 70, 44)).(*image.RGBA)
+
+	b := bgra.New(sub.Rect)
+	nrgba := image.NewNRGBA(sub.Rect)
+	ycbcr := image.NewYCbCr(sub.Rect, image.YCbCrSubsampleRatio444)
+	for y := sub.Rect.Min.Y; y < sub.Rect.Max.Y; y++ {
+		for x := sub.Rect.Min.X; x < sub.Rect.Max.X; x++ {
+			c := sub.RGBAAt(x, y)
+			b.SetRGBA(x, y, c)
+			nrgba.Set(x, y, c)
+			yy, cb, cr := color.RGBToYCbCr(c.R, c.G, c.B)
+			ycbcr.Y[ycbcr.YOffset(x, y)], ycbcr.Cb[ycbcr.COffset(x, y)], ycbcr.Cr[ycbcr.COffset(x, y)] = yy, cb, cr
+		}
+	}
+	return []image.Image{sub, b, nrgba, ycbcr}
+}
+
+// translucentImage returns an image with all kinds of alpha values.
+func translucentImage() *image.RGBA {
+	r := rand.New(rand.NewSource(2))
+	m := image.NewRGBA(image.Rect(0, 0, 33, 17))
+	for y := 0; y < 17; y++ {
+		for x := 0; x < 33; x++ {
+			a := uint8(r.Intn(256))
+			if x < 10 {
+				a = uint8(x * 28)
+			}
+			m.SetRGBA(x, y, color.RGBA{uint8(r.Intn(int(a) + 1)), uint8(r.Intn(int(a) + 1)), a / 2, a})
+		}
+	}
+	return m
+}
+
+// compare checks that every pixel of got is within tol of want, both converted to non-premultiplied colors.
+// JPEG images are compared by their average error instead.
+func compare(t *testing.T, name string, got, want image.Image, tol int, average bool) {
+	t.Helper()
+	if got.Bounds().Size() != want.Bounds().Size() {
+		t.Fatalf("%s: size %v, want %v", name, got.Bounds().Size(), want.Bounds().Size())
+	}
+	var sum, n int
+	for y := 0; y < want.Bounds().Dy(); y++ {
+		for x := 0; x < want.Bounds().Dx(); x++ {
+			g := color.NRGBAModel.Convert(got.At(got.Bounds().Min.X+x, got.Bounds().Min.Y+y)).(color.NRGBA)
+			w := color.NRGBAModel.Convert(want.At(want.Bounds().Min.X+x, want.Bounds().Min.Y+y)).(color.NRGBA)
+			for i, d := range []int{int(g.R) - int(w.R), int(g.G) - int(w.G), int(g.B) - int(w.B), int(g.A) - int(w.A)} {
+				if d < 0 {
+					d = -d
+				}
+				if !average && d > tol {
+					t.Fatalf("%s: component %d of pixel (%d, %d) is %v, want %v", name, i, x, y, g, w)
+				}
+				sum += d
+				n++
+			}
+		}
+	}
+	if average && sum > tol*n {
+		t.Fatalf("%s: average error %.2f, want at most %d", name, float64(sum)/float64(n), tol)
+	}
+}
+
+// decodeQOI decodes a QOI image, following the reference decoder.
+func decodeQOI(data []byte) (*image.NRGBA, byte, error) {
+	if len(data) < 14+8 || string(data[:4]) != "qoif" {
+		return nil, 0, fmt.Errorf("not a QOI image")
+	}
+	w, h := int(binary.BigEndian.Uint32(data[4:])), int(binary.BigEndian.Uint32(data[8:]))
+	channels := data[12]
+	if !bytes.Equal(data[len(data)-8:], qoiEnd[:]) {
+		return nil, 0, fmt.Errorf("missing end marker")
+	}
+	m := image.NewNRGBA(image.Rect(0, 0, w, h))
+	chunks := data[14 : len(data)-8]
+	var index [64][4]byte
+	px := [4]byte{0, 0, 0, 255}
+	run := 0
+	for i := 0; i < len(m.Pix); i += 4 {
+		if run > 0 {
+			run--
+		} else {
+			if len(chunks) == 0 {
+				return nil, 0, fmt.Errorf("missing chunks")
+			}
+			b1 := chunks[0]
+			chunks = chunks[1:]
+			switch {
+			case b1 == qoiRGB:
+				copy(px[:3], chunks[:3])
+				chunks = chunks[3:]
+			case b1 == qoiRGBA:
+				copy(px[:], chunks[:4])
+				chunks = chunks[4:]
+			case b1&0xc0 == qoiIndex:
+				px = index[b1]
+			case b1&0xc0 == qoiDiff:
+				px[0] += (b1>>4)&3 - 2
+				px[1] += (b1>>2)&3 - 2
+				px[2] += b1&3 - 2
+			case b1&0xc0 == qoiLuma:
+				b2 := chunks[0]
+				chunks = chunks[1:]
+				vg := b1&0x3f - 32
+				px[0] += vg - 8 + (b2>>4)&0xf
+				px[1] += vg
+				px[2] += vg - 8 + b2&0xf
+			case b1&0xc0 == qoiRun:
+				run = int(b1 & 0x3f)
+			}
+			index[(int(px[0])*3+int(px[1])*5+int(px[2])*7+int(px[3])*11)%64] = px
+		}
+		copy(m.Pix[i:], px[:])
+	}
+	if len(chunks) != 0 {
+		return nil, 0, fmt.Errorf("%d bytes left", len(chunks))
+	}
+	return m, channels, nil
+}
+
+// decodeBMP decodes the top-down 32 bit BMP images written by the encoder.
+func decodeBMP(data []byte) (*image.NRGBA, error) {
+	le := binary.LittleEndian
+	if len(data) < bmpHeaderSize || string(data[:2]) != "BM" || int(le.Uint32(data[2:])) != len(data) {
+		return nil, fmt.Errorf("not a BMP image")
+	}
+	off := int(le.Uint32(data[10:]))
+	w, h := int(int32(le.Uint32(data[18:]))), int(int32(le.Uint32(data[22:])))
+	if h >= 0 || le.Uint16(data[28:]) != 32 || le.Uint32(data[30:]) != 0 || off+w*-h*4 != len(data) {
+		return nil, fmt.Errorf("unexpected BMP header")
+	}
+	m := image.NewNRGBA(image.Rect(0, 0, w, -h))
+	for i := 0; i < len(m.Pix); i += 4 {
+		p := data[off+i:]
+		m.Pix[i], m.Pix[i+1], m.Pix[i+2], m.Pix[i+3] = p[2], p[1], p[0], p[3]
+	}
+	return m, nil
+}
+
+func decode(format string, data []byte) (image.Image, error) {
+	switch format {
+	case "jpeg", "jpegparallel", "jpegturbo":
+		return jpeg.Decode(bytes.NewReader(data))
+	case "png":
+		return png.Decode(bytes.NewReader(data))
+	case "qoi":
+		m, _, err := decodeQOI(data)
+		return m, err
+	case "bmp":
+		return decodeBMP(data)
+	case "webp":
+		return webp.Decode(bytes.NewReader(data))
+	}
+	return nil, fmt.Errorf("no decoder for %s", format)
+}
+
+func TestFormats(t *testing.T) {
+	mimeTypes := map[string]string{
+		"jpeg": "image/jpeg", "jpegparallel": "image/jpeg", "jpegturbo": "image/jpeg",
+		"png": "image/png", "qoi": "image/qoi", "bmp": "image/bmp",
+		"webp": "image/webp",
+	}
+	formats := Formats()
+	for _, name := range []string{"bmp", "jpeg", "jpegparallel", "png", "qoi", "webp"} {
+		if !contains(formats, name) {
+			t.Errorf("format %s is not registered", name)
+		}
+	}
+	for _, name := range formats {
+		e, err := New(name, Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := e.MIMEType(); got != mimeTypes[name] {
+			t.Errorf("MIME type of %s is %q, want %q", name, got, mimeTypes[name])
+		}
+	}
+	if _, err := New("gif", Options{}); err == nil {
+		t.Error("New of an unregistered format succeeded")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a format twice did not panic")
+		}
+	}()
+	Register("png", newPNG)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func TestEncode(t *testing.T) {
+	images := testImages()
+	for _, name := range Formats() {
+		e, _ := New(name, Options{Quality: 95})
+		lossy := e.MIMEType() == "image/jpeg"
+		var buf Buffer
+		// twice, to reuse the buffers of the encoder
+		for i := 0; i < 2; i++ {
+			for _, m := range images {
+				desc := fmt.Sprintf("%s of %T", name, m)
+				buf.Reset()
+				if err := e.Encode(&buf, m); err != nil {
+					t.Fatalf("%s: %v", desc, err)
+				}
+				got, err := decode(name, buf.Bytes())
+				if err != nil {
+					t.Fatalf("%s: %v", desc, err)
+				}
+				if lossy {
+					compare(t, desc, got, images[0], 3, true)
+				} else {
+					compare(t, desc, got, m, 0, false)
+				}
+			}
+		}
+	}
+}
+
+func TestEncodeAlpha(t *testing.T) {
+	m := translucentImage()
+	for _, name := range []string{"png", "qoi", "bmp", "webp"} {
+		e, _ := New(name, Options{})
+		var buf Buffer
+		if err := e.Encode(&buf, m); err != nil {
+			t.Fatal(err)
+		}
+		got, err := decode(name, buf.Bytes())
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		compare(t, name, got, m, 0, false)
+	}
+}
+
+func TestQOIChannels(t *testing.T) {
+	e := newQOI(Options{})
+	for _, c := range []struct {
+		m    image.Image
+		want byte
+	}{
+		{testImages()[0], 3},
+		{translucentImage(), 4},
+		// transparent black is found in the zeroed index without an RGBA chunk
+		{image.NewRGBA(image.Rect(0, 0, 70, 1)), 4},
+	} {
+		var buf Buffer
+		e.Encode(&buf, c.m)
+		if _, got, err := decodeQOI(buf.Bytes()); err != nil || got != c.want {
+			t.Errorf("%v: channels %d, %v, want %d", c.m.Bounds(), got, err, c.want)
+		}
+	}
+}
+
+func TestQuality(t *testing.T) {
+	for _, c := range [][2]int{{0, DefaultQuality}, {-5, 1}, {1, 1}, {50, 50}, {101, 100}} {
+		if got := (Options{Quality: c[0]}).quality(); got != c[1] {
+			t.Errorf("quality %d = %d, want %d", c[0], got, c[1])
+		}
+	}
+}
+
+func TestEncodeAllocs(t *testing.T) {
+	m := testImages()[1]
+	for _, name := range []string{"qoi", "bmp", "jpegparallel", "webp"} {
+		e, _ := New(name, Options{})
+		var buf Buffer
+		e.Encode(&buf, m)
+		if n := testing.AllocsPerRun(10, func() {
+			buf.Reset()
+			e.Encode(&buf, m)
+		}); n != 0 {
+			t.Errorf("%s: %v allocations per frame, want 0", name, n)
+		}
+	}
+}
+
+func BenchmarkEncode(b *testing.B) {
+	m := bgra.New(image.Rect(0, 0, 1920, 1080))
+	for _, img := range testImages()[:1] {
+		// tile the test image, like a desktop with windows
+		src := img.(*image.RGBA)
+		for y := 0; y < 1080; y++ {
+			for x := 0; x < 1920; x++ {
+				m.SetRGBA(x, y, src.RGBAAt(src.Rect.Min.X+x%src.Rect.Dx(), src.Rect.Min.Y+y%src.Rect.Dy()))
+			}
+		}
+	}
+	for _, name := range Formats() {
+		b.Run(name, func(b *testing.B) {
+			e, _ := New(name, Options{})
+			var buf Buffer
+			b.SetBytes(int64(len(m.Pix)))
+			for i := 0; i < b.N; i++ {
+				buf.Reset()
+				e.Encode(&buf, m)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package encode
+
+import (
+	"image"
+	"image/jpeg"
+	"io"
+
+	"github.com/kirides/screencapture/bgra"
+	"github.com/kirides/screencapture/parallel"
+)
+
+// jpegEncoder encodes JPEG images using image/jpeg.
+type jpegEncoder struct {
+	opts  jpeg.Options
+	ycbcr *image.YCbCr
+}
+
+// serialPool runs the jpegparallel encoder of the jpeg format on the calling goroutine.
+var serialPool = parallel.NewPool(1)
+
+func newJPEG(o Options) Encoder {
+	if o.Subsampling != Subsample420 || o.Quant != nil {
+		// image/jpeg always uses 4:2:0 and the tables of the specification
+		return newJPEGParallelPool(o, serialPool)
+	}
+	return &jpegEncoder{opts: jpeg.Options{Quality: o.quality()}}
+}
+
+func (e *jpegEncoder) MIMEType() string { return "image/jpeg" }
+
+func (e *jpegEncoder) Encode(w io.Writer, m image.Image) error {
+	if p, ok := m.(*bgra.Image); ok {
+		// image/jpeg encodes YCbCr images without a per pixel color conversion
+		e.ycbcr = toYCbCr(p, e.ycbcr)
+		m = e.ycbcr
+	}
+	return jpeg.Encode(w, m, &e.opts)
+}
+
+// toYCbCr converts p like p.ToYCbCr, but into an image at the origin,
+// whose chroma samples line up with every pair of pixels of p regardless of where p starts.
+func toYCbCr(p *bgra.Image, dst *image.YCbCr) *image.YCbCr {
+	q := *p
+	q.Rect = p.Rect.Sub(p.Rect.Min)
+	return q.ToYCbCr(dst)
+}
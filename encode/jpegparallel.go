@@ -0,0 +1,428 @@
+package encode
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/draw"
+	"io"
+	"math"
+	"math/bits"
+
+	"github.com/kirides/screencapture/bgra"
+	"github.com/kirides/screencapture/parallel"
+	"github.com/kirides/screencapture/swizzle"
+)
+
+// minJPEGBandRows is the smallest number of MCU rows of a band.
+const minJPEGBandRows = 2
+
+// jpegParallel encodes baseline JPEG images, splitting them into bands of MCU rows that are encoded concurrently.
+//
+// Every MCU row ends with a restart marker, which resets the DC prediction and aligns the entropy coded data to bytes,
+// so the bands are independent and concatenated as they are. The output does not depend on the number of bands.
+type jpegParallel struct {
+	pool *parallel.Pool
+	// hs and vs are the horizontal and vertical sampling factors of luma, chroma is sampled once per MCU
+	hs, vs int
+	ratio  image.YCbCrSubsampleRatio
+	// quant holds the luminance and chrominance quantization tables in zigzag order, as written to DQT
+	quant [2][64]byte
+	// divisors holds the reciprocals of the quantizers scaled by the AAN DCT factors, in natural order
+	divisors [2][64]float32
+	huffman  [4]huffmanTable
+
+	ycbcr  *image.YCbCr
+	rgba   *image.RGBA
+	header []byte
+	bands  []*jpegBand
+	task   jpegTask
+}
+
+// jpegTask holds the frame being encoded, it is kept in the encoder so running it does not allocate.
+type jpegTask struct {
+	e *jpegParallel
+	// src holds the RGBA or BGRA pixels to convert into e.ycbcr, or nil if it was converted already
+	src       []byte
+	srcStride int
+	bgr       bool
+}
+
+// jpegBand holds the entropy coded MCU rows of a band.
+type jpegBand struct {
+	buf   []byte
+	bits  uint64
+	nbits uint
+	block [64]float32
+}
+
+// huffmanTable maps symbols to their codes.
+type huffmanTable struct {
+	code [256]uint16
+	size [256]uint8
+}
+
+// jpegYUV is the color conversion of JPEG.
+var jpegYUV = swizzle.YUVOptions{Matrix: swizzle.BT601, FullRange: true}
+
+func newJPEGParallel(o Options) Encoder {
+	return newJPEGParallelPool(o, parallel.Default)
+}
+
+func newJPEGParallelPool(o Options, pool *parallel.Pool) *jpegParallel {
+	e := &jpegParallel{pool: pool, ratio: o.Subsampling.ratio()}
+	e.hs, e.vs = o.Subsampling.factors()
+	e.task.e = e
+	quant := o.Quant
+	if quant == nil {
+		quant = PhotoQuant
+	}
+	e.quant = quant.scaled(o.quality())
+	for t := range e.quant {
+		for k, x := range e.quant[t] {
+			i := unzig[k]
+			e.divisors[t][i] = float32(1 / (float64(x) * aanScale[i/8] * aanScale[i%8] * 8))
+		}
+	}
+	for i, spec := range huffmanSpecs {
+		e.huffman[i] = newHuffmanTable(spec)
+	}
+	return e
+}
+
+// aanScale are the factors by which the AAN DCT scales the coefficients of each row and column.
+var aanScale = func() (s [8]float64) {
+	s[0] = 1
+	for k := 1; k < 8; k++ {
+		s[k] = math.Cos(float64(k)*math.Pi/16) * math.Sqrt2
+	}
+	return s
+}()
+
+func newHuffmanTable(spec huffmanSpec) huffmanTable {
+	var t huffmanTable
+	code, k := uint16(0), 0
+	for size, n := range spec.count {
+		for i := 0; i < int(n); i++ {
+			sym := spec.symbol[k]
+			t.code[sym], t.size[sym] = code, uint8(size+1)
+			code++
+			k++
+		}
+		code <<= 1
+	}
+	return t
+}
+
+func (e *jpegParallel) MIMEType() string { return "image/jpeg" }
+
+func (e *jpegParallel) Encode(w io.Writer, m image.Image) error {
+	b := m.Bounds()
+	if b.Dx() > 0xffff || b.Dy() > 0xffff {
+		return errors.New("encode: image is too large for JPEG")
+	}
+	if b.Empty() {
+		return errors.New("encode: image is empty")
+	}
+	t := &e.task
+	t.src, t.srcStride, t.bgr = nil, 0, false
+	switch p := m.(type) {
+	case *image.RGBA:
+		t.src, t.srcStride = p.Pix[p.PixOffset(b.Min.X, b.Min.Y):], p.Stride
+	case *bgra.Image:
+		t.src, t.srcStride, t.bgr = p.Pix[p.PixOffset(b.Min.X, b.Min.Y):], p.Stride, true
+	default:
+		if e.rgba == nil || e.rgba.Rect != b {
+			e.rgba = image.NewRGBA(b)
+		}
+		draw.Draw(e.rgba, b, m, b.Min, draw.Src)
+		t.src, t.srcStride = e.rgba.Pix, e.rgba.Stride
+	}
+	size := b.Size()
+	if e.ycbcr == nil || e.ycbcr.Rect.Size() != size {
+		e.ycbcr = image.NewYCbCr(image.Rectangle{Max: size}, e.ratio)
+	}
+
+	mcuRows := (size.Y + 8*e.vs - 1) / (8 * e.vs)
+	for n := e.pool.Bands(mcuRows, minJPEGBandRows); len(e.bands) < n; {
+		e.bands = append(e.bands, &jpegBand{})
+	}
+	e.pool.Run(mcuRows, minJPEGBandRows, t)
+	t.src = nil
+
+	e.header = e.appendHeader(e.header[:0], size)
+	if _, err := w.Write(e.header); err != nil {
+		return err
+	}
+	for _, band := range e.bands[:e.pool.Bands(mcuRows, minJPEGBandRows)] {
+		if _, err := w.Write(band.buf); err != nil {
+			return err
+		}
+	}
+	_, err := w.Write(eoi[:])
+	return err
+}
+
+var eoi = [2]byte{0xff, 0xd9}
+
+// Band converts and encodes the MCU rows r0 to r1.
+func (t *jpegTask) Band(i, r0, r1 int) {
+	e := t.e
+	y := e.ycbcr
+	mcuW, mcuH := 8*e.hs, 8*e.vs
+	w, h := y.Rect.Dx(), y.Rect.Dy()
+	if t.src != nil {
+		y0, y1 := r0*mcuH, r1*mcuH
+		if y1 > h {
+			y1 = h
+		}
+		// the rows of the band, starting at an MCU row so the chroma rows are not shared with other bands
+		rows := image.YCbCr{
+			Y:              y.Y[y0*y.YStride:],
+			Cb:             y.Cb[y0/e.vs*y.CStride:],
+			Cr:             y.Cr[y0/e.vs*y.CStride:],
+			YStride:        y.YStride,
+			CStride:        y.CStride,
+			SubsampleRatio: e.ratio,
+			Rect:           image.Rect(0, 0, w, y1-y0),
+		}
+		if t.bgr {
+			swizzle.BGRAToYCbCr(&rows, t.src[y0*t.srcStride:], t.srcStride, jpegYUV)
+		} else {
+			swizzle.RGBAToYCbCr(&rows, t.src[y0*t.srcStride:], t.srcStride, jpegYUV)
+		}
+	}
+
+	band := e.bands[i]
+	band.buf = band.buf[:0]
+	cw, ch := (w+e.hs-1)/e.hs, (h+e.vs-1)/e.vs
+	mcus := (w + mcuW - 1) / mcuW
+	lastRow := (h+mcuH-1)/mcuH - 1
+	for r := r0; r < r1; r++ {
+		var dcY, dcCb, dcCr int32
+		for mx := 0; mx < mcus; mx++ {
+			for by := 0; by < e.vs; by++ {
+				for bx := 0; bx < e.hs; bx++ {
+					band.load(y.Y, y.YStride, mx*mcuW+bx*8, r*mcuH+by*8, w, h)
+					dcY = band.encode(&e.divisors[0], dcY, &e.huffman[0], &e.huffman[1])
+				}
+			}
+			band.load(y.Cb, y.CStride, mx*8, r*8, cw, ch)
+			dcCb = band.encode(&e.divisors[1], dcCb, &e.huffman[2], &e.huffman[3])
+			band.load(y.Cr, y.CStride, mx*8, r*8, cw, ch)
+			dcCr = band.encode(&e.divisors[1], dcCr, &e.huffman[2], &e.huffman[3])
+		}
+		band.pad()
+		if r != lastRow {
+			band.buf = append(band.buf, 0xff, 0xd0+byte(r%8))
+		}
+	}
+}
+
+// load reads the 8x8 block at x, y of a plane of w x h samples, repeating the last column and row beyond its edges,
+// and shifts the samples to be centered around zero.
+func (b *jpegBand) load(plane []byte, stride, x, y, w, h int) {
+	if x+8 <= w && y+8 <= h {
+		for r := 0; r < 8; r++ {
+			row := plane[(y+r)*stride+x:][:8]
+			blk := b.block[r*8 : r*8+8]
+			for c, v := range row {
+				blk[c] = float32(v) - 128
+			}
+		}
+		return
+	}
+	for r := 0; r < 8; r++ {
+		sy := y + r
+		if sy >= h {
+			sy = h - 1
+		}
+		row := plane[sy*stride:]
+		for c := 0; c < 8; c++ {
+			sx := x + c
+			if sx >= w {
+				sx = w - 1
+			}
+			b.block[r*8+c] = float32(row[sx]) - 128
+		}
+	}
+}
+
+// encode transforms, quantizes and entropy codes the loaded block, returning its DC coefficient.
+func (b *jpegBand) encode(divisors *[64]float32, prevDC int32, dc, ac *huffmanTable) int32 {
+	fdct(&b.block)
+	// the coefficients in zigzag order and a bit for every non-zero one
+	var coef [64]int32
+	var nonZero uint64
+	for k, i := range unzig {
+		// adding 1.5 * 2^23 rounds to an integer in the low bits of the mantissa
+		c := int32(math.Float32bits(b.block[i]*divisors[i]+roundFloat) - roundFloatBits)
+		coef[k] = c
+		if c != 0 {
+			nonZero |= 1 << uint(k)
+		}
+	}
+
+	b.emitValue(dc, 0, coef[0]-prevDC)
+	last := 0
+	for nonZero &^= 1; nonZero != 0; nonZero &= nonZero - 1 {
+		k := bits.TrailingZeros64(nonZero)
+		run := k - last - 1
+		for ; run > 15; run -= 16 {
+			// ZRL, a run of 16 zeros
+			b.emit(uint32(ac.code[0xf0]), uint(ac.size[0xf0]))
+		}
+		b.emitValue(ac, run, coef[k])
+		last = k
+	}
+	if last != 63 {
+		// EOB
+		b.emit(uint32(ac.code[0]), uint(ac.size[0]))
+	}
+	return coef[0]
+}
+
+const (
+	roundFloat     = 1.5 * (1 << 23)
+	roundFloatBits = 0x4b400000
+)
+
+// emitValue writes the symbol of the zero run and the size of v, followed by the bits of v.
+func (b *jpegBand) emitValue(t *huffmanTable, run int, v int32) {
+	a := v
+	if a < 0 {
+		a = -a
+		// negative values are written in one's complement
+		v--
+	}
+	n := uint(bits.Len32(uint32(a)))
+	sym := byte(run<<4) | byte(n)
+	b.emit(uint32(t.code[sym]), uint(t.size[sym]))
+	if n > 0 {
+		b.emit(uint32(v)&(1<<n-1), n)
+	}
+}
+
+// emit writes the n low bits of code, n is at most 16.
+// The bits are written in words of 32 bits, stuffing a zero byte after every 0xff.
+func (b *jpegBand) emit(code uint32, n uint) {
+	b.bits = b.bits<<n | uint64(code)
+	b.nbits += n
+	if b.nbits < 32 {
+		return
+	}
+	b.nbits -= 32
+	w := uint32(b.bits >> b.nbits)
+	// a byte of w is 0xff if it is zero in ^w
+	if (^w-0x01010101)&w&0x80808080 == 0 {
+		b.buf = append(b.buf, byte(w>>24), byte(w>>16), byte(w>>8), byte(w))
+		return
+	}
+	for shift := 24; shift >= 0; shift -= 8 {
+		c := byte(w >> uint(shift))
+		b.buf = append(b.buf, c)
+		if c == 0xff {
+			b.buf = append(b.buf, 0)
+		}
+	}
+}
+
+// pad fills the last byte with one bits and writes the remaining bytes.
+func (b *jpegBand) pad() {
+	if n := b.nbits % 8; n > 0 {
+		b.bits = b.bits<<(8-n) | 1<<(8-n) - 1
+		b.nbits += 8 - n
+	}
+	for b.nbits > 0 {
+		b.nbits -= 8
+		c := byte(b.bits >> b.nbits)
+		b.buf = append(b.buf, c)
+		if c == 0xff {
+			b.buf = append(b.buf, 0)
+		}
+	}
+	b.bits = 0
+}
+
+// fdct computes the scaled forward DCT of the block using the AAN algorithm, see jfdctflt.c of libjpeg.
+// The coefficients are scaled by aanScale[u] * aanScale[v] * 8, which is undone by the divisors.
+func fdct(b *[64]float32) {
+	for i := 0; i < 8; i++ {
+		fdct8(b[i*8:i*8+8:i*8+8], 1)
+	}
+	for i := 0; i < 8; i++ {
+		fdct8(b[i:], 8)
+	}
+}
+
+// fdct8 transforms the 8 values of d that are step apart.
+func fdct8(d []float32, step int) {
+	_ = d[7*step]
+	tmp0, tmp7 := d[0]+d[7*step], d[0]-d[7*step]
+	tmp1, tmp6 := d[step]+d[6*step], d[step]-d[6*step]
+	tmp2, tmp5 := d[2*step]+d[5*step], d[2*step]-d[5*step]
+	tmp3, tmp4 := d[3*step]+d[4*step], d[3*step]-d[4*step]
+
+	// even part
+	tmp10, tmp13 := tmp0+tmp3, tmp0-tmp3
+	tmp11, tmp12 := tmp1+tmp2, tmp1-tmp2
+	d[0], d[4*step] = tmp10+tmp11, tmp10-tmp11
+	z1 := (tmp12 + tmp13) * 0.707106781
+	d[2*step], d[6*step] = tmp13+z1, tmp13-z1
+
+	// odd part
+	tmp10, tmp11, tmp12 = tmp4+tmp5, tmp5+tmp6, tmp6+tmp7
+	z5 := (tmp10 - tmp12) * 0.382683433
+	z2 := 0.541196100*tmp10 + z5
+	z4 := 1.306562965*tmp12 + z5
+	z3 := tmp11 * 0.707106781
+	z11, z13 := tmp7+z3, tmp7-z3
+	d[5*step], d[3*step] = z13+z2, z13-z2
+	d[step], d[7*step] = z11+z4, z11-z4
+}
+
+// appendHeader appends the segments from SOI to SOS of an image of the given size.
+func (e *jpegParallel) appendHeader(dst []byte, size image.Point) []byte {
+	be := binary.BigEndian
+	segment := func(marker byte, length int) {
+		dst = append(dst, 0xff, marker, byte((length+2)>>8), byte(length+2))
+	}
+	dst = append(dst, 0xff, 0xd8)
+
+	// DQT
+	segment(0xdb, 2*65)
+	for t := range e.quant {
+		dst = append(dst, byte(t))
+		dst = append(dst, e.quant[t][:]...)
+	}
+
+	// SOF0, baseline
+	segment(0xc0, 6+3*3)
+	dst = append(dst, 8, 0, 0, 0, 0, 3)
+	be.PutUint16(dst[len(dst)-5:], uint16(size.Y))
+	be.PutUint16(dst[len(dst)-3:], uint16(size.X))
+	dst = append(dst, 1, byte(e.hs<<4|e.vs), 0, 2, 0x11, 1, 3, 0x11, 1)
+
+	// DHT
+	n := 0
+	for _, spec := range huffmanSpecs {
+		n += 1 + 16 + len(spec.symbol)
+	}
+	segment(0xc4, n)
+	for i, spec := range huffmanSpecs {
+		// DC tables are class 0, AC tables class 1; luminance uses table 0, chrominance table 1
+		dst = append(dst, byte(i%2<<4|i/2))
+		dst = append(dst, spec.count[:]...)
+		dst = append(dst, spec.symbol...)
+	}
+
+	// DRI, a restart interval of one MCU row
+	segment(0xdd, 2)
+	mcus := (size.X + 8*e.hs - 1) / (8 * e.hs)
+	dst = append(dst, byte(mcus>>8), byte(mcus))
+
+	// SOS
+	segment(0xda, 1+3*2+3)
+	dst = append(dst, 3, 1, 0x00, 2, 0x11, 3, 0x11, 0, 63, 0)
+	return dst
+}
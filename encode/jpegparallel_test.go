@@ -0,0 +1,268 @@
+package encode
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math/rand"
+	"testing"
+
+	"github.com/kirides/screencapture/bgra"
+	"github.com/kirides/screencapture/parallel"
+)
+
+// screenImage returns an image of the given size with the contents of testImages repeated.
+func screenImage(w, h int) *bgra.Image {
+	src := testImages()[0].(*image.RGBA)
+	m := bgra.New(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			m.SetRGBA(x, y, src.RGBAAt(src.Rect.Min.X+x%src.Rect.Dx(), src.Rect.Min.Y+y%src.Rect.Dy()))
+		}
+	}
+	return m
+}
+
+func TestJPEGParallel(t *testing.T) {
+	serial := parallel.NewPool(1)
+	pool := parallel.NewPool(4)
+	defer pool.Close()
+	for _, size := range []image.Point{{1, 1}, {15, 17}, {16, 16}, {33, 200}, {200, 33}, {257, 129}} {
+		m := screenImage(size.X, size.Y)
+		for _, quality := range []int{10, 75, 100} {
+			name := fmt.Sprintf("%v quality %d", size, quality)
+			var want, got, std Buffer
+			if err := newJPEGParallelPool(Options{Quality: quality}, serial).Encode(&want, m); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if err := newJPEGParallelPool(Options{Quality: quality}, pool).Encode(&got, m); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			// the bands are joined at restart markers, so the output does not depend on their number
+			if !bytes.Equal(got.Bytes(), want.Bytes()) {
+				t.Fatalf("%s: concurrently encoded image differs from the one encoded by a single goroutine", name)
+			}
+
+			img, err := jpeg.Decode(bytes.NewReader(got.Bytes()))
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			jpeg.Encode(&std, m, &jpeg.Options{Quality: quality})
+			stdImg, _ := jpeg.Decode(bytes.NewReader(std.Bytes()))
+			if d, stdD := meanError(img, m), meanError(stdImg, m); d > stdD*1.1+0.5 {
+				t.Errorf("%s: mean error %.2f, image/jpeg %.2f", name, d, stdD)
+			}
+			if n := len(got.Bytes()); n > len(std.Bytes())*11/10+size.Y/16*2+16 {
+				t.Errorf("%s: %d bytes, image/jpeg %d", name, n, len(std.Bytes()))
+			}
+			if n, rows := restartMarkers(got.Bytes()), (size.Y+15)/16; n != rows-1 {
+				t.Errorf("%s: %d restart markers, want %d", name, n, rows-1)
+			}
+		}
+	}
+}
+
+// textImage returns an image of red and blue strokes on a light background, like the syntax highlighting of an editor.
+func textImage(w, h int) *bgra.Image {
+	r := rand.New(rand.NewSource(4))
+	m := bgra.New(image.Rect(0, 0, w, h))
+	for i := range m.Pix {
+		m.Pix[i] = 250
+	}
+	colors := []color.RGBA{{200, 30, 30, 255}, {30, 60, 220, 255}}
+	// a glyph of up to three strokes in every 7x12 cell
+	for cy := 0; cy+12 <= h; cy += 12 {
+		for cx := 0; cx+7 <= w; cx += 7 {
+			c := colors[r.Intn(len(colors))]
+			for n := r.Intn(4); n > 0; n-- {
+				if r.Intn(2) == 0 {
+					x := cx + 1 + r.Intn(5)
+					for y := cy + 2; y < cy+10; y++ {
+						m.SetRGBA(x, y, c)
+					}
+				} else {
+					y := cy + 2 + r.Intn(8)
+					for x := cx + 1; x < cx+6; x++ {
+						m.SetRGBA(x, y, c)
+					}
+				}
+			}
+		}
+	}
+	return m
+}
+
+// sofSampling returns the sampling factors of the first component of a JPEG image.
+func sofSampling(data []byte) byte {
+	i := bytes.Index(data, []byte{0xff, 0xc0})
+	if i < 0 || i+11 >= len(data) {
+		return 0
+	}
+	return data[i+11]
+}
+
+func TestJPEGSubsampling(t *testing.T) {
+	m := textImage(131, 75)
+	pool := parallel.NewPool(4)
+	defer pool.Close()
+	for _, name := range []string{"jpeg", "jpegparallel", "jpegturbo"} {
+		if !contains(Formats(), name) {
+			continue
+		}
+		var errs [3]float64
+		for _, s := range []Subsampling{Subsample420, Subsample422, Subsample444} {
+			desc := fmt.Sprintf("%s %v", name, s)
+			e, _ := New(name, Options{Quality: 90, Subsampling: s})
+			var buf Buffer
+			if err := e.Encode(&buf, m); err != nil {
+				t.Fatalf("%s: %v", desc, err)
+			}
+			hs, vs := s.factors()
+			if got := sofSampling(buf.Bytes()); got != byte(hs<<4|vs) {
+				t.Errorf("%s: sampling factors %#x, want %#x", desc, got, hs<<4|vs)
+			}
+			img, err := jpeg.Decode(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatalf("%s: %v", desc, err)
+			}
+			errs[s] = meanError(img, m)
+
+			if name == "jpegparallel" {
+				var got Buffer
+				newJPEGParallelPool(Options{Quality: 90, Subsampling: s}, pool).Encode(&got, m)
+				if !bytes.Equal(got.Bytes(), buf.Bytes()) {
+					t.Errorf("%s: concurrently encoded image differs from the one encoded by a single goroutine", desc)
+				}
+				if n, rows := restartMarkers(got.Bytes()), (m.Rect.Dy()+8*vs-1)/(8*vs); n != rows-1 {
+					t.Errorf("%s: %d restart markers, want %d", desc, n, rows-1)
+				}
+			}
+		}
+		// the colored strokes are smeared by subsampling the chroma
+		if errs[Subsample444] >= errs[Subsample422] || errs[Subsample422] >= errs[Subsample420] {
+			t.Errorf("%s: mean errors of 4:2:0, 4:2:2 and 4:4:4 are %.2f", name, errs)
+		}
+	}
+}
+
+func TestQuantTables(t *testing.T) {
+	// at quality 50, the tables are used unchanged
+	if got := PhotoQuant.scaled(50); got != unscaledQuant {
+		t.Errorf("PhotoQuant at quality 50 is %v, want %v", got, unscaledQuant)
+	}
+	for _, quality := range []int{1, 100} {
+		for _, table := range ScreenQuant.scaled(quality) {
+			for _, x := range table {
+				if x < 1 {
+					t.Fatalf("quality %d: quantizer %d", quality, x)
+				}
+			}
+		}
+	}
+
+	m := textImage(131, 75)
+	var errs [2]float64
+	for i, q := range []*QuantTables{PhotoQuant, ScreenQuant} {
+		e, _ := New("jpeg", Options{Quality: 50, Subsampling: Subsample444, Quant: q})
+		var buf Buffer
+		if err := e.Encode(&buf, m); err != nil {
+			t.Fatal(err)
+		}
+		// DQT holds the tables in zigzag order
+		want := q.scaled(50)
+		if !bytes.Contains(buf.Bytes(), append([]byte{0}, want[0][:]...)) || !bytes.Contains(buf.Bytes(), append([]byte{1}, want[1][:]...)) {
+			t.Errorf("table %d: the quantization tables are not in the image", i)
+		}
+		img, err := jpeg.Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		errs[i] = meanError(img, m)
+	}
+	if errs[1] >= errs[0]*0.8 {
+		t.Errorf("mean error of ScreenQuant %.2f, PhotoQuant %.2f", errs[1], errs[0])
+	}
+}
+
+func TestJPEGParallelImageTypes(t *testing.T) {
+	// all image types are encoded like the BGRA image
+	images := testImages()
+	e := newJPEGParallelPool(Options{}, parallel.NewPool(1))
+	var want Buffer
+	e.Encode(&want, images[1])
+	for _, m := range images {
+		var got Buffer
+		if err := e.Encode(&got, m); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := m.(*image.YCbCr); ok {
+			// converted to RGB and back, which is not exact
+			var std Buffer
+			jpeg.Encode(&std, m, nil)
+			img, _ := jpeg.Decode(bytes.NewReader(got.Bytes()))
+			stdImg, _ := jpeg.Decode(bytes.NewReader(std.Bytes()))
+			if d, stdD := meanError(img, m), meanError(stdImg, m); d > stdD*1.1+0.5 {
+				t.Errorf("%T: mean error %.2f, image/jpeg %.2f", m, d, stdD)
+			}
+			continue
+		}
+		if !bytes.Equal(got.Bytes(), want.Bytes()) {
+			t.Errorf("%T is encoded differently", m)
+		}
+	}
+	if err := e.Encode(&want, image.NewRGBA(image.Rect(0, 0, 1<<16, 1))); err == nil {
+		t.Error("encoding an image wider than 65535 pixels succeeded")
+	}
+}
+
+// meanError returns the mean absolute difference of the color components of the images.
+func meanError(got, want image.Image) float64 {
+	var sum, n int
+	gb, wb := got.Bounds(), want.Bounds()
+	for y := 0; y < wb.Dy(); y++ {
+		for x := 0; x < wb.Dx(); x++ {
+			r0, g0, b0, _ := got.At(gb.Min.X+x, gb.Min.Y+y).RGBA()
+			r1, g1, b1, _ := want.At(wb.Min.X+x, wb.Min.Y+y).RGBA()
+			for _, d := range []int{int(r0>>8) - int(r1>>8), int(g0>>8) - int(g1>>8), int(b0>>8) - int(b1>>8)} {
+				if d < 0 {
+					d = -d
+				}
+				sum += d
+				n++
+			}
+		}
+	}
+	return float64(sum) / float64(n)
+}
+
+// restartMarkers counts the RSTn markers of the entropy coded data of a JPEG image.
+func restartMarkers(data []byte) int {
+	n := 0
+	for i := 0; i+1 < len(data); i++ {
+		if data[i] == 0xff && data[i+1] >= 0xd0 && data[i+1] <= 0xd7 {
+			if want := byte(0xd0 + n%8); data[i+1] != want {
+				return -1
+			}
+			n++
+		}
+	}
+	return n
+}
+
+func BenchmarkJPEGParallel4K(b *testing.B) {
+	m := screenImage(3840, 2160)
+	for _, workers := range []int{1, 0} {
+		pool := parallel.NewPool(workers)
+		b.Run(fmt.Sprintf("workers %d", pool.Workers()), func(b *testing.B) {
+			e := newJPEGParallelPool(Options{Quality: 50}, pool)
+			var buf Buffer
+			b.SetBytes(int64(len(m.Pix)))
+			for i := 0; i < b.N; i++ {
+				buf.Reset()
+				e.Encode(&buf, m)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package encode
+
+import (
+	"image"
+	"image/color"
+
+	"github.com/kirides/screencapture/bgra"
+	"github.com/kirides/screencapture/swizzle"
+)
+
+// nrgbaRow writes row y of m into dst as non-premultiplied pixels in RGBA order, or BGRA order if bgr is set.
+// len(dst) must be 4 times the width of m.
+func nrgbaRow(dst []byte, m image.Image, y int, bgr bool) {
+	b := m.Bounds()
+	switch m := m.(type) {
+	case *image.RGBA:
+		copy(dst, m.Pix[m.PixOffset(b.Min.X, y):])
+		unpremultiply(dst)
+		if bgr {
+			swizzle.BGRA(dst)
+		}
+	case *bgra.Image:
+		copy(dst, m.Pix[m.PixOffset(b.Min.X, y):])
+		unpremultiply(dst)
+		if !bgr {
+			swizzle.BGRA(dst)
+		}
+	case *image.NRGBA:
+		copy(dst, m.Pix[m.PixOffset(b.Min.X, y):])
+		if bgr {
+			swizzle.BGRA(dst)
+		}
+	default:
+		for x := 0; x < len(dst)/4; x++ {
+			c := color.NRGBAModel.Convert(m.At(b.Min.X+x, y)).(color.NRGBA)
+			if bgr {
+				c.R, c.B = c.B, c.R
+			}
+			dst[x*4], dst[x*4+1], dst[x*4+2], dst[x*4+3] = c.R, c.G, c.B, c.A
+		}
+	}
+}
+
+// unpremultiply converts alpha-premultiplied pixels into non-premultiplied ones, like color.NRGBAModel.
+// Opaque pixels, the usual case for captured frames, are left as they are.
+func unpremultiply(pix []byte) {
+	for i := 0; i+3 < len(pix); i += 4 {
+		a := uint32(pix[i+3])
+		if a == 0xff {
+			continue
+		}
+		if a == 0 {
+			pix[i], pix[i+1], pix[i+2] = 0, 0, 0
+			continue
+		}
+		for c := i; c < i+3; c++ {
+			pix[c] = uint8(uint32(pix[c]) * 0xffff / a >> 8)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package encode
+
+import (
+	"image"
+	"image/png"
+	"io"
+
+	"github.com/kirides/screencapture/bgra"
+)
+
+// pngEncoder encodes PNG images with the fastest compression, which suits screen contents well.
+type pngEncoder struct {
+	enc  png.Encoder
+	rgba *image.RGBA
+	// buf keeps the compression buffers of image/png between calls
+	buf *png.EncoderBuffer
+}
+
+func newPNG(Options) Encoder {
+	e := &pngEncoder{enc: png.Encoder{CompressionLevel: png.BestSpeed}}
+	e.enc.BufferPool = e
+	return e
+}
+
+func (e *pngEncoder) MIMEType() string { return "image/png" }
+
+func (e *pngEncoder) Encode(w io.Writer, m image.Image) error {
+	if p, ok := m.(*bgra.Image); ok {
+		// image/png encodes an *image.RGBA without calling At for every pixel
+		e.rgba = p.ToRGBA(e.rgba)
+		m = e.rgba
+	}
+	return e.enc.Encode(w, m)
+}
+
+// Get implements png.EncoderBufferPool.
+func (e *pngEncoder) Get() *png.EncoderBuffer { return e.buf }
+
+// Put implements png.EncoderBufferPool.
+func (e *pngEncoder) Put(b *png.EncoderBuffer) { e.buf = b }
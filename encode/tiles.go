@@ -0,0 +1,151 @@
+package encode
+
+import (
+	"fmt"
+	"image"
+
+	"github.com/kirides/screencapture/bgra"
+)
+
+// DefaultTileSize is the size of the tiles of a TileEncoder if none is set.
+const DefaultTileSize = 64
+
+// TileOptions configures a TileEncoder. The zero value encodes tiles of DefaultTileSize as PNG and JPEG.
+type TileOptions struct {
+	// Size is the width and height of the tiles, DefaultTileSize if zero.
+	// It should be a multiple of 16, so the blocks of JPEG tiles do not need padding.
+	Size int
+	// Lossless is the format of flat and text tiles, "png" if empty. "webp" usually makes smaller tiles.
+	// PNG tiles of up to MaxTileColors colors are palettized.
+	Lossless string
+	// Lossy is the format of photo tiles, "jpeg" if empty.
+	Lossy string
+	// Options configures both formats.
+	Options Options
+}
+
+// Tile is an encoded part of a frame.
+type Tile struct {
+	// Rect is the part of the frame, in its coordinates.
+	Rect  image.Rectangle
+	Class TileClass
+	// MIMEType is the media type of Data.
+	MIMEType string
+	Data     []byte
+}
+
+// TileEncoder encodes the changed parts of frames as tiles of a fixed grid,
+// choosing a lossless or a lossy format for every tile by its content.
+// It keeps buffers between calls, so it must not be used concurrently.
+type TileEncoder struct {
+	size            int
+	lossless, lossy Encoder
+	palettize       bool
+
+	classifier Classifier
+	checked    []bool
+	tiles      []Tile
+	ends       []int
+	buf        Buffer
+
+	rgba     image.RGBA
+	bgra     bgra.Image
+	paletted image.Paletted
+}
+
+// NewTileEncoder returns a TileEncoder of the formats selected by o.
+func NewTileEncoder(o TileOptions) (*TileEncoder, error) {
+	e := &TileEncoder{size: o.Size}
+	if e.size <= 0 {
+		e.size = DefaultTileSize
+	}
+	lossless, lossy := o.Lossless, o.Lossy
+	if lossless == "" {
+		lossless = "png"
+	}
+	if lossy == "" {
+		lossy = "jpeg"
+	}
+	var err error
+	if e.lossless, err = New(lossless, o.Options); err != nil {
+		return nil, err
+	}
+	if e.lossy, err = New(lossy, o.Options); err != nil {
+		return nil, err
+	}
+	e.palettize = e.lossless.MIMEType() == "image/png"
+	return e, nil
+}
+
+// Size returns the width and height of the tiles.
+func (e *TileEncoder) Size() int { return e.size }
+
+// Encode encodes every tile of m intersecting rects, the whole image if rects is nil.
+// The tiles are aligned to the top-left corner of m and clipped to its bounds.
+// The returned tiles are only valid until the next call to Encode.
+func (e *TileEncoder) Encode(m image.Image, rects []image.Rectangle) ([]Tile, error) {
+	b := m.Bounds()
+	if rects == nil {
+		rects = []image.Rectangle{b}
+	}
+	ts := e.size
+	cols, rows := (b.Dx()+ts-1)/ts, (b.Dy()+ts-1)/ts
+	if len(e.checked) < cols*rows {
+		e.checked = make([]bool, cols*rows)
+	}
+	checked := e.checked[:cols*rows]
+	for i := range checked {
+		checked[i] = false
+	}
+
+	e.tiles, e.ends = e.tiles[:0], e.ends[:0]
+	e.buf.Reset()
+	for _, r := range rects {
+		r = r.Intersect(b).Sub(b.Min)
+		if r.Empty() {
+			continue
+		}
+		for ty := r.Min.Y / ts; ty <= (r.Max.Y-1)/ts; ty++ {
+			for tx := r.Min.X / ts; tx <= (r.Max.X-1)/ts; tx++ {
+				if checked[ty*cols+tx] {
+					continue
+				}
+				checked[ty*cols+tx] = true
+				tile := image.Rect(tx*ts, ty*ts, tx*ts+ts, ty*ts+ts).Add(b.Min).Intersect(b)
+				if err := e.encodeTile(m, tile); err != nil {
+					return nil, fmt.Errorf("failed to encode tile %v. %w", tile, err)
+				}
+			}
+		}
+	}
+	// the buffer may have grown while encoding, so the data is sliced at the end
+	data, start := e.buf.Bytes(), 0
+	for i, end := range e.ends {
+		e.tiles[i].Data = data[start:end:end]
+		start = end
+	}
+	return e.tiles, nil
+}
+
+func (e *TileEncoder) encodeTile(m image.Image, r image.Rectangle) error {
+	stats := e.classifier.Stats(m, r)
+	t := Tile{Rect: r, Class: stats.Class()}
+	var err error
+	switch {
+	case t.Class == TilePhoto:
+		t.MIMEType = e.lossy.MIMEType()
+		err = e.lossy.Encode(&e.buf, e.classifier.tile(&e.rgba, &e.bgra))
+	case e.palettize && stats.Colors <= MaxTileColors:
+		t.MIMEType = e.lossless.MIMEType()
+		err = e.lossless.Encode(&e.buf, e.classifier.paletted(&e.paletted))
+	default:
+		t.MIMEType = e.lossless.MIMEType()
+		err = e.lossless.Encode(&e.buf, e.classifier.tile(&e.rgba, &e.bgra))
+	}
+	if err != nil {
+		return err
+	}
+	e.tiles = append(e.tiles, t)
+	e.ends = append(e.ends, e.buf.Len())
+	return nil
+}
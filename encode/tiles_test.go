@@ -0,0 +1,179 @@
+package encode
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math/rand"
+	"testing"
+
+	"github.com/kirides/screencapture/bgra"
+)
+
+// photoImage returns an image of smooth gradients with mild noise, like a photo.
+func photoImage(w, h int) *image.RGBA {
+	r := rand.New(rand.NewSource(5))
+	m := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			n := uint8(r.Intn(6))
+			m.SetRGBA(x, y, color.RGBA{uint8(x*2) + n, uint8(y) + 40 + n, uint8((x+y)/2) + n, 255})
+		}
+	}
+	return m
+}
+
+// antialiasedText returns text-like strokes blended into a background at many levels, like anti-aliased text.
+func antialiasedText(w, h int) *image.RGBA {
+	m := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(m, m.Rect, image.NewUniform(color.RGBA{30, 30, 30, 255}), image.Point{}, draw.Src)
+	for y := 2; y < h; y += 12 {
+		for x := 0; x < w; x++ {
+			// the edges of a stroke have intermediate colors, which differ with every position
+			a, b := uint8((x*37+y)%251), uint8((x*13+y*7)%199)
+			m.SetRGBA(x, y, color.RGBA{220, 220, 220, 255})
+			m.SetRGBA(x, y+1, color.RGBA{30 + a*3/4, 30 + b/2, 30 + a/4, 255})
+			if x%7 == 0 {
+				for dy := 2; dy < 8 && y+dy < h; dy++ {
+					m.SetRGBA(x, y+dy, color.RGBA{250, 120, 40, 255})
+				}
+			}
+		}
+	}
+	return m
+}
+
+func TestClassifier(t *testing.T) {
+	flat := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	generic := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	draw.Draw(generic, generic.Rect, photoImage(64, 64), image.Point{}, draw.Src)
+	var c Classifier
+	for _, tc := range []struct {
+		name string
+		m    image.Image
+		want TileClass
+	}{
+		{"flat", flat, TileFlat},
+		{"text", textImage(64, 64), TileText},
+		{"anti-aliased text", antialiasedText(64, 64), TileText},
+		{"photo", photoImage(64, 64), TilePhoto},
+		{"noise", screenImage(64, 64).SubImage(image.Rect(41, 0, 64, 64)), TilePhoto},
+		{"generic photo", generic, TilePhoto},
+		{"single pixel", image.NewNRGBA(image.Rect(0, 0, 1, 1)), TileFlat},
+	} {
+		s := c.Stats(tc.m, tc.m.Bounds())
+		if got := s.Class(); got != tc.want {
+			t.Errorf("%s: class %v, want %v, %+v", tc.name, got, tc.want, s)
+		}
+	}
+	if s := c.Stats(antialiasedText(64, 64), image.Rect(0, 0, 64, 64)); s.Colors != MaxTileColors+1 {
+		t.Errorf("anti-aliased text has %d colors, want %d", s.Colors, MaxTileColors+1)
+	}
+}
+
+// mixedScreen returns an editor next to a photo, as BGRA.
+func mixedScreen() *bgra.Image {
+	m := bgra.New(image.Rect(0, 0, 200, 150))
+	draw.Draw(m, image.Rect(0, 0, 100, 150), textImage(100, 150), image.Point{}, draw.Src)
+	draw.Draw(m, image.Rect(100, 0, 200, 150), photoImage(100, 150), image.Point{}, draw.Src)
+	return m
+}
+
+func TestTileEncoder(t *testing.T) {
+	m := mixedScreen()
+	for _, o := range []TileOptions{
+		{Size: 32, Options: Options{Quality: 90}},
+		{Size: 48, Lossless: "qoi", Lossy: "jpegparallel", Options: Options{Quality: 90}},
+		{Size: 32, Lossless: "webp", Options: Options{Quality: 90}},
+	} {
+		e, err := NewTileEncoder(o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// twice, to reuse the buffers of the encoder
+		for i := 0; i < 2; i++ {
+			tiles, err := e.Encode(m, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ts := e.Size()
+			if n := ((200 + ts - 1) / ts) * ((150 + ts - 1) / ts); len(tiles) != n {
+				t.Fatalf("%+v: %d tiles, want %d", o, len(tiles), n)
+			}
+			for _, tile := range tiles {
+				name := fmt.Sprintf("%+v: tile %v", o, tile.Rect)
+				if tile.Rect.Min.X%ts != 0 || tile.Rect.Min.Y%ts != 0 || !tile.Rect.In(m.Rect) {
+					t.Fatalf("%s is not on the grid", name)
+				}
+				format := "jpeg"
+				switch {
+				case tile.Rect.Max.X <= 100 && tile.Class == TilePhoto:
+					t.Errorf("%s of the editor is a photo", name)
+				case tile.Rect.Min.X >= 100 && tile.Class != TilePhoto:
+					t.Errorf("%s of the photo is %v", name, tile.Class)
+				case tile.Class != TilePhoto:
+					format = o.Lossless
+					if format == "" {
+						format = "png"
+					}
+				}
+				if tile.MIMEType != "image/"+format {
+					t.Errorf("%s: MIME type %s, want image/%s", name, tile.MIMEType, format)
+				}
+				got, err := decode(format, tile.Data)
+				if err != nil {
+					t.Fatalf("%s: %v", name, err)
+				}
+				want := m.SubImage(tile.Rect)
+				if format == "jpeg" {
+					compare(t, name, got, want, 4, true)
+				} else {
+					compare(t, name, got, want, 0, false)
+				}
+				if _, ok := got.(*image.Paletted); format == "png" && !ok {
+					t.Errorf("%s: PNG of %v is not palettized", name, tile.Class)
+				}
+			}
+		}
+	}
+}
+
+func TestTileEncoderRects(t *testing.T) {
+	m := mixedScreen()
+	e, _ := NewTileEncoder(TileOptions{})
+	tiles, err := e.Encode(m, []image.Rectangle{image.Rect(10, 10, 20, 20), image.Rect(60, 70, 70, 130), image.Rect(300, 0, 400, 10)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []image.Rectangle{
+		image.Rect(0, 0, 64, 64),
+		image.Rect(0, 64, 64, 128), image.Rect(64, 64, 128, 128),
+		image.Rect(0, 128, 64, 150), image.Rect(64, 128, 128, 150),
+	}
+	var got []image.Rectangle
+	for _, tile := range tiles {
+		got = append(got, tile.Rect)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("tiles %v, want %v", got, want)
+	}
+
+	// the tiles are aligned to the bounds of the image
+	sub := m.SubImage(image.Rect(5, 7, 200, 150))
+	tiles, _ = e.Encode(sub, nil)
+	if r := tiles[0].Rect; r != image.Rect(5, 7, 69, 71) {
+		t.Errorf("first tile of a sub-image is %v", r)
+	}
+	img, err := png.Decode(bytes.NewReader(tiles[0].Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	compare(t, "sub-image", img, sub.(*bgra.Image).SubImage(tiles[0].Rect), 0, false)
+
+	if _, err := NewTileEncoder(TileOptions{Lossy: "gif"}); err == nil {
+		t.Error("NewTileEncoder of an unregistered format succeeded")
+	}
+}
@@ -0,0 +1,72 @@
+//go:build jpegturbo
+// +build jpegturbo
+
+package encode
+
+import (
+	"image"
+	"image/draw"
+	"io"
+
+	"github.com/kirides/screencapture/bgra"
+	"github.com/kirides/screencapture/swizzle"
+	jpegturbo "github.com/pixiv/go-libjpeg/jpeg"
+)
+
+// turboEncoder encodes JPEG images using libjpeg-turbo.
+type turboEncoder struct {
+	opts  jpegturbo.EncoderOptions
+	ratio image.YCbCrSubsampleRatio
+	ycbcr *image.YCbCr
+	rgba  *image.RGBA
+}
+
+func newTurbo(o Options) Encoder {
+	if o.Quant != nil {
+		// go-libjpeg does not set quantization tables
+		return newJPEGParallel(o)
+	}
+	return &turboEncoder{opts: jpegturbo.EncoderOptions{Quality: o.quality()}, ratio: o.Subsampling.ratio()}
+}
+
+func (e *turboEncoder) MIMEType() string { return "image/jpeg" }
+
+func (e *turboEncoder) Encode(w io.Writer, m image.Image) error {
+	// libjpeg-turbo encodes *image.YCbCr with its subsample ratio and without converting it,
+	// other images are converted into one with the configured ratio
+	switch p := m.(type) {
+	case *image.Gray:
+	case *image.RGBA:
+		m = e.toYCbCr(p.Pix[p.PixOffset(p.Rect.Min.X, p.Rect.Min.Y):], p.Stride, p.Rect.Size(), false)
+	case *bgra.Image:
+		m = e.toYCbCr(p.Pix[p.PixOffset(p.Rect.Min.X, p.Rect.Min.Y):], p.Stride, p.Rect.Size(), true)
+	default:
+		if q, ok := p.(*image.YCbCr); ok && q.SubsampleRatio == e.ratio {
+			break
+		}
+		b := p.Bounds()
+		if e.rgba == nil || e.rgba.Rect != b {
+			e.rgba = image.NewRGBA(b)
+		}
+		draw.Draw(e.rgba, b, p, b.Min, draw.Src)
+		m = e.toYCbCr(e.rgba.Pix, e.rgba.Stride, b.Size(), false)
+	}
+	return jpegturbo.Encode(w, m, &e.opts)
+}
+
+// toYCbCr converts RGBA or BGRA pixels into e.ycbcr.
+func (e *turboEncoder) toYCbCr(pix []byte, stride int, size image.Point, bgr bool) *image.YCbCr {
+	if e.ycbcr == nil || e.ycbcr.Rect.Size() != size {
+		e.ycbcr = image.NewYCbCr(image.Rectangle{Max: size}, e.ratio)
+	}
+	if bgr {
+		swizzle.BGRAToYCbCr(e.ycbcr, pix, stride, jpegYUV)
+	} else {
+		swizzle.RGBAToYCbCr(e.ycbcr, pix, stride, jpegYUV)
+	}
+	return e.ycbcr
+}
+
+func init() {
+	Register("jpegturbo", newTurbo)
+}
@@ -0,0 +1,811 @@
+package encode
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"io"
+	"math/bits"
+	"sort"
+)
+
+// The lossless WebP format is specified at https://developers.google.com/speed/webp/docs/webp_lossless_bitstream_specification.
+
+const (
+	// webpMaxSize is the largest width and height of a WebP image.
+	webpMaxSize = 1 << 14
+	// webpPredictorBits is the log-2 size of the blocks that share a predictor.
+	webpPredictorBits = 4
+	// webpCacheBits is the log-2 size of the color cache.
+	webpCacheBits = 10
+	// webpMinMatch and webpMaxMatch are the shortest and longest backward references in pixels.
+	webpMinMatch = 3
+	webpMaxMatch = 4096
+	// webpMaxDistance is the farthest a backward reference reaches, limited by the 40 distance codes.
+	webpMaxDistance = 1<<20 - 120
+	// webpHashBits is the log-2 size of the hash table of pixel pairs, webpChainDepth the number
+	// of earlier positions of a pair that are tried as backward references.
+	webpHashBits   = 16
+	webpChainDepth = 16
+	// webpMaxCodeLength is the longest code of the prefix codes, webpMaxLengthCodeLength
+	// the longest code of the code that encodes their code lengths.
+	webpMaxCodeLength       = 15
+	webpMaxLengthCodeLength = 7
+)
+
+const (
+	webpLiterals     = 256
+	webpLengthCodes  = 24
+	webpDistCodes    = 40
+	webpCacheMult    = 0x1e35a7bd
+	webpTransformPre = 0
+	webpTransformSub = 2
+)
+
+// webpToken is a literal pixel, an index of the color cache or a backward reference.
+type webpToken struct {
+	kind uint8
+	// a is the pixel, the cache index or the length, b is the distance code
+	a, b uint32
+}
+
+const (
+	webpLiteral = iota
+	webpCached
+	webpCopy
+)
+
+// webpEncoder encodes lossless WebP (VP8L) images. It removes the green from red and blue,
+// predicts every pixel from its neighbors with the predictor that suits each block best
+// and codes the residuals with backward references and a color cache,
+// which make the repeated and flat areas of screen contents cheap.
+type webpEncoder struct {
+	argb     []uint32
+	residual []uint32
+	modes    []uint32
+	row      []byte
+
+	tokens []webpToken
+	head   [1 << webpHashBits]int32
+	chain  []int32
+	cache  [1 << webpCacheBits]uint32
+
+	codes   [5]webpCode
+	lengths webpCode
+	huffman huffmanBuilder
+	bw      bitWriter
+	out     []byte
+}
+
+// webpCode is a prefix code with the histogram it is built from.
+type webpCode struct {
+	freq    []uint32
+	lengths []uint8
+	// bits and codes are the length and the bit-reversed code written for every symbol,
+	// bits is zero for a code of a single symbol
+	bits  []uint8
+	codes []uint16
+}
+
+func newWebP(Options) Encoder {
+	return &webpEncoder{}
+}
+
+func (e *webpEncoder) MIMEType() string { return "image/webp" }
+
+func (e *webpEncoder) Encode(w io.Writer, m image.Image) error {
+	b := m.Bounds()
+	width, height := b.Dx(), b.Dy()
+	if width > webpMaxSize || height > webpMaxSize {
+		return errors.New("encode: image is too large for WebP")
+	}
+	if b.Empty() {
+		return errors.New("encode: image is empty")
+	}
+	n := width * height
+	if cap(e.argb) < n {
+		e.argb = make([]uint32, n)
+		e.residual = make([]uint32, n)
+		e.chain = make([]int32, n)
+	}
+	argb, residual := e.argb[:n], e.residual[:n]
+	if cap(e.row) < width*4 {
+		e.row = make([]byte, width*4)
+	}
+	row := e.row[:width*4]
+	alpha := false
+	for y := 0; y < height; y++ {
+		// BGRA bytes are little endian ARGB
+		nrgbaRow(row, m, b.Min.Y+y, true)
+		dst := argb[y*width:][:width]
+		for x := range dst {
+			p := binary.LittleEndian.Uint32(row[x*4:])
+			alpha = alpha || p < 0xff000000
+			dst[x] = subtractGreen(p)
+		}
+	}
+
+	tw, th := (width+1<<webpPredictorBits-1)>>webpPredictorBits, (height+1<<webpPredictorBits-1)>>webpPredictorBits
+	if cap(e.modes) < tw*th {
+		e.modes = make([]uint32, tw*th)
+	}
+	modes := e.modes[:tw*th]
+	for ty := 0; ty < th; ty++ {
+		for tx := 0; tx < tw; tx++ {
+			mode := bestPredictor(argb, width, height, tx, ty)
+			modes[ty*tw+tx] = uint32(mode) << 8
+		}
+	}
+	predict(residual, argb, width, height, modes, tw)
+
+	bw := &e.bw
+	bw.reset(e.out[:0])
+	// RIFF header, with the sizes filled in at the end
+	bw.buf = append(bw.buf, "RIFF\x00\x00\x00\x00WEBPVP8L\x00\x00\x00\x00"...)
+	bw.write(0x2f, 8)
+	bw.write(uint32(width-1), 14)
+	bw.write(uint32(height-1), 14)
+	if alpha {
+		bw.write(1, 1)
+	} else {
+		bw.write(0, 1)
+	}
+	bw.write(0, 3)
+
+	// the transforms in the order they were applied
+	bw.write(1, 1)
+	bw.write(webpTransformSub, 2)
+	bw.write(1, 1)
+	bw.write(webpTransformPre, 2)
+	bw.write(webpPredictorBits-2, 3)
+	e.writeImage(modes, tw, 0, false)
+	bw.write(0, 1)
+
+	e.writeImage(residual, width, webpCacheBits, true)
+
+	e.out = bw.flush()
+	size := len(e.out) - 20
+	if size%2 == 1 {
+		e.out = append(e.out, 0)
+	}
+	binary.LittleEndian.PutUint32(e.out[4:], uint32(len(e.out)-8))
+	binary.LittleEndian.PutUint32(e.out[16:], uint32(size))
+	_, err := w.Write(e.out)
+	return err
+}
+
+// subtractGreen subtracts the green of p from its red and blue.
+func subtractGreen(p uint32) uint32 {
+	g := p >> 8 & 0xff
+	return p&0xff00ff00 | (p>>16-g)&0xff<<16 | (p-g)&0xff
+}
+
+// subPixels subtracts the components of b from a.
+func subPixels(a, b uint32) uint32 {
+	ag := 0x00ff00ff + a&0xff00ff00 - b&0xff00ff00
+	rb := 0xff00ff00 + a&0x00ff00ff - b&0x00ff00ff
+	return ag&0xff00ff00 | rb&0x00ff00ff
+}
+
+// average2 returns the rounded down averages of the components of a and b.
+func average2(a, b uint32) uint32 {
+	return (a^b)&0xfefefefe>>1 + a&b
+}
+
+// predictor returns the prediction of mode for the pixel i of a row of width w, which is neither on the first row
+// nor in the first column. For the last column, the top-right pixel is the first one of the row.
+func predictor(mode int, argb []uint32, i, w int) uint32 {
+	switch mode {
+	case 0:
+		return 0xff000000
+	case 1:
+		return argb[i-1]
+	case 2:
+		return argb[i-w]
+	case 3:
+		return argb[i-w+1]
+	case 4:
+		return argb[i-w-1]
+	case 5:
+		return average2(average2(argb[i-1], argb[i-w+1]), argb[i-w])
+	case 6:
+		return average2(argb[i-1], argb[i-w-1])
+	case 7:
+		return average2(argb[i-1], argb[i-w])
+	case 8:
+		return average2(argb[i-w-1], argb[i-w])
+	case 9:
+		return average2(argb[i-w], argb[i-w+1])
+	case 10:
+		return average2(average2(argb[i-1], argb[i-w-1]), average2(argb[i-w], argb[i-w+1]))
+	case 11:
+		return selectPredictor(argb[i-1], argb[i-w], argb[i-w-1])
+	case 12:
+		return clampAddSubtractFull(argb[i-1], argb[i-w], argb[i-w-1])
+	}
+	return clampAddSubtractHalf(average2(argb[i-1], argb[i-w]), argb[i-w-1])
+}
+
+// predictorOrder lists the predictors by how often they suit screen contents, so the search for the best one
+// stops early for blocks that are flat or repeat the previous rows.
+var predictorOrder = [14]int{1, 2, 11, 12, 13, 7, 5, 10, 3, 4, 6, 8, 9, 0}
+
+// selectPredictor returns the one of l and t that is closer to the gradient estimate l + t - tl.
+func selectPredictor(l, t, tl uint32) uint32 {
+	var pl, pt int
+	for s := 0; s < 32; s += 8 {
+		c := int(tl >> s & 0xff)
+		pl += absInt(c - int(t>>s&0xff))
+		pt += absInt(c - int(l>>s&0xff))
+	}
+	if pl < pt {
+		return l
+	}
+	return t
+}
+
+func clampAddSubtractFull(a, b, c uint32) uint32 {
+	var p uint32
+	for s := 0; s < 32; s += 8 {
+		p |= clampByte(int(a>>s&0xff)+int(b>>s&0xff)-int(c>>s&0xff)) << s
+	}
+	return p
+}
+
+func clampAddSubtractHalf(a, b uint32) uint32 {
+	var p uint32
+	for s := 0; s < 32; s += 8 {
+		x := int(a >> s & 0xff)
+		p |= clampByte(x+(x-int(b>>s&0xff))/2) << s
+	}
+	return p
+}
+
+func clampByte(x int) uint32 {
+	if x < 0 {
+		return 0
+	}
+	if x > 255 {
+		return 255
+	}
+	return uint32(x)
+}
+
+func absInt(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// residualCost estimates the bits of a residual component, small values in both directions being cheap.
+var residualCost = func() (c [256]uint8) {
+	for i := range c {
+		if d := int(int8(i)); d != 0 {
+			c[i] = uint8(2 + bits.Len(uint(absInt(d))))
+		}
+	}
+	return c
+}()
+
+// bestPredictor returns the predictor with the cheapest residuals for the block tx, ty.
+func bestPredictor(argb []uint32, w, h, tx, ty int) int {
+	x0, y0 := tx<<webpPredictorBits, ty<<webpPredictorBits
+	x1, y1 := x0+1<<webpPredictorBits, y0+1<<webpPredictorBits
+	if x0 == 0 {
+		x0 = 1
+	}
+	if y0 == 0 {
+		y0 = 1
+	}
+	if x1 > w {
+		x1 = w
+	}
+	if y1 > h {
+		y1 = h
+	}
+	best, bestCost := 1, -1
+	for _, mode := range predictorOrder {
+		if bestCost == 0 {
+			break
+		}
+		cost := 0
+		for y := y0; y < y1; y++ {
+			for x := x0; x < x1; x++ {
+				i := y*w + x
+				r := subPixels(argb[i], predictor(mode, argb, i, w))
+				cost += int(residualCost[r&0xff]) + int(residualCost[r>>8&0xff]) + int(residualCost[r>>16&0xff]) + int(residualCost[r>>24])
+			}
+			if bestCost >= 0 && cost >= bestCost {
+				break
+			}
+		}
+		if bestCost < 0 || cost < bestCost {
+			best, bestCost = mode, cost
+		}
+	}
+	return best
+}
+
+// predict writes the residuals of argb into dst, using the predictors in the green of modes.
+func predict(dst, argb []uint32, w, h int, modes []uint32, tw int) {
+	dst[0] = subPixels(argb[0], 0xff000000)
+	for x := 1; x < w; x++ {
+		dst[x] = subPixels(argb[x], argb[x-1])
+	}
+	for y := 1; y < h; y++ {
+		i := y * w
+		dst[i] = subPixels(argb[i], argb[i-w])
+		for x := 1; x < w; x++ {
+			mode := int(modes[(y>>webpPredictorBits)*tw+x>>webpPredictorBits] >> 8 & 0xf)
+			dst[i+x] = subPixels(argb[i+x], predictor(mode, argb, i+x, w))
+		}
+	}
+}
+
+// writeImage writes the entropy coded pixels of an image of width w, with a color cache of cacheBits if not zero.
+// Only the main image can have meta prefix codes, which are not used.
+func (e *webpEncoder) writeImage(argb []uint32, w int, cacheBits uint, main bool) {
+	e.tokenize(argb, w, cacheBits)
+
+	bw := &e.bw
+	if cacheBits > 0 {
+		bw.write(1, 1)
+		bw.write(uint32(cacheBits), 4)
+	} else {
+		bw.write(0, 1)
+	}
+	if main {
+		bw.write(0, 1)
+	}
+
+	sizes := [5]int{webpLiterals + webpLengthCodes, webpLiterals, webpLiterals, webpLiterals, webpDistCodes}
+	if cacheBits > 0 {
+		sizes[0] += 1 << cacheBits
+	}
+	for i := range e.codes {
+		e.codes[i].reset(sizes[i])
+	}
+	green, red, blue, alphaCode, dist := &e.codes[0], &e.codes[1], &e.codes[2], &e.codes[3], &e.codes[4]
+	for _, t := range e.tokens {
+		switch t.kind {
+		case webpLiteral:
+			green.freq[t.a>>8&0xff]++
+			red.freq[t.a>>16&0xff]++
+			blue.freq[t.a&0xff]++
+			alphaCode.freq[t.a>>24]++
+		case webpCached:
+			green.freq[webpLiterals+webpLengthCodes+t.a]++
+		case webpCopy:
+			sym, _, _ := prefixEncode(t.a)
+			green.freq[webpLiterals+sym]++
+			sym, _, _ = prefixEncode(t.b)
+			dist.freq[sym]++
+		}
+	}
+	for i := range e.codes {
+		e.writeCode(&e.codes[i])
+	}
+
+	for _, t := range e.tokens {
+		switch t.kind {
+		case webpLiteral:
+			green.put(bw, int(t.a>>8&0xff))
+			red.put(bw, int(t.a>>16&0xff))
+			blue.put(bw, int(t.a&0xff))
+			alphaCode.put(bw, int(t.a>>24))
+		case webpCached:
+			green.put(bw, webpLiterals+webpLengthCodes+int(t.a))
+		case webpCopy:
+			sym, n, extra := prefixEncode(t.a)
+			green.put(bw, webpLiterals+sym)
+			bw.write(extra, n)
+			sym, n, extra = prefixEncode(t.b)
+			dist.put(bw, sym)
+			bw.write(extra, n)
+		}
+	}
+}
+
+// tokenize splits the pixels into literals, color cache indices and backward references.
+// Backward references are searched at the distances of the pixel to the left and above, which cover
+// flat areas and vertical repetitions, and at earlier positions of the same pair of pixels.
+func (e *webpEncoder) tokenize(argb []uint32, w int, cacheBits uint) {
+	n := len(argb)
+	e.tokens = e.tokens[:0]
+	for i := range e.head {
+		e.head[i] = -1
+	}
+	chain := e.chain[:n]
+	cache := e.cache[:1<<cacheBits]
+	for i := range cache {
+		cache[i] = 0
+	}
+	shift := 32 - cacheBits
+	insert := func(i int) {
+		if i+1 < n {
+			h := (argb[i]*0x9e3779b1 ^ argb[i+1]*0x85ebca6b) >> (32 - webpHashBits)
+			chain[i] = e.head[h]
+			e.head[h] = int32(i)
+		}
+		if cacheBits > 0 {
+			cache[argb[i]*webpCacheMult>>shift] = argb[i]
+		}
+	}
+
+	for i := 0; i < n; {
+		bestLen, bestDist := 0, 0
+		try := func(j int) {
+			if j < 0 || i-j > webpMaxDistance {
+				return
+			}
+			limit := n - i
+			if limit > webpMaxMatch {
+				limit = webpMaxMatch
+			}
+			l := 0
+			for l < limit && argb[j+l] == argb[i+l] {
+				l++
+			}
+			if l > bestLen {
+				bestLen, bestDist = l, i-j
+			}
+		}
+		try(i - 1)
+		try(i - w)
+		if i+1 < n {
+			h := (argb[i]*0x9e3779b1 ^ argb[i+1]*0x85ebca6b) >> (32 - webpHashBits)
+			for j, depth := int(e.head[h]), 0; j >= 0 && depth < webpChainDepth && bestLen < webpMaxMatch; j, depth = int(chain[j]), depth+1 {
+				try(j)
+			}
+		}
+
+		if bestLen >= webpMinMatch {
+			e.tokens = append(e.tokens, webpToken{kind: webpCopy, a: uint32(bestLen), b: distanceCode(bestDist, w)})
+			for end := i + bestLen; i < end; i++ {
+				insert(i)
+			}
+			continue
+		}
+		p := argb[i]
+		if k := p * webpCacheMult >> shift; cacheBits > 0 && cache[k] == p {
+			e.tokens = append(e.tokens, webpToken{kind: webpCached, a: k})
+		} else {
+			e.tokens = append(e.tokens, webpToken{kind: webpLiteral, a: p})
+		}
+		insert(i)
+		i++
+	}
+}
+
+// distanceCodes are the distance codes minus one of the nearby pixels of the previous rows,
+// as y<<4 | 8-x of their offset x, y.
+var distanceCodes = [120]uint8{
+	0x18, 0x07, 0x17, 0x19, 0x28, 0x06, 0x27, 0x29, 0x16, 0x1a,
+	0x26, 0x2a, 0x38, 0x05, 0x37, 0x39, 0x15, 0x1b, 0x36, 0x3a,
+	0x25, 0x2b, 0x48, 0x04, 0x47, 0x49, 0x14, 0x1c, 0x35, 0x3b,
+	0x46, 0x4a, 0x24, 0x2c, 0x58, 0x45, 0x4b, 0x34, 0x3c, 0x03,
+	0x57, 0x59, 0x13, 0x1d, 0x56, 0x5a, 0x23, 0x2d, 0x44, 0x4c,
+	0x55, 0x5b, 0x33, 0x3d, 0x68, 0x02, 0x67, 0x69, 0x12, 0x1e,
+	0x66, 0x6a, 0x22, 0x2e, 0x54, 0x5c, 0x43, 0x4d, 0x65, 0x6b,
+	0x32, 0x3e, 0x78, 0x01, 0x77, 0x79, 0x53, 0x5d, 0x11, 0x1f,
+	0x64, 0x6c, 0x42, 0x4e, 0x76, 0x7a, 0x21, 0x2f, 0x75, 0x7b,
+	0x31, 0x3f, 0x63, 0x6d, 0x52, 0x5e, 0x00, 0x74, 0x7c, 0x41,
+	0x4f, 0x10, 0x20, 0x62, 0x6e, 0x30, 0x73, 0x7d, 0x51, 0x5f,
+	0x40, 0x72, 0x7e, 0x61, 0x6f, 0x50, 0x71, 0x7f, 0x60, 0x70,
+}
+
+// distanceMap is the inverse of distanceCodes, 0xff for the offsets without a code.
+var distanceMap = func() (m [128]uint8) {
+	for i := range m {
+		m[i] = 0xff
+	}
+	for i, c := range distanceCodes {
+		m[c] = uint8(i)
+	}
+	return m
+}()
+
+// distanceCode returns the code of a backward reference to the pixel dist pixels before,
+// preferring the short codes of the nearby pixels of the previous rows.
+func distanceCode(dist, w int) uint32 {
+	y, x := dist/w, dist%w
+	// the offset is either to the left of the pixel y rows above or to the right of the pixel y+1 rows above
+	for _, o := range [2][2]int{{x, y}, {x - w, y + 1}} {
+		x, y := o[0], o[1]
+		if y < 8 && x <= 8 && x >= -7 {
+			if c := distanceMap[y<<4|(8-x)]; c != 0xff {
+				return uint32(c) + 1
+			}
+		}
+	}
+	return uint32(dist + 120)
+}
+
+// prefixEncode returns the prefix symbol, the number of extra bits and the extra bits of a length or distance v >= 1.
+func prefixEncode(v uint32) (sym int, n uint, extra uint32) {
+	d := v - 1
+	if d < 4 {
+		return int(d), 0, 0
+	}
+	h := uint(bits.Len32(d)) - 1
+	second := d >> (h - 1) & 1
+	n = h - 1
+	return int(2*h + uint(second)), n, d & (1<<n - 1)
+}
+
+func (c *webpCode) reset(size int) {
+	if cap(c.freq) < size {
+		c.freq = make([]uint32, size)
+		c.lengths = make([]uint8, size)
+		c.bits = make([]uint8, size)
+		c.codes = make([]uint16, size)
+	}
+	c.freq, c.lengths, c.bits, c.codes = c.freq[:size], c.lengths[:size], c.bits[:size], c.codes[:size]
+	for i := range c.freq {
+		c.freq[i] = 0
+	}
+}
+
+// put writes the code of sym.
+func (c *webpCode) put(bw *bitWriter, sym int) {
+	bw.write(uint32(c.codes[sym]), uint(c.bits[sym]))
+}
+
+// writeCode builds the prefix code of the histogram of c and writes it.
+func (e *webpEncoder) writeCode(c *webpCode) {
+	bw := &e.bw
+	var symbols [2]int
+	n := 0
+	for s, f := range c.freq {
+		if f > 0 {
+			if n < 2 {
+				symbols[n] = s
+			}
+			n++
+		}
+	}
+	for i := range c.bits {
+		c.bits[i], c.codes[i] = 0, 0
+	}
+	if n <= 2 && symbols[0] < 256 && symbols[1] < 256 {
+		// a simple code of one symbol without bits or two symbols of one bit
+		bw.write(1, 1)
+		if n == 0 {
+			n = 1
+		}
+		bw.write(uint32(n-1), 1)
+		if symbols[0] < 2 {
+			bw.write(0, 1)
+			bw.write(uint32(symbols[0]), 1)
+		} else {
+			bw.write(1, 1)
+			bw.write(uint32(symbols[0]), 8)
+		}
+		if n == 2 {
+			bw.write(uint32(symbols[1]), 8)
+			c.bits[symbols[0]], c.bits[symbols[1]] = 1, 1
+			c.codes[symbols[1]] = 1
+		}
+		return
+	}
+
+	e.huffman.lengths(c.freq, c.lengths, webpMaxCodeLength)
+	c.assign(n)
+	bw.write(0, 1)
+
+	// the code lengths are written with a code of their own, with 16 repeating the previous length
+	// and 17 and 18 repeating zeros
+	lc := &e.lengths
+	lc.reset(19)
+	tokens := e.huffman.rle[:0]
+	for i := 0; i < len(c.lengths); {
+		l := c.lengths[i]
+		run := 1
+		for i+run < len(c.lengths) && c.lengths[i+run] == l {
+			run++
+		}
+		i += run
+		if l == 0 {
+			for run >= 3 {
+				r := run
+				if r > 138 {
+					r = 138
+				}
+				if r >= 11 {
+					tokens = append(tokens, 18|uint16(r-11)<<5)
+				} else {
+					tokens = append(tokens, 17|uint16(r-3)<<5)
+				}
+				run -= r
+			}
+		} else {
+			tokens = append(tokens, uint16(l))
+			run--
+			for run >= 3 {
+				r := run
+				if r > 6 {
+					r = 6
+				}
+				tokens = append(tokens, 16|uint16(r-3)<<5)
+				run -= r
+			}
+		}
+		for ; run > 0; run-- {
+			tokens = append(tokens, uint16(l))
+		}
+	}
+	e.huffman.rle = tokens
+	for _, t := range tokens {
+		lc.freq[t&0x1f]++
+	}
+	e.huffman.lengths(lc.freq, lc.lengths, webpMaxLengthCodeLength)
+	nLengths := 0
+	for _, s := range lc.freq {
+		if s > 0 {
+			nLengths++
+		}
+	}
+	lc.assign(nLengths)
+
+	order := [19]uint8{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+	count := 4
+	for i, s := range order {
+		if lc.lengths[s] > 0 && i+1 > count {
+			count = i + 1
+		}
+	}
+	bw.write(uint32(count-4), 4)
+	for _, s := range order[:count] {
+		bw.write(uint32(lc.lengths[s]), 3)
+	}
+	// all code lengths are written
+	bw.write(0, 1)
+	extraBits := [3]uint{2, 3, 7}
+	for _, t := range tokens {
+		sym := int(t & 0x1f)
+		lc.put(bw, sym)
+		if sym >= 16 {
+			bw.write(uint32(t>>5), extraBits[sym-16])
+		}
+	}
+}
+
+// assign sets the canonical codes of the code lengths of c, which has n symbols.
+// A code of a single symbol is written without bits.
+func (c *webpCode) assign(n int) {
+	if n == 1 {
+		return
+	}
+	var count, next [16]uint16
+	for _, l := range c.lengths {
+		count[l]++
+	}
+	count[0] = 0
+	code := uint16(0)
+	for l := 1; l < len(next); l++ {
+		code = (code + count[l-1]) << 1
+		next[l] = code
+	}
+	for s, l := range c.lengths {
+		if l > 0 {
+			c.bits[s] = l
+			c.codes[s] = bits.Reverse16(next[l]) >> (16 - l)
+			next[l]++
+		}
+	}
+}
+
+// huffmanBuilder computes length-limited Huffman code lengths, keeping its buffers between calls.
+type huffmanBuilder struct {
+	leaves []huffmanNode
+	nodes  []huffmanNode
+	rle    []uint16
+}
+
+type huffmanNode struct {
+	freq uint32
+	// sym is the symbol of a leaf, or -1 for an internal node whose children are left and right
+	sym         int32
+	left, right int32
+}
+
+// Len, Less and Swap sort the leaves by frequency, as a pointer so sorting does not allocate.
+func (b *huffmanBuilder) Len() int { return len(b.leaves) }
+func (b *huffmanBuilder) Less(i, j int) bool {
+	l := b.leaves
+	return l[i].freq < l[j].freq || l[i].freq == l[j].freq && l[i].sym < l[j].sym
+}
+func (b *huffmanBuilder) Swap(i, j int) { b.leaves[i], b.leaves[j] = b.leaves[j], b.leaves[i] }
+
+// lengths sets the code lengths of the symbols of freq, at most maxLength.
+// If the Huffman code is longer, the frequencies are flattened until it fits.
+func (b *huffmanBuilder) lengths(freq []uint32, lengths []uint8, maxLength int) {
+	for i := range lengths {
+		lengths[i] = 0
+	}
+	for minFreq := uint32(0); ; minFreq = minFreq*2 + 1 {
+		b.leaves = b.leaves[:0]
+		for s, f := range freq {
+			if f > 0 {
+				if f < minFreq {
+					f = minFreq
+				}
+				b.leaves = append(b.leaves, huffmanNode{freq: f, sym: int32(s)})
+			}
+		}
+		if len(b.leaves) == 1 {
+			lengths[b.leaves[0].sym] = 1
+			return
+		}
+		sort.Sort(b)
+
+		// the two-queue construction: leaves and internal nodes are both created in increasing frequency
+		b.nodes = append(b.nodes[:0], b.leaves...)
+		leaf, node := 0, len(b.leaves)
+		pick := func() int32 {
+			if leaf < len(b.leaves) && (node >= len(b.nodes) || b.leaves[leaf].freq <= b.nodes[node].freq) {
+				leaf++
+				return int32(leaf - 1)
+			}
+			node++
+			return int32(node - 1)
+		}
+		for i := 1; i < len(b.leaves); i++ {
+			l, r := pick(), pick()
+			b.nodes = append(b.nodes, huffmanNode{freq: b.nodes[l].freq + b.nodes[r].freq, sym: -1, left: l, right: r})
+		}
+		if b.depths(int32(len(b.nodes)-1), 0, lengths, maxLength) {
+			return
+		}
+	}
+}
+
+// depths sets the lengths of the leaves below node at depth d, reporting false if one is longer than maxLength.
+func (b *huffmanBuilder) depths(node int32, d int, lengths []uint8, maxLength int) bool {
+	n := b.nodes[node]
+	if n.sym >= 0 {
+		if d > maxLength {
+			return false
+		}
+		lengths[n.sym] = uint8(d)
+		return true
+	}
+	return b.depths(n.left, d+1, lengths, maxLength) && b.depths(n.right, d+1, lengths, maxLength)
+}
+
+// bitWriter writes bits starting with the least significant bit of every byte.
+type bitWriter struct {
+	buf  []byte
+	bits uint64
+	n    uint
+}
+
+func (w *bitWriter) reset(buf []byte) {
+	w.buf, w.bits, w.n = buf, 0, 0
+}
+
+// write writes the n low bits of v, n is at most 32.
+func (w *bitWriter) write(v uint32, n uint) {
+	w.bits |= uint64(v) << w.n
+	w.n += n
+	if w.n >= 32 {
+		w.buf = append(w.buf, byte(w.bits), byte(w.bits>>8), byte(w.bits>>16), byte(w.bits>>24))
+		w.bits >>= 32
+		w.n -= 32
+	}
+}
+
+// flush writes the remaining bits, padded with zeros to a byte, and returns the buffer.
+func (w *bitWriter) flush() []byte {
+	for w.n > 0 {
+		w.buf = append(w.buf, byte(w.bits))
+		w.bits >>= 8
+		if w.n < 8 {
+			w.n = 0
+		} else {
+			w.n -= 8
+		}
+	}
+	return w.buf
+}
@@ -0,0 +1,107 @@
+package encode
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+func TestWebP(t *testing.T) {
+	wide := image.NewNRGBA(image.Rect(0, 0, 300, 3))
+	for x := 0; x < 300; x++ {
+		wide.SetNRGBA(x, x%3, color.NRGBA{uint8(x), 255 - uint8(x), uint8(x * 7), uint8(x * 3)})
+	}
+	e := newWebP(Options{})
+	var buf Buffer
+	for _, m := range []image.Image{
+		image.NewRGBA(image.Rect(0, 0, 1, 1)),
+		image.NewRGBA(image.Rect(0, 0, 7, 1)),
+		wide,
+		textImage(37, 91),
+		screenImage(203, 117),
+		photoImage(129, 65),
+		antialiasedText(64, 64),
+		mixedScreen().SubImage(image.Rect(3, 5, 171, 140)),
+		translucentImage(),
+	} {
+		name := fmt.Sprintf("%T %v", m, m.Bounds())
+		buf.Reset()
+		if err := e.Encode(&buf, m); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		got, err := webp.Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		compare(t, name, got, m, 0, false)
+	}
+
+	for _, r := range []image.Rectangle{{}, image.Rect(0, 0, webpMaxSize+1, 1)} {
+		if err := e.Encode(&buf, image.NewRGBA(r)); err == nil {
+			t.Errorf("encoding an image of %v succeeded", r)
+		}
+	}
+}
+
+func TestWebPSize(t *testing.T) {
+	for _, m := range []image.Image{textImage(256, 256), screenImage(256, 256), photoImage(256, 256)} {
+		var wp, pn bytes.Buffer
+		if err := newWebP(Options{}).Encode(&wp, m); err != nil {
+			t.Fatal(err)
+		}
+		(&png.Encoder{CompressionLevel: png.BestCompression}).Encode(&pn, m)
+		if wp.Len() >= pn.Len() {
+			t.Errorf("%T: WebP of %d bytes is not smaller than PNG of %d bytes", m, wp.Len(), pn.Len())
+		}
+	}
+}
+
+func TestDistanceCode(t *testing.T) {
+	for _, w := range []int{1, 3, 8, 9, 100} {
+		for dist := 1; dist < 10*w+20; dist++ {
+			code := distanceCode(dist, w)
+			got := int(code) - 120
+			if code <= 120 {
+				c := int(distanceCodes[code-1])
+				got = c>>4*w + 8 - c&0xf
+			}
+			if got != dist {
+				t.Fatalf("width %d: distance %d has code %d of distance %d", w, dist, code, got)
+			}
+			if w > 1 && (dist == 1 && code != 2 || dist == w && code != 1) {
+				t.Errorf("width %d: distance %d has code %d", w, dist, code)
+			}
+		}
+	}
+}
+
+func TestHuffmanLengths(t *testing.T) {
+	// Fibonacci frequencies make the deepest Huffman codes
+	freq := make([]uint32, 40)
+	a, b := uint32(1), uint32(1)
+	for i := range freq {
+		freq[i] = a
+		a, b = b, a+b
+	}
+	lengths := make([]uint8, len(freq))
+	var h huffmanBuilder
+	for _, max := range []int{7, 15} {
+		h.lengths(freq[:19], lengths[:19], max)
+		h.lengths(freq, lengths, max)
+		kraft := 0
+		for s, l := range lengths {
+			if l == 0 || int(l) > max {
+				t.Fatalf("max %d: symbol %d has length %d", max, s, l)
+			}
+			kraft += 1 << (15 - l)
+		}
+		if kraft != 1<<15 {
+			t.Errorf("max %d: code is not complete, %d", max, kraft)
+		}
+	}
+}
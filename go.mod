@@ -3,15 +3,11 @@ module github.com/kirides/screencapture
 go 1.17
 
 require (
+	github.com/gen2brain/shm v0.0.0-20210511105953-083dbc7d9d83
+	github.com/jezek/xgb v0.0.0-20210312150743-0e0f116e1240
 	github.com/kbinani/screenshot v0.0.0-20210720154843-7d3a670d8329
 	github.com/lxn/win v0.0.0-20210218163916-a377121e959e
-	github.com/mattn/go-mjpeg v0.0.3
-	github.com/nfnt/resize v0.0.0-20180221191011-83c6a9932646
 	github.com/pixiv/go-libjpeg v0.0.0-20190822045933-3da21a74767d
+	golang.org/x/image v0.0.0-20211028202545-6944b10bf410
 	golang.org/x/sys v0.0.0-20211031064116-611d5d643895
 )
-
-require (
-	github.com/gen2brain/shm v0.0.0-20210511105953-083dbc7d9d83 // indirect
-	github.com/jezek/xgb v0.0.0-20210312150743-0e0f116e1240 // indirect
-)
@@ -0,0 +1,299 @@
+// Package hdr converts HDR desktop surfaces to 8-bit sRGB.
+//
+// Desktops with HDR enabled are composed in linear scRGB with half float components,
+// fullscreen applications may present BT.2020 with the PQ curve in 10 bits per component.
+// Both are tone mapped so that highlights above the SDR white level are compressed instead of clipped.
+package hdr
+
+import (
+	"encoding/binary"
+	"image"
+	"math"
+	"sync"
+)
+
+// Format is the layout and encoding of HDR pixels.
+type Format int
+
+const (
+	// ScRGB is DXGI_FORMAT_R16G16B16A16_FLOAT holding linear BT.709 primaries, 1.0 is 80 nits.
+	// Values can exceed 1.0 for highlights or be negative for colors outside of BT.709.
+	ScRGB Format = iota + 1
+	// PQ10 is DXGI_FORMAT_R10G10B10A2_UNORM holding BT.2020 primaries encoded with the SMPTE ST 2084 (PQ) curve.
+	PQ10
+)
+
+func (f Format) String() string {
+	switch f {
+	case ScRGB:
+		return "scRGB"
+	case PQ10:
+		return "PQ10"
+	}
+	return "unknown"
+}
+
+// BytesPerPixel returns the size of a pixel of f.
+func (f Format) BytesPerPixel() int {
+	switch f {
+	case ScRGB:
+		return 8
+	case PQ10:
+		return 4
+	}
+	panic("unknown HDR format")
+}
+
+// Options configures the conversion to SDR.
+type Options struct {
+	ToneMap ToneMap
+	// SDRWhite is the luminance in nits which is mapped to white, 80 if zero.
+	// Windows shows SDR content at the "SDR content brightness" level, use that to keep SDR content unchanged.
+	SDRWhite float64
+	// Peak is the highest luminance in nits expected in the source, 1000 if zero.
+	// The tone mapping curves map it to white.
+	Peak float64
+}
+
+const scRGBWhite = 80
+
+func (o Options) sdrWhite() float64 {
+	if o.SDRWhite <= 0 {
+		return scRGBWhite
+	}
+	return o.SDRWhite
+}
+
+// peak returns the source peak relative to the SDR white.
+func (o Options) peak() float64 {
+	peak := o.Peak
+	if peak <= 0 {
+		peak = 1000
+	}
+	return math.Max(peak/o.sdrWhite(), 1)
+}
+
+// The tone curve is stored as a factor for every value of the brightest component,
+// in 1<<curveBits steps for every power of two between 2^curveMinExp and 2^curveMaxExp relative to the SDR white.
+const (
+	curveMinExp = -14
+	curveMaxExp = 8
+	curveBits   = 6
+	curveSize   = (curveMaxExp - curveMinExp) << curveBits
+)
+
+// Converter converts HDR pixels to 8-bit sRGB.
+// It holds lookup tables for its Options, so it should be created once and reused.
+type Converter struct {
+	format Format
+	// scale makes the SDR white 1.0 for scRGB sources
+	scale float32
+	// pq holds the linear value of every 10-bit PQ code, relative to the SDR white
+	pq []float32
+	// curve holds the tone mapped value divided by the input value
+	curve [curveSize + 1]float32
+}
+
+// NewConverter returns a Converter for pixels of format f.
+func NewConverter(f Format, opts Options) *Converter {
+	if f != ScRGB && f != PQ10 {
+		panic("unknown HDR format")
+	}
+	c := &Converter{format: f}
+	white := opts.sdrWhite()
+	c.scale = float32(scRGBWhite / white)
+	if f == ScRGB {
+		halfOnce.Do(initHalfTable)
+	}
+	if f == PQ10 {
+		c.pq = make([]float32, 1024)
+		for i := range c.pq {
+			c.pq[i] = float32(pqEOTF(float64(i)/1023) / white)
+		}
+	}
+	t := newToneCurve(opts)
+	for i := range c.curve {
+		m := math.Ldexp(1+float64(i&(1<<curveBits-1))/(1<<curveBits), curveMinExp+i>>curveBits)
+		c.curve[i] = float32(t.apply(m) / m)
+	}
+	return c
+}
+
+// Format returns the format of the source pixels.
+func (c *Converter) Format() Format { return c.format }
+
+// ToRGBA converts the pixels of r from src into RGBA pixels in dst.
+//
+// Rows start every srcStride bytes in src and every dstStride bytes in dst.
+// r is given in pixels and addresses the same position in both buffers, pixels outside of it are not touched.
+// It panics if r does not fit into either buffer.
+func (c *Converter) ToRGBA(dst []byte, dstStride int, src []byte, srcStride int, r image.Rectangle) {
+	c.convert(dst, dstStride, src, srcStride, r, false)
+}
+
+// ToBGRA is like ToRGBA, but writes BGRA pixels.
+func (c *Converter) ToBGRA(dst []byte, dstStride int, src []byte, srcStride int, r image.Rectangle) {
+	c.convert(dst, dstStride, src, srcStride, r, true)
+}
+
+func (c *Converter) convert(dst []byte, dstStride int, src []byte, srcStride int, r image.Rectangle, bgr bool) {
+	if r.Empty() {
+		return
+	}
+	bpp := c.format.BytesPerPixel()
+	if r.Min.X < 0 || r.Min.Y < 0 || (r.Max.Y-1)*srcStride+r.Max.X*bpp > len(src) || (r.Max.Y-1)*dstStride+r.Max.X*4 > len(dst) {
+		panic("rectangle is outside of the buffers")
+	}
+	ri, bi := 0, 2
+	if bgr {
+		ri, bi = 2, 0
+	}
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		s := src[y*srcStride+r.Min.X*bpp:][:r.Dx()*bpp]
+		d := dst[y*dstStride+r.Min.X*4:][:r.Dx()*4]
+		for i := 0; i < len(d); i, s = i+4, s[bpp:] {
+			var cr, cg, cb float32
+			var a uint8
+			if c.format == ScRGB {
+				cr = halfTable[binary.LittleEndian.Uint16(s[0:])] * c.scale
+				cg = halfTable[binary.LittleEndian.Uint16(s[2:])] * c.scale
+				cb = halfTable[binary.LittleEndian.Uint16(s[4:])] * c.scale
+				a = unorm8(halfTable[binary.LittleEndian.Uint16(s[6:])])
+			} else {
+				v := binary.LittleEndian.Uint32(s)
+				cr, cg, cb = bt2020To709(c.pq[v&1023], c.pq[v>>10&1023], c.pq[v>>20&1023])
+				a = uint8(v>>30) * 85
+			}
+			if m := max3(cr, cg, cb); m > 0 {
+				k := c.factor(m)
+				cr, cg, cb = cr*k, cg*k, cb*k
+			}
+			d[i+ri], d[i+1], d[i+bi], d[i+3] = srgb(cr), srgb(cg), srgb(cb), a
+		}
+	}
+}
+
+// factor returns the tone mapped value of m divided by m.
+func (c *Converter) factor(m float32) float32 {
+	b := math.Float32bits(m)
+	e := int(b>>23) - 127
+	if e < curveMinExp {
+		return c.curve[0]
+	}
+	if e >= curveMaxExp {
+		return c.curve[curveSize]
+	}
+	i := (e-curveMinExp)<<curveBits | int(b>>(23-curveBits))&(1<<curveBits-1)
+	frac := float32(b&(1<<(23-curveBits)-1)) / (1 << (23 - curveBits))
+	return c.curve[i] + (c.curve[i+1]-c.curve[i])*frac
+}
+
+func max3(a, b, c float32) float32 {
+	if b > a {
+		a = b
+	}
+	if c > a {
+		a = c
+	}
+	return a
+}
+
+// bt2020To709 converts linear BT.2020 to linear BT.709 primaries.
+func bt2020To709(r, g, b float32) (float32, float32, float32) {
+	return 1.6605*r - 0.5876*g - 0.0728*b,
+		-0.1246*r + 1.1329*g - 0.0083*b,
+		-0.0182*r - 0.1006*g + 1.1187*b
+}
+
+var (
+	halfOnce sync.Once
+	// halfTable holds the value of every half precision float, it is only allocated when needed
+	halfTable *[1 << 16]float32
+)
+
+func initHalfTable() {
+	halfTable = new([1 << 16]float32)
+	for i := range halfTable {
+		halfTable[i] = halfToFloat(uint16(i))
+	}
+}
+
+// halfToFloat converts an IEEE 754 half precision float.
+func halfToFloat(h uint16) float32 {
+	sign := uint32(h&0x8000) << 16
+	exp := uint32(h>>10) & 0x1f
+	mant := uint32(h & 0x3ff)
+	switch exp {
+	case 0:
+		// zero or subnormal
+		f := float32(mant) / (1 << 24)
+		if sign != 0 {
+			return -f
+		}
+		return f
+	case 0x1f:
+		// infinity or NaN
+		return math.Float32frombits(sign | 0x7f800000 | mant<<13)
+	}
+	return math.Float32frombits(sign | (exp+127-15)<<23 | mant<<13)
+}
+
+func unorm8(v float32) uint8 {
+	if !(v > 0) {
+		return 0
+	}
+	if v >= 1 {
+		return 255
+	}
+	return uint8(v*255 + 0.5)
+}
+
+const srgbSize = 1 << 14
+
+// srgbTable holds the sRGB encoding of linear values in [0, 1].
+var srgbTable [srgbSize + 1]uint8
+
+func init() {
+	for i := range srgbTable {
+		srgbTable[i] = uint8(math.Round(255 * srgbOETF(float64(i)/srgbSize)))
+	}
+}
+
+// srgb encodes a linear value, clipping it to [0, 1].
+func srgb(v float32) uint8 {
+	if !(v > 0) {
+		return 0
+	}
+	if v >= 1 {
+		return 255
+	}
+	return srgbTable[int(v*srgbSize+0.5)]
+}
+
+func srgbOETF(v float64) float64 {
+	if v <= 0.0031308 {
+		return 12.92 * v
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
+
+// SMPTE ST 2084 constants
+const (
+	pqM1 = 2610.0 / 16384
+	pqM2 = 2523.0 / 4096 * 128
+	pqC1 = 3424.0 / 4096
+	pqC2 = 2413.0 / 4096 * 32
+	pqC3 = 2392.0 / 4096 * 32
+)
+
+// pqEOTF returns the luminance in nits of the PQ value e in [0, 1].
+func pqEOTF(e float64) float64 {
+	p := math.Pow(e, 1/pqM2)
+	return 10000 * math.Pow(math.Max(p-pqC1, 0)/(pqC2-pqC3*p), 1/pqM1)
+}
+
+// pqInverseEOTF returns the PQ value of a luminance in nits.
+func pqInverseEOTF(nits float64) float64 {
+	y := math.Pow(math.Max(nits, 0)/10000, pqM1)
+	return math.Pow((pqC1+pqC2*y)/(1+pqC3*y), pqM2)
+}
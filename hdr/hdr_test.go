@@ -0,0 +1,273 @@
+package hdr
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+var toneMaps = []ToneMap{Clip, Reinhard, Hable, BT2390}
+
+func TestHalfToFloat(t *testing.T) {
+	testCases := []struct {
+		h    uint16
+		want float32
+	}{
+		{0x0000, 0},
+		{0x3C00, 1},
+		{0x3800, 0.5},
+		{0xC000, -2},
+		{0x4B00, 14},
+		{0x7BFF, 65504},
+		{0x0001, 1.0 / (1 << 24)},
+		{0x03FF, 1023.0 / (1 << 24)},
+		{0x0400, 1.0 / (1 << 14)},
+		{0x7C00, float32(math.Inf(1))},
+		{0xFC00, float32(math.Inf(-1))},
+	}
+	for _, tc := range testCases {
+		if got := halfToFloat(tc.h); got != tc.want {
+			t.Errorf("halfToFloat(%#04x) = %v, want %v", tc.h, got, tc.want)
+		}
+	}
+	if got := halfToFloat(0x7E00); !math.IsNaN(float64(got)) {
+		t.Errorf("halfToFloat(0x7E00) = %v, want NaN", got)
+	}
+}
+
+func TestPQ(t *testing.T) {
+	testCases := []struct{ e, nits float64 }{
+		{0, 0},
+		{1, 10000},
+		// BT.2408 reference white
+		{0.5806, 203},
+		{0.7518, 1000},
+	}
+	for _, tc := range testCases {
+		if got := pqEOTF(tc.e); math.Abs(got-tc.nits) > tc.nits*0.002+1e-9 {
+			t.Errorf("pqEOTF(%v) = %v, want %v", tc.e, got, tc.nits)
+		}
+	}
+	for e := 0.0; e <= 1; e += 1.0 / 64 {
+		if got := pqInverseEOTF(pqEOTF(e)); math.Abs(got-e) > 1e-6 {
+			t.Errorf("pqInverseEOTF(pqEOTF(%v)) = %v", e, got)
+		}
+	}
+}
+
+func TestToneCurves(t *testing.T) {
+	opts := Options{SDRWhite: 100, Peak: 1000}
+	for _, op := range toneMaps {
+		opts.ToneMap = op
+		c := newToneCurve(opts)
+		if got := c.apply(0); math.Abs(got) > 1e-6 {
+			t.Errorf("%v: black = %v, want 0", op, got)
+		}
+		if got := c.apply(c.peak); op != Clip && math.Abs(got-1) > 1e-6 {
+			t.Errorf("%v: peak = %v, want 1", op, got)
+		}
+		prev := -1.0
+		for m := 0.0; m <= c.peak; m += c.peak / 1000 {
+			v := c.apply(m)
+			if v < prev {
+				t.Fatalf("%v: not monotonic at %v: %v < %v", op, m, v, prev)
+			}
+			prev = v
+		}
+	}
+
+	// values well below the SDR white are not changed
+	for _, op := range []ToneMap{Clip, BT2390} {
+		opts.ToneMap = op
+		c := newToneCurve(opts)
+		for _, m := range []float64{0.01, 0.1} {
+			if got := c.apply(m); math.Abs(got-m) > 1e-9 {
+				t.Errorf("%v(%v) = %v, want unchanged", op, m, got)
+			}
+		}
+	}
+
+	// nothing to compress if the peak is not above the SDR white
+	opts = Options{ToneMap: BT2390, SDRWhite: 200, Peak: 200}
+	if got := newToneCurve(opts).apply(0.9); got != 0.9 {
+		t.Errorf("BT2390 without headroom = %v, want 0.9", got)
+	}
+}
+
+// reference converts a single pixel with float64 math and without lookup tables.
+func reference(f Format, p []byte, opts Options) [4]uint8 {
+	white := opts.sdrWhite()
+	var rgb [3]float64
+	var a uint8
+	switch f {
+	case ScRGB:
+		for i := range rgb {
+			rgb[i] = float64(halfToFloat(binary.LittleEndian.Uint16(p[i*2:]))) * scRGBWhite / white
+		}
+		a = unorm8(halfToFloat(binary.LittleEndian.Uint16(p[6:])))
+	case PQ10:
+		v := binary.LittleEndian.Uint32(p)
+		var c [3]float64
+		for i := range c {
+			c[i] = pqEOTF(float64(v>>(i*10)&1023)/1023) / white
+		}
+		rgb = [3]float64{
+			1.6605*c[0] - 0.5876*c[1] - 0.0728*c[2],
+			-0.1246*c[0] + 1.1329*c[1] - 0.0083*c[2],
+			-0.0182*c[0] - 0.1006*c[1] + 1.1187*c[2],
+		}
+		a = uint8(v>>30) * 85
+	}
+	m := math.Max(rgb[0], math.Max(rgb[1], rgb[2]))
+	if m > 0 {
+		k := newToneCurve(opts).apply(m) / m
+		for i := range rgb {
+			rgb[i] *= k
+		}
+	}
+	var out [4]uint8
+	for i, v := range rgb {
+		if v > 0 {
+			out[i] = uint8(math.Round(255 * srgbOETF(math.Min(v, 1))))
+		}
+	}
+	out[3] = a
+	return out
+}
+
+// randomPixels returns w x h synthetic HDR pixels with padded rows.
+func randomPixels(r *rand.Rand, f Format, w, h int) ([]byte, int) {
+	bpp := f.BytesPerPixel()
+	stride := w*bpp + r.Intn(3)*bpp
+	pix := make([]byte, h*stride)
+	for i := 0; i+bpp <= len(pix); i += bpp {
+		switch f {
+		case ScRGB:
+			for c := 0; c < 3; c++ {
+				// positive values up to 64, i.e. 5120 nits, and some negative ones
+				h := uint16(r.Intn(0x5400))
+				if r.Intn(8) == 0 {
+					h |= 0x8000
+				}
+				binary.LittleEndian.PutUint16(pix[i+c*2:], h)
+			}
+			binary.LittleEndian.PutUint16(pix[i+6:], 0x3C00)
+		case PQ10:
+			binary.LittleEndian.PutUint32(pix[i:], r.Uint32())
+		}
+	}
+	return pix, stride
+}
+
+func TestConvert(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, f := range []Format{ScRGB, PQ10} {
+		for _, op := range toneMaps {
+			for _, opts := range []Options{{ToneMap: op}, {ToneMap: op, SDRWhite: 203, Peak: 4000}} {
+				name := fmt.Sprintf("%v %v white=%v peak=%v", f, op, opts.SDRWhite, opts.Peak)
+				c := NewConverter(f, opts)
+				w, h := 37, 9
+				src, srcStride := randomPixels(r, f, w, h)
+				rgba := image.NewRGBA(image.Rect(0, 0, w+3, h))
+				bgra := image.NewRGBA(rgba.Rect)
+				sub := image.Rect(1, 1, w, h-1)
+				c.ToRGBA(rgba.Pix, rgba.Stride, src, srcStride, sub)
+				c.ToBGRA(bgra.Pix, bgra.Stride, src, srcStride, sub)
+				for y := 0; y < h; y++ {
+					for x := 0; x < w+3; x++ {
+						got := rgba.Pix[rgba.PixOffset(x, y):][:4]
+						gotBGRA := bgra.Pix[bgra.PixOffset(x, y):][:4]
+						if !(image.Point{x, y}).In(sub) {
+							if got[0]|got[1]|got[2]|got[3] != 0 || gotBGRA[0]|gotBGRA[1]|gotBGRA[2]|gotBGRA[3] != 0 {
+								t.Fatalf("%s: pixel (%d, %d) outside of the rectangle was written", name, x, y)
+							}
+							continue
+						}
+						want := reference(f, src[y*srcStride+x*f.BytesPerPixel():], opts)
+						for i := range want {
+							if d := int(got[i]) - int(want[i]); d < -1 || d > 1 {
+								t.Fatalf("%s: pixel (%d, %d) = %v, want %v", name, x, y, got, want)
+							}
+						}
+						if gotBGRA[0] != got[2] || gotBGRA[1] != got[1] || gotBGRA[2] != got[0] || gotBGRA[3] != got[3] {
+							t.Fatalf("%s: BGRA pixel (%d, %d) = %v, RGBA %v", name, x, y, gotBGRA, got)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestConvertKnownValues(t *testing.T) {
+	half := func(v ...uint16) []byte {
+		b := make([]byte, 8)
+		for i, h := range v {
+			binary.LittleEndian.PutUint16(b[i*2:], h)
+		}
+		return b
+	}
+	pq := func(r, g, b, a uint32) []byte {
+		p := make([]byte, 4)
+		binary.LittleEndian.PutUint32(p, r|g<<10|b<<20|a<<30)
+		return p
+	}
+	// the PQ code of 203 nits
+	white203 := uint32(math.Round(pqInverseEOTF(203) * 1023))
+
+	testCases := []struct {
+		name string
+		f    Format
+		opts Options
+		pix  []byte
+		want [4]uint8
+	}{
+		{"scRGB white", ScRGB, Options{}, half(0x3C00, 0x3C00, 0x3C00, 0x3C00), [4]uint8{255, 255, 255, 255}},
+		{"scRGB black", ScRGB, Options{ToneMap: Hable}, half(0, 0, 0, 0x3C00), [4]uint8{0, 0, 0, 255}},
+		{"scRGB linear 0.5", ScRGB, Options{}, half(0x3800, 0, 0, 0x3C00), [4]uint8{188, 0, 0, 255}},
+		{"scRGB 2.0 clipped", ScRGB, Options{}, half(0x4000, 0x4000, 0x4000, 0x3C00), [4]uint8{255, 255, 255, 255}},
+		{"scRGB 2.0 at 160 nits white", ScRGB, Options{SDRWhite: 160}, half(0x4000, 0x4000, 0x4000, 0x3C00), [4]uint8{255, 255, 255, 255}},
+		{"scRGB peak", ScRGB, Options{ToneMap: Reinhard, Peak: 800}, half(0x4900, 0x4900, 0x4900, 0x3C00), [4]uint8{255, 255, 255, 255}},
+		{"scRGB negative", ScRGB, Options{}, half(0xBC00, 0x3C00, 0x7E00, 0x3C00), [4]uint8{0, 255, 0, 255}},
+		{"PQ reference white", PQ10, Options{SDRWhite: 203}, pq(white203, white203, white203, 3), [4]uint8{255, 255, 255, 255}},
+		{"PQ black", PQ10, Options{ToneMap: BT2390}, pq(0, 0, 0, 3), [4]uint8{0, 0, 0, 255}},
+		{"PQ BT.2020 green", PQ10, Options{SDRWhite: 203}, pq(0, white203, 0, 3), [4]uint8{0, 255, 0, 255}},
+		{"PQ alpha", PQ10, Options{}, pq(0, 0, 0, 1), [4]uint8{0, 0, 0, 85}},
+	}
+	for _, tc := range testCases {
+		var got [4]uint8
+		NewConverter(tc.f, tc.opts).ToRGBA(got[:], 4, tc.pix, len(tc.pix), image.Rect(0, 0, 1, 1))
+		if got != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestConvertOutOfBounds(t *testing.T) {
+	c := NewConverter(ScRGB, Options{})
+	defer func() {
+		if recover() == nil {
+			t.Fatal("converting outside of the source did not panic")
+		}
+	}()
+	// the source has 4 bytes per pixel too few
+	c.ToRGBA(make([]byte, 16*4), 16, make([]byte, 16*4), 16, image.Rect(0, 0, 4, 4))
+}
+
+func BenchmarkConvert(b *testing.B) {
+	for _, f := range []Format{ScRGB, PQ10} {
+		b.Run(f.String(), func(b *testing.B) {
+			src, stride := randomPixels(rand.New(rand.NewSource(1)), f, 1920, 1080)
+			dst := image.NewRGBA(image.Rect(0, 0, 1920, 1080))
+			c := NewConverter(f, Options{ToneMap: BT2390})
+			b.SetBytes(int64(len(dst.Pix)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				c.ToRGBA(dst.Pix, dst.Stride, src, stride, dst.Rect)
+			}
+		})
+	}
+}
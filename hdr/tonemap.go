@@ -0,0 +1,89 @@
+package hdr
+
+import "math"
+
+// ToneMap selects the curve that compresses luminance above the SDR white.
+//
+// The curve is applied to the brightest component of a pixel and all components are scaled by the same factor,
+// which keeps the hue of highlights.
+type ToneMap int
+
+const (
+	// Clip clips every component at the SDR white, like the conversion done by Windows for SDR duplication.
+	Clip ToneMap = iota
+	// Reinhard is the extended Reinhard operator, it darkens the whole image but keeps the most detail in highlights.
+	Reinhard
+	// Hable is the filmic curve of Uncharted 2.
+	Hable
+	// BT2390 is the EETF of ITU-R BT.2390, which leaves values well below the SDR white unchanged
+	// and compresses the rest with a spline in the PQ domain.
+	BT2390
+)
+
+func (t ToneMap) String() string {
+	switch t {
+	case Clip:
+		return "clip"
+	case Reinhard:
+		return "reinhard"
+	case Hable:
+		return "hable"
+	case BT2390:
+		return "bt2390"
+	}
+	return "unknown"
+}
+
+// toneCurve maps values relative to the SDR white.
+type toneCurve struct {
+	op    ToneMap
+	white float64
+	peak  float64
+}
+
+func newToneCurve(opts Options) toneCurve {
+	switch opts.ToneMap {
+	case Clip, Reinhard, Hable, BT2390:
+	default:
+		panic("unknown tone map")
+	}
+	return toneCurve{op: opts.ToneMap, white: opts.sdrWhite(), peak: opts.peak()}
+}
+
+// apply returns the tone mapped value of m, the result is clipped at 1 later.
+func (t toneCurve) apply(m float64) float64 {
+	switch t.op {
+	case Reinhard:
+		return m * (1 + m/(t.peak*t.peak)) / (1 + m)
+	case Hable:
+		return hable(hableExposure*m) / hable(hableExposure*t.peak)
+	case BT2390:
+		return t.bt2390(m)
+	}
+	return m
+}
+
+const hableExposure = 2
+
+func hable(x float64) float64 {
+	const a, b, c, d, e, f = 0.15, 0.50, 0.10, 0.20, 0.02, 0.30
+	return (x*(a*x+c*b)+d*e)/(x*(a*x+b)+d*f) - e/f
+}
+
+// bt2390 maps the source range up to the peak to the range up to the SDR white, without changing the black level.
+func (t toneCurve) bt2390(m float64) float64 {
+	srcMax := pqInverseEOTF(t.peak * t.white)
+	maxLum := pqInverseEOTF(t.white) / srcMax
+	if maxLum >= 1 {
+		return m
+	}
+	e := pqInverseEOTF(m*t.white) / srcMax
+	ks := 1.5*maxLum - 0.5
+	if e > ks {
+		// hermite spline from the knee to the target peak
+		p := (math.Min(e, 1) - ks) / (1 - ks)
+		p2, p3 := p*p, p*p*p
+		e = (2*p3-3*p2+1)*ks + (p3-2*p2+p)*(1-ks) + (-2*p3+3*p2)*maxLum
+	}
+	return pqEOTF(e*srcMax) / t.white
+}
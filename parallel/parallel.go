@@ -0,0 +1,174 @@
+// Package parallel processes images in horizontal bands on a bounded number of goroutines.
+//
+// The goroutines of a Pool are started once and reused, so running a task on every frame does not allocate.
+// The calling goroutine processes bands as well, a task never waits for workers that are busy with other tasks.
+package parallel
+
+import (
+	"image"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// Task processes the rows y0 to y1 of band i.
+// Bands run concurrently, but every band index is processed by one goroutine at a time,
+// so it can be used to select scratch buffers.
+type Task interface {
+	Band(i, y0, y1 int)
+}
+
+// TaskFunc adapts a function to a Task.
+// A closure allocates when it is run, a Task that is kept for every frame does not.
+type TaskFunc func(i, y0, y1 int)
+
+// Band calls f(i, y0, y1).
+func (f TaskFunc) Band(i, y0, y1 int) {
+	f(i, y0, y1)
+}
+
+// Pool runs tasks on a fixed number of goroutines. It is safe for concurrent use.
+type Pool struct {
+	workers int
+	once    sync.Once
+	work    chan *job
+}
+
+// Default is a pool of GOMAXPROCS goroutines, including the calling one.
+var Default = NewPool(0)
+
+// NewPool returns a pool that processes a task on at most workers goroutines, including the calling one.
+// If workers is zero or negative, GOMAXPROCS at the time of the call is used.
+// The goroutines are started by the first task.
+func NewPool(workers int) *Pool {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	return &Pool{workers: workers, work: make(chan *job)}
+}
+
+// Workers returns the maximum number of goroutines of a task.
+func (p *Pool) Workers() int {
+	return p.workers
+}
+
+// Bands returns the number of bands Run splits h rows into, so that no band has less than minRows rows.
+func (p *Pool) Bands(h, minRows int) int {
+	if minRows < 1 {
+		minRows = 1
+	}
+	n := p.workers
+	if max := h / minRows; n > max {
+		n = max
+	}
+	if n < 1 && h > 0 {
+		n = 1
+	}
+	return n
+}
+
+// Run splits the rows 0 to h into Bands(h, minRows) bands of similar height and returns after t processed all of them.
+func (p *Pool) Run(h, minRows int, t Task) {
+	n := p.Bands(h, minRows)
+	if n == 0 {
+		return
+	}
+	if n == 1 {
+		t.Band(0, 0, h)
+		return
+	}
+	p.once.Do(p.start)
+	j := jobs.Get().(*job)
+	j.task, j.h, j.bands, j.next = t, h, n, 0
+	// only idle workers join, the remaining bands are processed by the calling goroutine
+join:
+	for i := 1; i < n; i++ {
+		j.wg.Add(1)
+		select {
+		case p.work <- j:
+		default:
+			j.wg.Done()
+			break join
+		}
+	}
+	j.run()
+	j.wg.Wait()
+	j.task = nil
+	jobs.Put(j)
+}
+
+// Close stops the goroutines of the pool once they finished their current task. The pool must not be used afterwards.
+func (p *Pool) Close() {
+	close(p.work)
+}
+
+func (p *Pool) start() {
+	for i := 1; i < p.workers; i++ {
+		go func() {
+			for j := range p.work {
+				j.run()
+				j.wg.Done()
+			}
+		}()
+	}
+}
+
+// job is a task being run, shared by all goroutines processing it.
+type job struct {
+	task     Task
+	h, bands int
+	// next is the index of the next band to process
+	next int32
+	wg   sync.WaitGroup
+}
+
+var jobs = sync.Pool{New: func() interface{} { return new(job) }}
+
+// run processes bands until none are left.
+func (j *job) run() {
+	for {
+		i := int(atomic.AddInt32(&j.next, 1)) - 1
+		if i >= j.bands {
+			return
+		}
+		j.task.Band(i, j.h*i/j.bands, j.h*(i+1)/j.bands)
+	}
+}
+
+// CopyFunc is a kernel copying the pixels of r between buffers with the given strides, like swizzle.CopyBGRA.
+type CopyFunc func(dst []byte, dstStride int, src []byte, srcStride int, r image.Rectangle)
+
+// minCopyBytes is the smallest number of bytes a band of Copy writes.
+const minCopyBytes = 256 << 10
+
+// Copy runs the kernel fn on bands of r. Rectangles smaller than a few hundred kilobytes are copied by the calling goroutine.
+func (p *Pool) Copy(fn CopyFunc, dst []byte, dstStride int, src []byte, srcStride int, r image.Rectangle) {
+	if r.Empty() {
+		return
+	}
+	minRows := minCopyBytes / (r.Dx() * 4)
+	if p.Bands(r.Dy(), minRows) <= 1 {
+		fn(dst, dstStride, src, srcStride, r)
+		return
+	}
+	c := copies.Get().(*copyTask)
+	*c = copyTask{fn: fn, dst: dst, dstStride: dstStride, src: src, srcStride: srcStride, r: r}
+	p.Run(r.Dy(), minRows, c)
+	*c = copyTask{}
+	copies.Put(c)
+}
+
+type copyTask struct {
+	fn                   CopyFunc
+	dst, src             []byte
+	dstStride, srcStride int
+	r                    image.Rectangle
+}
+
+var copies = sync.Pool{New: func() interface{} { return new(copyTask) }}
+
+func (c *copyTask) Band(_, y0, y1 int) {
+	r := c.r
+	r.Min.Y, r.Max.Y = c.r.Min.Y+y0, c.r.Min.Y+y1
+	c.fn(c.dst, c.dstStride, c.src, c.srcStride, r)
+}
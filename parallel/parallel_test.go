@@ -0,0 +1,157 @@
+package parallel
+
+import (
+	"image"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// rowCounter counts how often every row was processed and the largest number of concurrent bands.
+type rowCounter struct {
+	rows          []int32
+	bands         []int32
+	running, peak int32
+}
+
+func (c *rowCounter) Band(i, y0, y1 int) {
+	if n := atomic.AddInt32(&c.running, 1); n > atomic.LoadInt32(&c.peak) {
+		atomic.StoreInt32(&c.peak, n)
+	}
+	if atomic.AddInt32(&c.bands[i], 1) != 1 {
+		panic("band processed twice")
+	}
+	for y := y0; y < y1; y++ {
+		atomic.AddInt32(&c.rows[y], 1)
+	}
+	// give the other goroutines a chance to run concurrently
+	for k := 0; k < 1000; k++ {
+		atomic.LoadInt32(&c.running)
+	}
+	atomic.AddInt32(&c.running, -1)
+}
+
+func TestRun(t *testing.T) {
+	for _, workers := range []int{1, 2, 5} {
+		p := NewPool(workers)
+		for _, h := range []int{0, 1, 7, 16, 100, 1081} {
+			for _, minRows := range []int{0, 1, 16, 2000} {
+				c := &rowCounter{rows: make([]int32, h), bands: make([]int32, workers)}
+				p.Run(h, minRows, c)
+				for y, n := range c.rows {
+					if n != 1 {
+						t.Fatalf("workers %d, %d rows of at least %d: row %d processed %d times", workers, h, minRows, y, n)
+					}
+				}
+				bands := p.Bands(h, minRows)
+				for i, n := range c.bands {
+					want := int32(0)
+					if i < bands {
+						want = 1
+					}
+					if n != want {
+						t.Fatalf("workers %d, %d rows of at least %d: band %d processed %d times", workers, h, minRows, i, n)
+					}
+				}
+				if int(c.peak) > workers {
+					t.Fatalf("workers %d: %d bands ran concurrently", workers, c.peak)
+				}
+			}
+		}
+		p.Close()
+	}
+}
+
+func TestBands(t *testing.T) {
+	p := NewPool(4)
+	for _, c := range []struct{ h, minRows, want int }{
+		{0, 16, 0},
+		{1, 16, 1},
+		{31, 16, 1},
+		{32, 16, 2},
+		{1080, 16, 4},
+		{1080, 0, 4},
+		{3, 0, 3},
+	} {
+		if got := p.Bands(c.h, c.minRows); got != c.want {
+			t.Errorf("Bands(%d, %d) = %d, want %d", c.h, c.minRows, got, c.want)
+		}
+	}
+}
+
+func TestRunConcurrently(t *testing.T) {
+	// tasks share the workers, nested tasks are processed by the calling goroutine if no worker is idle
+	p := NewPool(3)
+	defer p.Close()
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k := 0; k < 50; k++ {
+				var rows int32
+				p.Run(64, 1, TaskFunc(func(_, y0, y1 int) {
+					inner := &rowCounter{rows: make([]int32, 8), bands: make([]int32, 3)}
+					p.Run(8, 1, inner)
+					for _, n := range inner.rows {
+						if n != 1 {
+							panic("nested task skipped a row")
+						}
+					}
+					atomic.AddInt32(&rows, int32(y1-y0))
+				}))
+				if rows != 64 {
+					t.Errorf("processed %d rows, want 64", rows)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestRunAllocs(t *testing.T) {
+	p := NewPool(4)
+	defer p.Close()
+	c := &rowCounter{rows: make([]int32, 1000), bands: make([]int32, 4)}
+	if n := testing.AllocsPerRun(100, func() {
+		for i := range c.bands {
+			c.bands[i] = 0
+		}
+		p.Run(len(c.rows), 16, c)
+	}); n != 0 {
+		t.Errorf("Run allocates %v times, want 0", n)
+	}
+}
+
+// copyRows copies the rows of r like the kernels of the swizzle package.
+func copyRows(dst []byte, dstStride int, src []byte, srcStride int, r image.Rectangle) {
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		copy(dst[y*dstStride+r.Min.X*4:][:r.Dx()*4], src[y*srcStride+r.Min.X*4:])
+	}
+}
+
+func TestCopy(t *testing.T) {
+	p := NewPool(3)
+	defer p.Close()
+	src := make([]byte, 2000*4*500)
+	rand.New(rand.NewSource(1)).Read(src)
+	for _, r := range []image.Rectangle{
+		image.Rect(0, 0, 2000, 500),
+		image.Rect(3, 7, 1999, 401),
+		image.Rect(10, 10, 20, 30),
+		image.Rect(5, 5, 5, 5),
+	} {
+		want, got := make([]byte, 1900*4*500), make([]byte, 1900*4*500)
+		copyRows(want, 1900*4, src, 2000*4, r.Intersect(image.Rect(0, 0, 1900, 500)))
+		p.Copy(copyRows, got, 1900*4, src, 2000*4, r.Intersect(image.Rect(0, 0, 1900, 500)))
+		if string(got) != string(want) {
+			t.Fatalf("Copy of %v differs", r)
+		}
+		if n := testing.AllocsPerRun(10, func() {
+			p.Copy(copyRows, got, 1900*4, src, 2000*4, r.Intersect(image.Rect(0, 0, 1900, 500)))
+		}); n != 0 {
+			t.Errorf("Copy of %v allocates %v times, want 0", r, n)
+		}
+	}
+}
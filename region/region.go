@@ -0,0 +1,361 @@
+// Package region implements sets of pixels described by rectangles, e.g. the damaged parts of a frame.
+//
+// A Region is built from horizontal bands of spans, like X11 and pixman regions, where spans of
+// consecutive bands with the same horizontal extent form a single rectangle. Overlapping and
+// adjacent rectangles are merged, so two regions covering the same pixels have the same representation.
+package region
+
+import (
+	"container/heap"
+	"image"
+	"sort"
+)
+
+// Region is a set of pixels. The zero value is an empty region.
+// Regions are immutable, all operations return a new Region.
+type Region struct {
+	rects []image.Rectangle
+}
+
+// New returns the union of rects.
+func New(rects ...image.Rectangle) Region {
+	return Region{rects: combine(rects, nil, opUnion)}
+}
+
+// Rects returns the non-overlapping rectangles of r. The slice must not be modified.
+func (r Region) Rects() []image.Rectangle { return r.rects }
+
+func (r Region) Empty() bool { return len(r.rects) == 0 }
+
+// Bounds returns the smallest rectangle containing r.
+func (r Region) Bounds() image.Rectangle {
+	if r.Empty() {
+		return image.Rectangle{}
+	}
+	b := r.rects[0]
+	for _, rect := range r.rects[1:] {
+		b = b.Union(rect)
+	}
+	return b
+}
+
+// Area returns the number of pixels in r.
+func (r Region) Area() int {
+	n := 0
+	for _, rect := range r.rects {
+		n += area(rect)
+	}
+	return n
+}
+
+func (r Region) Contains(p image.Point) bool {
+	for _, rect := range r.rects {
+		if p.In(rect) {
+			return true
+		}
+	}
+	return false
+}
+
+// Equal reports whether r and o contain the same pixels.
+func (r Region) Equal(o Region) bool {
+	if len(r.rects) != len(o.rects) {
+		return false
+	}
+	for i := range r.rects {
+		if r.rects[i] != o.rects[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Add returns the union of r and rects.
+func (r Region) Add(rects ...image.Rectangle) Region {
+	return Region{rects: combine(r.rects, rects, opUnion)}
+}
+
+func (r Region) Union(o Region) Region {
+	return Region{rects: combine(r.rects, o.rects, opUnion)}
+}
+
+func (r Region) Intersect(o Region) Region {
+	return Region{rects: combine(r.rects, o.rects, opIntersect)}
+}
+
+// Subtract returns the pixels of r that are not in o.
+func (r Region) Subtract(o Region) Region {
+	return Region{rects: combine(r.rects, o.rects, opSubtract)}
+}
+
+// Translate returns r moved by p.
+func (r Region) Translate(p image.Point) Region {
+	rects := make([]image.Rectangle, len(r.rects))
+	for i, rect := range r.rects {
+		rects[i] = rect.Add(p)
+	}
+	return Region{rects: rects}
+}
+
+// Snap returns r grown to a grid of tileW x tileH tiles, aligned to (0, 0).
+func (r Region) Snap(tileW, tileH int) Region {
+	rects := make([]image.Rectangle, len(r.rects))
+	for i, rect := range r.rects {
+		rects[i] = image.Rect(
+			floorDiv(rect.Min.X, tileW)*tileW,
+			floorDiv(rect.Min.Y, tileH)*tileH,
+			-floorDiv(-rect.Max.X, tileW)*tileW,
+			-floorDiv(-rect.Max.Y, tileH)*tileH,
+		)
+	}
+	return New(rects...)
+}
+
+// Cover returns at most maxRects rectangles covering r, by merging rectangles into their bounding box.
+//
+// Merging draws pixels that are not part of r. The merges adding the fewest pixels are done first
+// and no merge is done that would grow the number of drawn pixels beyond (1+maxOverdraw) * r.Area(),
+// so the result is only guaranteed to have at most maxRects rectangles if the budget allows it.
+// The returned rectangles may overlap.
+func (r Region) Cover(maxRects int, maxOverdraw float64) []image.Rectangle {
+	if maxRects < 1 {
+		maxRects = 1
+	}
+	if len(r.rects) <= maxRects {
+		return append([]image.Rectangle(nil), r.rects...)
+	}
+	budget := r.Area() + int(float64(r.Area())*maxOverdraw)
+
+	// merging pairs is quadratic, coarsen large regions by snapping them to a grid first
+	coarse := r
+	for tile := 8; len(coarse.rects) > maxPairwise && tile <= 1<<16; tile *= 2 {
+		snapped := r.Snap(tile, tile)
+		if snapped.Area() > budget {
+			break
+		}
+		coarse = snapped
+	}
+	return mergePairs(coarse.rects, coarse.Area(), budget, maxRects)
+}
+
+// maxPairwise is the number of rectangles up to which all pairs are considered for merging.
+const maxPairwise = 256
+
+type mergeCandidate struct {
+	cost, i, j int
+}
+
+type mergeHeap []mergeCandidate
+
+func (h mergeHeap) Len() int            { return len(h) }
+func (h mergeHeap) Less(i, j int) bool  { return h[i].cost < h[j].cost }
+func (h mergeHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *mergeHeap) Push(x interface{}) { *h = append(*h, x.(mergeCandidate)) }
+func (h *mergeHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// mergePairs greedily merges the cheapest pair of rects into its bounding box,
+// until at most maxRects remain or the next merge would draw more than budget pixels.
+func mergePairs(rects []image.Rectangle, drawn, budget, maxRects int) []image.Rectangle {
+	rects = append([]image.Rectangle(nil), rects...)
+	alive := make([]bool, len(rects), 2*len(rects))
+	for i := range alive {
+		alive[i] = true
+	}
+	live := len(rects)
+
+	// for large inputs only pair rectangles with their successors in top to bottom order
+	window := len(rects)
+	if window > maxPairwise {
+		window = maxPairwise / 8
+	}
+	h := make(mergeHeap, 0, len(rects)*window)
+	push := func(i, j int) {
+		cost := area(rects[i].Union(rects[j])) - area(rects[i]) - area(rects[j])
+		heap.Push(&h, mergeCandidate{cost, i, j})
+	}
+	for i := range rects {
+		for j := i + 1; j < len(rects) && j <= i+window; j++ {
+			h = append(h, mergeCandidate{area(rects[i].Union(rects[j])) - area(rects[i]) - area(rects[j]), i, j})
+		}
+	}
+	heap.Init(&h)
+
+	for live > maxRects && h.Len() > 0 {
+		c := heap.Pop(&h).(mergeCandidate)
+		if !alive[c.i] || !alive[c.j] {
+			continue
+		}
+		merged := rects[c.i].Union(rects[c.j])
+		next := drawn + c.cost
+		// rectangles inside the merged one do not have to be drawn anymore
+		var contained []int
+		for k, rect := range rects {
+			if alive[k] && k != c.i && k != c.j && rect.In(merged) {
+				next -= area(rect)
+				contained = append(contained, k)
+			}
+		}
+		if next > budget {
+			// the remaining merges are at least as expensive
+			break
+		}
+		drawn = next
+		alive[c.i], alive[c.j] = false, false
+		for _, k := range contained {
+			alive[k] = false
+		}
+		live -= 1 + len(contained)
+
+		rects = append(rects, merged)
+		alive = append(alive, true)
+		n := len(rects) - 1
+		for k := 0; k < n; k++ {
+			if alive[k] {
+				push(k, n)
+			}
+		}
+	}
+
+	out := make([]image.Rectangle, 0, live)
+	for k, rect := range rects {
+		if alive[k] {
+			out = append(out, rect)
+		}
+	}
+	return out
+}
+
+func area(r image.Rectangle) int {
+	return r.Dx() * r.Dy()
+}
+
+func floorDiv(a, b int) int {
+	q := a / b
+	if a%b != 0 && a < 0 {
+		q--
+	}
+	return q
+}
+
+type op func(inA, inB bool) bool
+
+func opUnion(inA, inB bool) bool     { return inA || inB }
+func opIntersect(inA, inB bool) bool { return inA && inB }
+func opSubtract(inA, inB bool) bool  { return inA && !inB }
+
+type span struct {
+	x0, x1 int
+}
+
+// combine applies op to the pixels covered by a and b, which may contain overlapping rectangles,
+// and returns the banded representation of the result.
+func combine(a, b []image.Rectangle, op op) []image.Rectangle {
+	ys := make([]int, 0, 2*(len(a)+len(b)))
+	for _, rects := range [][]image.Rectangle{a, b} {
+		for _, r := range rects {
+			if !r.Empty() {
+				ys = append(ys, r.Min.Y, r.Max.Y)
+			}
+		}
+	}
+	sort.Ints(ys)
+
+	var out []image.Rectangle
+	// open maps the spans of the previous band to their rectangle in out
+	open := make(map[span]int)
+	var sa, sb, spans []span
+	for i := 0; i+1 < len(ys); i++ {
+		y0, y1 := ys[i], ys[i+1]
+		if y0 == y1 {
+			continue
+		}
+		sa = spansAt(sa[:0], a, y0)
+		sb = spansAt(sb[:0], b, y0)
+		spans = combineSpans(spans[:0], sa, sb, op)
+		for _, s := range spans {
+			if k, ok := open[s]; ok && out[k].Max.Y == y0 {
+				// same span as in the band above, grow its rectangle
+				out[k].Max.Y = y1
+				continue
+			}
+			open[s] = len(out)
+			out = append(out, image.Rect(s.x0, y0, s.x1, y1))
+		}
+	}
+	return out
+}
+
+// spansAt appends the merged horizontal spans of rects covering row y to dst.
+func spansAt(dst []span, rects []image.Rectangle, y int) []span {
+	start := len(dst)
+	for _, r := range rects {
+		if !r.Empty() && r.Min.Y <= y && y < r.Max.Y {
+			dst = append(dst, span{r.Min.X, r.Max.X})
+		}
+	}
+	spans := dst[start:]
+	sort.Slice(spans, func(i, j int) bool { return spans[i].x0 < spans[j].x0 })
+	merged := dst[:start]
+	for _, s := range spans {
+		if n := len(merged); n > start && s.x0 <= merged[n-1].x1 {
+			if s.x1 > merged[n-1].x1 {
+				merged[n-1].x1 = s.x1
+			}
+			continue
+		}
+		merged = append(merged, s)
+	}
+	return merged
+}
+
+// combineSpans appends the spans where op holds for the sorted, merged spans a and b to dst.
+func combineSpans(dst []span, a, b []span, op op) []span {
+	i, j := 0, 0
+	x := 0
+	first := true
+	for i < len(a) || j < len(b) {
+		// the next boundary is the smallest span start or end that is still ahead
+		next := 0
+		found := false
+		consider := func(v int) {
+			if (first || v > x) && (!found || v < next) {
+				next, found = v, true
+			}
+		}
+		if i < len(a) {
+			consider(a[i].x0)
+			consider(a[i].x1)
+		}
+		if j < len(b) {
+			consider(b[j].x0)
+			consider(b[j].x1)
+		}
+		if !found {
+			break
+		}
+		if !first {
+			inA := i < len(a) && a[i].x0 <= x && x < a[i].x1
+			inB := j < len(b) && b[j].x0 <= x && x < b[j].x1
+			if op(inA, inB) {
+				if n := len(dst); n > 0 && dst[n-1].x1 == x {
+					dst[n-1].x1 = next
+				} else {
+					dst = append(dst, span{x, next})
+				}
+			}
+		}
+		x, first = next, false
+		for i < len(a) && a[i].x1 <= x {
+			i++
+		}
+		for j < len(b) && b[j].x1 <= x {
+			j++
+		}
+	}
+	return dst
+}
@@ -0,0 +1,173 @@
+package region
+
+import (
+	"image"
+	"math/rand"
+	"testing"
+)
+
+const gridSize = 24
+
+func randomRects(r *rand.Rand, n int) []image.Rectangle {
+	rects := make([]image.Rectangle, n)
+	for i := range rects {
+		x, y := r.Intn(gridSize)-2, r.Intn(gridSize)-2
+		rects[i] = image.Rect(x, y, x+r.Intn(10), y+r.Intn(10))
+	}
+	return rects
+}
+
+func pixels(rects []image.Rectangle) map[image.Point]bool {
+	set := make(map[image.Point]bool)
+	for _, r := range rects {
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				set[image.Pt(x, y)] = true
+			}
+		}
+	}
+	return set
+}
+
+// checkRegion verifies the invariants of r and that it contains exactly want.
+func checkRegion(t *testing.T, name string, r Region, want func(p image.Point) bool) {
+	t.Helper()
+	rects := r.Rects()
+	for i, a := range rects {
+		if a.Empty() {
+			t.Fatalf("%s: empty rectangle %v", name, a)
+		}
+		for _, b := range rects[i+1:] {
+			if a.Overlaps(b) {
+				t.Fatalf("%s: %v overlaps %v", name, a, b)
+			}
+			if a.Min.Y == b.Min.Y && a.Max.Y == b.Max.Y && (a.Max.X == b.Min.X || b.Max.X == a.Min.X) {
+				t.Fatalf("%s: adjacent rectangles %v and %v were not merged", name, a, b)
+			}
+		}
+	}
+	got := pixels(rects)
+	if n := r.Area(); n != len(got) {
+		t.Fatalf("%s: Area() = %d, want %d", name, n, len(got))
+	}
+	for y := -4; y < gridSize+12; y++ {
+		for x := -4; x < gridSize+12; x++ {
+			p := image.Pt(x, y)
+			if got[p] != want(p) {
+				t.Fatalf("%s: pixel %v: got %v, want %v", name, p, got[p], want(p))
+			}
+			if r.Contains(p) != want(p) {
+				t.Fatalf("%s: Contains(%v) = %v, want %v", name, p, !want(p), want(p))
+			}
+		}
+	}
+}
+
+func TestOperations(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		ra, rb := randomRects(rnd, rnd.Intn(8)), randomRects(rnd, rnd.Intn(8))
+		pa, pb := pixels(ra), pixels(rb)
+		a, b := New(ra...), New(rb...)
+
+		checkRegion(t, "New", a, func(p image.Point) bool { return pa[p] })
+		checkRegion(t, "Union", a.Union(b), func(p image.Point) bool { return pa[p] || pb[p] })
+		checkRegion(t, "Add", a.Add(rb...), func(p image.Point) bool { return pa[p] || pb[p] })
+		checkRegion(t, "Intersect", a.Intersect(b), func(p image.Point) bool { return pa[p] && pb[p] })
+		checkRegion(t, "Subtract", a.Subtract(b), func(p image.Point) bool { return pa[p] && !pb[p] })
+
+		if !a.Union(b).Equal(b.Union(a)) {
+			t.Fatalf("union is not commutative for %v and %v", ra, rb)
+		}
+		if !a.Subtract(b).Union(a.Intersect(b)).Equal(a) {
+			t.Fatalf("(a - b) | (a & b) != a for %v and %v", ra, rb)
+		}
+	}
+}
+
+func TestMergeAdjacent(t *testing.T) {
+	r := New(
+		image.Rect(0, 0, 10, 10),
+		image.Rect(10, 0, 20, 10),
+		image.Rect(0, 10, 20, 15),
+		image.Rect(5, 5, 15, 12),
+	)
+	if got := r.Rects(); len(got) != 1 || got[0] != image.Rect(0, 0, 20, 15) {
+		t.Errorf("Rects() = %v, want a single rectangle", got)
+	}
+	if b := r.Bounds(); b != image.Rect(0, 0, 20, 15) {
+		t.Errorf("Bounds() = %v", b)
+	}
+	if !(Region{}).Empty() || New(image.Rect(3, 3, 3, 9)).Area() != 0 {
+		t.Errorf("empty regions are not empty")
+	}
+}
+
+func TestSnap(t *testing.T) {
+	r := New(image.Rect(3, 3, 5, 5), image.Rect(-1, 17, 2, 18), image.Rect(16, 16, 17, 17)).Snap(8, 16)
+	want := New(image.Rect(0, 0, 8, 16), image.Rect(-8, 16, 8, 32), image.Rect(16, 16, 24, 32))
+	if !r.Equal(want) {
+		t.Errorf("Snap() = %v, want %v", r.Rects(), want.Rects())
+	}
+}
+
+func TestCover(t *testing.T) {
+	rnd := rand.New(rand.NewSource(2))
+	for i := 0; i < 100; i++ {
+		r := New(randomRects(rnd, 20+rnd.Intn(40))...)
+		for _, tt := range []struct {
+			max      int
+			overdraw float64
+		}{{1, 100}, {4, 1}, {8, 0.1}, {16, 0}} {
+			cover := r.Cover(tt.max, tt.overdraw)
+			drawn := 0
+			for _, c := range cover {
+				drawn += c.Dx() * c.Dy()
+			}
+			if !New(cover...).Subtract(r).Union(r).Equal(New(cover...)) {
+				t.Fatalf("Cover(%d, %v) does not cover the region", tt.max, tt.overdraw)
+			}
+			if limit := float64(r.Area()) * (1 + tt.overdraw); float64(drawn) > limit {
+				t.Fatalf("Cover(%d, %v) draws %d pixels, more than %v", tt.max, tt.overdraw, drawn, limit)
+			}
+			if tt.overdraw >= 100 && len(cover) > tt.max {
+				t.Fatalf("Cover(%d, %v) returned %d rectangles", tt.max, tt.overdraw, len(cover))
+			}
+		}
+	}
+	// disjoint, far apart rectangles are only merged if the budget allows it
+	r := New(image.Rect(0, 0, 2, 2), image.Rect(100, 100, 102, 102))
+	if got := r.Cover(1, 0.5); len(got) != 2 {
+		t.Errorf("Cover(1, 0.5) = %v, want both rectangles", got)
+	}
+	if got := r.Cover(1, 10000); len(got) != 1 {
+		t.Errorf("Cover(1, 10000) = %v, want the bounding box", got)
+	}
+}
+
+func BenchmarkNew(b *testing.B) {
+	rnd := rand.New(rand.NewSource(3))
+	rects := make([]image.Rectangle, 300)
+	for i := range rects {
+		x, y := rnd.Intn(1920), rnd.Intn(1080)
+		rects[i] = image.Rect(x, y, x+rnd.Intn(64), y+rnd.Intn(32))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		New(rects...)
+	}
+}
+
+func BenchmarkCover(b *testing.B) {
+	rnd := rand.New(rand.NewSource(3))
+	rects := make([]image.Rectangle, 300)
+	for i := range rects {
+		x, y := rnd.Intn(1920), rnd.Intn(1080)
+		rects[i] = image.Rect(x, y, x+rnd.Intn(64), y+rnd.Intn(32))
+	}
+	r := New(rects...)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.Cover(32, 0.5)
+	}
+}
@@ -0,0 +1,52 @@
+package scale
+
+// box2 averages 2x2 blocks of the rows s0 and s1 into len(dst)/4 pixels.
+func box2(dst, s0, s1 []byte) {
+	n := len(dst) / 4
+	if n == 0 {
+		return
+	}
+	_, _ = s0[n*8-1], s1[n*8-1]
+	x := 0
+	if useBox2x8 && n >= 8 {
+		box2x8(&dst[0], &s0[0], &s1[0], n/8)
+		x = n &^ 7
+	}
+	if useBox2x4 && n-x >= 4 {
+		box2x4(&dst[x*4], &s0[x*8], &s1[x*8], (n-x)/4)
+		x += (n - x) &^ 3
+	}
+	for ; x < n; x++ {
+		d, a, b := dst[x*4:x*4+4], s0[x*8:x*8+8], s1[x*8:x*8+8]
+		for c := range d {
+			d[c] = byte((uint32(a[c]) + uint32(a[c+4]) + uint32(b[c]) + uint32(b[c+4]) + 2) >> 2)
+		}
+	}
+}
+
+// box4 averages 4x4 blocks of the rows s into len(dst)/4 pixels.
+func box4(dst []byte, s *[4][]byte) {
+	n := len(dst) / 4
+	if n == 0 {
+		return
+	}
+	for _, row := range s {
+		_ = row[n*16-1]
+	}
+	x := 0
+	if useBox4x4 && n >= 4 {
+		box4x4(&dst[0], &s[0][0], &s[1][0], &s[2][0], &s[3][0], n/4)
+		x = n &^ 3
+	}
+	for ; x < n; x++ {
+		d := dst[x*4 : x*4+4]
+		for c := range d {
+			sum := uint32(8)
+			for _, row := range s {
+				p := row[x*16:]
+				sum += uint32(p[c]) + uint32(p[c+4]) + uint32(p[c+8]) + uint32(p[c+12])
+			}
+			d[c] = byte(sum >> 4)
+		}
+	}
+}
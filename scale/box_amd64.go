@@ -0,0 +1,15 @@
+package scale
+
+import "golang.org/x/sys/cpu"
+
+var useBox2x8 = cpu.X86.HasAVX2
+
+// SSE2 is always available on amd64.
+const (
+	useBox2x4 = true
+	useBox4x4 = true
+)
+
+func box2x4(dst, s0, s1 *byte, n int)
+func box2x8(dst, s0, s1 *byte, n int)
+func box4x4(dst, s0, s1, s2, s3 *byte, n int)
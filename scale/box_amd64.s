@@ -0,0 +1,175 @@
+#include "textflag.h"
+
+// round2 and round8 round the sums of 4 and 16 pixels before they are divided.
+DATA round2<>+0x00(SB)/8, $0x0002000200020002
+DATA round2<>+0x08(SB)/8, $0x0002000200020002
+DATA round2<>+0x10(SB)/8, $0x0002000200020002
+DATA round2<>+0x18(SB)/8, $0x0002000200020002
+GLOBL round2<>(SB), (NOPTR+RODATA), $32
+
+DATA round8<>+0x00(SB)/8, $0x0008000800080008
+DATA round8<>+0x08(SB)/8, $0x0008000800080008
+GLOBL round8<>(SB), (NOPTR+RODATA), $16
+
+// BOX2 averages the 4 pixels at off of the rows SI and DX into 2 pixels, as words in dst.
+#define BOX2(off, dst) \
+	MOVOU	off(SI), dst; \
+	MOVOU	off(DX), X1; \
+	MOVOU	dst, X2; \
+	PUNPCKLBW	X7, dst; \
+	PUNPCKHBW	X7, X2; \
+	MOVOU	X1, X3; \
+	PUNPCKLBW	X7, X1; \
+	PUNPCKHBW	X7, X3; \
+	PADDW	X1, dst; \
+	PADDW	X3, X2; \
+	MOVOU	dst, X1; \
+	PUNPCKLQDQ	X2, dst; \
+	PUNPCKHQDQ	X2, X1; \
+	PADDW	X1, dst; \
+	PADDW	X6, dst; \
+	PSRLW	$2, dst
+
+// func box2x4(dst, s0, s1 *byte, n int)
+//
+// box2x4 averages n blocks of 2x8 pixels of the rows s0 and s1 into 4 pixels each.
+TEXT ·box2x4(SB),NOSPLIT,$0-32
+	MOVQ	dst+0(FP), DI
+	MOVQ	s0+8(FP), SI
+	MOVQ	s1+16(FP), DX
+	MOVQ	n+24(FP), CX
+
+	PXOR	X7, X7
+	MOVOU	round2<>(SB), X6
+
+loop:
+	TESTQ	CX, CX
+	JZ	done
+
+	// X0 holds the words of d0 d1, X4 those of d2 d3.
+	BOX2(0, X0)
+	BOX2(16, X4)
+	PACKUSWB	X4, X0
+	MOVOU	X0, (DI)
+
+	ADDQ	$32, SI
+	ADDQ	$32, DX
+	ADDQ	$16, DI
+	DECQ	CX
+	JMP	loop
+
+done:
+	RET
+
+// BOX2Y averages the 8 pixels at off of the rows SI and DX into 4 pixels, as words in dst.
+// The pixels are ordered d0 d1 in the low lane and d2 d3 in the high lane.
+#define BOX2Y(off, dst) \
+	VMOVDQU	off(SI), Y0; \
+	VMOVDQU	off(DX), Y1; \
+	VPUNPCKLBW	Y7, Y0, Y2; \
+	VPUNPCKHBW	Y7, Y0, Y3; \
+	VPUNPCKLBW	Y7, Y1, Y4; \
+	VPUNPCKHBW	Y7, Y1, Y5; \
+	VPADDW	Y4, Y2, Y2; \
+	VPADDW	Y5, Y3, Y3; \
+	VPUNPCKLQDQ	Y3, Y2, Y4; \
+	VPUNPCKHQDQ	Y3, Y2, Y5; \
+	VPADDW	Y5, Y4, dst; \
+	VPADDW	Y6, dst, dst; \
+	VPSRLW	$2, dst, dst
+
+// func box2x8(dst, s0, s1 *byte, n int)
+//
+// box2x8 averages n blocks of 2x16 pixels of the rows s0 and s1 into 8 pixels each. It requires AVX2.
+TEXT ·box2x8(SB),NOSPLIT,$0-32
+	MOVQ	dst+0(FP), DI
+	MOVQ	s0+8(FP), SI
+	MOVQ	s1+16(FP), DX
+	MOVQ	n+24(FP), CX
+
+	VPXOR	Y7, Y7, Y7
+	VMOVDQU	round2<>(SB), Y6
+
+loop:
+	TESTQ	CX, CX
+	JZ	done
+
+	BOX2Y(0, Y8)
+	BOX2Y(32, Y9)
+	// VPACKUSWB works per 128-bit lane, VPERMQ restores the order d0..d7.
+	VPACKUSWB	Y9, Y8, Y8
+	VPERMQ	$0xD8, Y8, Y8
+	VMOVDQU	Y8, (DI)
+
+	ADDQ	$64, SI
+	ADDQ	$64, DX
+	ADDQ	$32, DI
+	DECQ	CX
+	JMP	loop
+
+done:
+	VZEROUPPER
+	RET
+
+// WIDEN adds the 4 pixels at off of the row src as words to lo (p0 p1) and hi (p2 p3).
+#define WIDEN(off, src, lo, hi) \
+	MOVOU	off(src), X2; \
+	MOVOU	X2, X3; \
+	PUNPCKLBW	X7, X2; \
+	PUNPCKHBW	X7, X3; \
+	PADDW	X2, lo; \
+	PADDW	X3, hi
+
+// BOX4 sums the 4x4 pixels at off of the rows SI, DX, R8 and R9 into the words of a single pixel, repeated in both halves of dst.
+#define BOX4(off, dst) \
+	PXOR	X0, X0; \
+	PXOR	X1, X1; \
+	WIDEN(off, SI, X0, X1); \
+	WIDEN(off, DX, X0, X1); \
+	WIDEN(off, R8, X0, X1); \
+	WIDEN(off, R9, X0, X1); \
+	PADDW	X1, X0; \
+	PSHUFD	$0x4E, X0, dst; \
+	PADDW	X0, dst
+
+// func box4x4(dst, s0, s1, s2, s3 *byte, n int)
+//
+// box4x4 averages n blocks of 4x16 pixels of the rows s0 to s3 into 4 pixels each.
+TEXT ·box4x4(SB),NOSPLIT,$0-48
+	MOVQ	dst+0(FP), DI
+	MOVQ	s0+8(FP), SI
+	MOVQ	s1+16(FP), DX
+	MOVQ	s2+24(FP), R8
+	MOVQ	s3+32(FP), R9
+	MOVQ	n+40(FP), CX
+
+	PXOR	X7, X7
+	MOVOU	round8<>(SB), X6
+
+loop:
+	TESTQ	CX, CX
+	JZ	done
+
+	BOX4(0, X8)
+	BOX4(16, X9)
+	BOX4(32, X10)
+	BOX4(48, X11)
+	PUNPCKLQDQ	X9, X8
+	PUNPCKLQDQ	X11, X10
+	PADDW	X6, X8
+	PADDW	X6, X10
+	PSRLW	$4, X8
+	PSRLW	$4, X10
+	PACKUSWB	X10, X8
+	MOVOU	X8, (DI)
+
+	ADDQ	$64, SI
+	ADDQ	$64, DX
+	ADDQ	$64, R8
+	ADDQ	$64, R9
+	ADDQ	$16, DI
+	DECQ	CX
+	JMP	loop
+
+done:
+	RET
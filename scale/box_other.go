@@ -0,0 +1,14 @@
+//go:build !amd64
+// +build !amd64
+
+package scale
+
+const (
+	useBox2x8 = false
+	useBox2x4 = false
+	useBox4x4 = false
+)
+
+func box2x4(dst, s0, s1 *byte, n int)         { panic("unreachable") }
+func box2x8(dst, s0, s1 *byte, n int)         { panic("unreachable") }
+func box4x4(dst, s0, s1, s2, s3 *byte, n int) { panic("unreachable") }
@@ -0,0 +1,181 @@
+// Package scale resizes RGBA and BGRA images, fast enough to be done for every captured frame.
+//
+// Halving and quartering use box filters with SIMD kernels, other sizes a separable filter with fixed point weights.
+// Rows are scaled in bands by the goroutines of a parallel.Pool.
+package scale
+
+import (
+	"image"
+
+	"github.com/kirides/screencapture/bgra"
+	"github.com/kirides/screencapture/parallel"
+)
+
+// Filter selects how the destination pixels are computed.
+type Filter int
+
+const (
+	// Area averages the source pixels covered by a destination pixel, weighted by the covered area.
+	Area Filter = iota
+	// Bilinear interpolates the nearest 2x2 source pixels. It is faster than Area for large factors, but aliases.
+	Bilinear
+)
+
+// minBandRows is the smallest number of destination rows of a band.
+const minBandRows = 16
+
+// Scaler scales images to the size of the destination.
+// It keeps the weights and buffers of the last sizes, so it should be reused for every frame of a stream.
+// A Scaler must not be used concurrently.
+type Scaler struct {
+	Filter Filter
+	// Pool runs the bands, parallel.Default if nil.
+	Pool *parallel.Pool
+
+	filter   Filter
+	src, dst image.Point
+	x, y     *axis
+	scratch  []*scratch
+	task     task
+}
+
+// kernel selects how a task scales its rows.
+type kernel int
+
+const (
+	copyRows kernel = iota
+	box2Rows
+	box4Rows
+	separableRows
+)
+
+// task holds the buffers of the current call, it is kept in the Scaler so running it does not allocate.
+type task struct {
+	s                    *Scaler
+	kernel               kernel
+	dst, src             []byte
+	dstStride, srcStride int
+	width                int
+}
+
+// scratch holds the fixed point rows of a band.
+type scratch struct {
+	rows [][]uint32
+	// src is the source row held by rows[i], or -1
+	src []int
+	// window holds the rows of the current destination row
+	window [][]uint32
+	acc    []uint32
+}
+
+// Scale scales src to the size of dst.
+// The byte order of the pixels does not matter, BGRA pixels can be scaled as *image.RGBA as well.
+func (s *Scaler) Scale(dst, src *image.RGBA) {
+	s.scale(dst.Pix[dst.PixOffset(dst.Rect.Min.X, dst.Rect.Min.Y):], dst.Stride, dst.Rect.Size(),
+		src.Pix[src.PixOffset(src.Rect.Min.X, src.Rect.Min.Y):], src.Stride, src.Rect.Size())
+}
+
+// ScaleBGRA is like Scale, but for BGRA images.
+func (s *Scaler) ScaleBGRA(dst, src *bgra.Image) {
+	s.scale(dst.Pix[dst.PixOffset(dst.Rect.Min.X, dst.Rect.Min.Y):], dst.Stride, dst.Rect.Size(),
+		src.Pix[src.PixOffset(src.Rect.Min.X, src.Rect.Min.Y):], src.Stride, src.Rect.Size())
+}
+
+func (s *Scaler) scale(dst []byte, dstStride int, dstSize image.Point, src []byte, srcStride int, srcSize image.Point) {
+	if dstSize.X <= 0 || dstSize.Y <= 0 || srcSize.X <= 0 || srcSize.Y <= 0 {
+		return
+	}
+	t := &s.task
+	*t = task{s: s, dst: dst, dstStride: dstStride, src: src, srcStride: srcStride, width: dstSize.X * 4}
+	switch {
+	case srcSize == dstSize:
+		t.kernel = copyRows
+	case srcSize == dstSize.Mul(2):
+		// bilinear interpolation at the centers of the destination pixels is a 2x2 box filter, too
+		t.kernel = box2Rows
+	case srcSize == dstSize.Mul(4) && s.Filter == Area:
+		t.kernel = box4Rows
+	default:
+		t.kernel = separableRows
+		s.prepare(srcSize, dstSize)
+	}
+	pool := s.Pool
+	if pool == nil {
+		pool = parallel.Default
+	}
+	for n := pool.Bands(dstSize.Y, minBandRows); len(s.scratch) < n; {
+		s.scratch = append(s.scratch, &scratch{})
+	}
+	pool.Run(dstSize.Y, minBandRows, t)
+	// the buffers are not kept beyond the call
+	*t = task{}
+}
+
+// Band scales the destination rows y0 to y1.
+func (t *task) Band(i, y0, y1 int) {
+	dst, src := t.dst, t.src
+	switch t.kernel {
+	case copyRows:
+		for y := y0; y < y1; y++ {
+			copy(dst[y*t.dstStride:][:t.width], src[y*t.srcStride:])
+		}
+	case box2Rows:
+		for y := y0; y < y1; y++ {
+			box2(dst[y*t.dstStride:][:t.width], src[2*y*t.srcStride:], src[(2*y+1)*t.srcStride:])
+		}
+	case box4Rows:
+		var rows [4][]byte
+		for y := y0; y < y1; y++ {
+			for k := range rows {
+				rows[k] = src[(4*y+k)*t.srcStride:]
+			}
+			box4(dst[y*t.dstStride:][:t.width], &rows)
+		}
+	case separableRows:
+		t.s.separable(t.s.scratch[i], dst, t.dstStride, src, t.srcStride, y0, y1)
+	}
+}
+
+// prepare computes the weights for scaling from src to dst, unless they are known already.
+func (s *Scaler) prepare(src, dst image.Point) {
+	if s.x != nil && s.filter == s.Filter && s.src == src && s.dst == dst {
+		return
+	}
+	s.filter, s.src, s.dst = s.Filter, src, dst
+	s.x = newAxis(s.Filter, src.X, dst.X)
+	s.y = newAxis(s.Filter, src.Y, dst.Y)
+	for _, sc := range s.scratch {
+		sc.rows = sc.rows[:0]
+	}
+}
+
+// separable scales the destination rows y0 to y1, scaling every source row horizontally only once.
+func (s *Scaler) separable(sc *scratch, dst []byte, dstStride int, src []byte, srcStride int, y0, y1 int) {
+	if len(sc.rows) != s.y.taps {
+		sc.rows = make([][]uint32, s.y.taps)
+		sc.src = make([]int, s.y.taps)
+		sc.window = make([][]uint32, s.y.taps)
+		sc.acc = make([]uint32, s.dst.X*4)
+		for i := range sc.rows {
+			sc.rows[i] = make([]uint32, s.dst.X*4)
+		}
+	}
+	for i := range sc.src {
+		sc.src[i] = -1
+	}
+	// the source rows of consecutive destination rows only move forward,
+	// so a ring of taps rows holds all source rows of the current destination row
+	rows := sc.window
+	for y := y0; y < y1; y++ {
+		start, taps := s.y.start[y], s.y.off[y+1]-s.y.off[y]
+		for k := 0; k < taps; k++ {
+			i := (start + k) % len(sc.rows)
+			if sc.src[i] != start+k {
+				s.x.row(sc.rows[i], src[(start+k)*srcStride:])
+				sc.src[i] = start + k
+			}
+			rows[k] = sc.rows[i]
+		}
+		s.y.column(dst[y*dstStride:][:s.dst.X*4], sc.acc, rows, y)
+	}
+}
@@ -0,0 +1,239 @@
+package scale
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/kirides/screencapture/bgra"
+	"github.com/kirides/screencapture/parallel"
+)
+
+// randomImage returns an image with padded rows and an offset origin.
+func randomImage(r *rand.Rand, w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(-3, 2, w+2, h+3)).SubImage(image.Rect(-1, 3, w-1, h+3)).(*image.RGBA)
+	r.Read(img.Pix)
+	return img
+}
+
+// reference scales src with float64 math.
+func reference(f Filter, dst, src *image.RGBA) *image.RGBA {
+	sw, sh := src.Rect.Dx(), src.Rect.Dy()
+	dw, dh := dst.Rect.Dx(), dst.Rect.Dy()
+	weights := func(i, n, m int) map[int]float64 {
+		w := map[int]float64{}
+		scale := float64(n) / float64(m)
+		if f == Bilinear {
+			c := math.Max(0, math.Min((float64(i)+0.5)*scale-0.5, float64(n-1)))
+			j := int(c)
+			w[j] += 1 - (c - float64(j))
+			if j+1 < n {
+				w[j+1] += c - float64(j)
+			}
+			return w
+		}
+		lo, hi := float64(i)*scale, float64(i+1)*scale
+		for j := int(lo); j < n && float64(j) < hi; j++ {
+			w[j] += (math.Min(hi, float64(j+1)) - math.Max(lo, float64(j))) / scale
+		}
+		return w
+	}
+	out := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		wy := weights(y, sh, dh)
+		for x := 0; x < dw; x++ {
+			wx := weights(x, sw, dw)
+			var sum [4]float64
+			for sy, ky := range wy {
+				for sx, kx := range wx {
+					p := src.Pix[src.PixOffset(src.Rect.Min.X+sx, src.Rect.Min.Y+sy):]
+					for c := range sum {
+						sum[c] += float64(p[c]) * kx * ky
+					}
+				}
+			}
+			for c := range sum {
+				out.Pix[out.PixOffset(x, y)+c] = uint8(math.Round(sum[c]))
+			}
+		}
+	}
+	return out
+}
+
+// compare checks that got matches want within tol.
+func compare(t *testing.T, name string, got, want *image.RGBA, tol int) {
+	t.Helper()
+	for y := 0; y < want.Rect.Dy(); y++ {
+		for x := 0; x < want.Rect.Dx(); x++ {
+			g := got.Pix[got.PixOffset(got.Rect.Min.X+x, got.Rect.Min.Y+y):][:4]
+			w := want.Pix[want.PixOffset(want.Rect.Min.X+x, want.Rect.Min.Y+y):][:4]
+			for c := range w {
+				if d := int(g[c]) - int(w[c]); d < -tol || d > tol {
+					t.Fatalf("%s: pixel (%d, %d) = %v, want %v", name, x, y, g, w)
+				}
+			}
+		}
+	}
+}
+
+func TestScale(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	sizes := []struct{ src, dst image.Point }{
+		{image.Pt(64, 48), image.Pt(32, 24)},
+		{image.Pt(66, 34), image.Pt(33, 17)},
+		{image.Pt(128, 64), image.Pt(32, 16)},
+		{image.Pt(132, 68), image.Pt(33, 17)},
+		{image.Pt(100, 75), image.Pt(64, 36)},
+		{image.Pt(97, 61), image.Pt(31, 29)},
+		{image.Pt(40, 30), image.Pt(40, 30)},
+		{image.Pt(13, 7), image.Pt(40, 19)},
+		{image.Pt(1, 1), image.Pt(3, 2)},
+		{image.Pt(50, 3), image.Pt(1, 1)},
+	}
+	pools := []*parallel.Pool{parallel.NewPool(1), parallel.NewPool(3)}
+	defer pools[1].Close()
+	for _, size := range sizes {
+		src := randomImage(r, size.src.X, size.src.Y)
+		for _, f := range []Filter{Area, Bilinear} {
+			for _, pool := range pools {
+				name := fmt.Sprintf("%v to %v filter %d workers %d", size.src, size.dst, f, pool.Workers())
+				dst := randomImage(r, size.dst.X, size.dst.Y)
+				s := Scaler{Filter: f, Pool: pool}
+				// twice, to use the cached weights and buffers
+				for i := 0; i < 2; i++ {
+					s.Scale(dst, src)
+					compare(t, name, dst, reference(f, dst, src), 1)
+				}
+			}
+		}
+	}
+}
+
+func TestScaleBox(t *testing.T) {
+	// box filters round exactly like the reference
+	r := rand.New(rand.NewSource(2))
+	for _, w := range []int{1, 3, 4, 7, 8, 9, 15, 16, 17, 33, 100} {
+		for _, factor := range []int{2, 4} {
+			src := randomImage(r, w*factor, 2*factor)
+			dst := image.NewRGBA(image.Rect(0, 0, w, 2))
+			var s Scaler
+			s.Scale(dst, src)
+			compare(t, fmt.Sprintf("width %d factor %d", w, factor), dst, reference(Area, dst, src), 0)
+		}
+	}
+}
+
+func TestBoxKernels(t *testing.T) {
+	// the asm kernels match the pure Go code
+	r := rand.New(rand.NewSource(3))
+	for n := 0; n < 70; n++ {
+		var rows [4][]byte
+		for i := range rows {
+			rows[i] = make([]byte, n*16)
+			r.Read(rows[i])
+		}
+		want2, want4 := make([]byte, n*4), make([]byte, n*4)
+		for x := 0; x < n; x++ {
+			for c := 0; c < 4; c++ {
+				sum2, sum4 := 2, 8
+				for i := range rows {
+					for k := 0; k < 4; k++ {
+						sum4 += int(rows[i][x*16+k*4+c])
+						if i < 2 && k < 2 {
+							sum2 += int(rows[i][x*8+k*4+c])
+						}
+					}
+				}
+				want2[x*4+c], want4[x*4+c] = byte(sum2>>2), byte(sum4>>4)
+			}
+		}
+		got := make([]byte, n*4)
+		box2(got, rows[0], rows[1])
+		if string(got) != string(want2) {
+			t.Fatalf("box2 of %d pixels = %v, want %v", n, got, want2)
+		}
+		box4(got, &rows)
+		if string(got) != string(want4) {
+			t.Fatalf("box4 of %d pixels = %v, want %v", n, got, want4)
+		}
+	}
+}
+
+func TestScaleBGRA(t *testing.T) {
+	r := rand.New(rand.NewSource(4))
+	src := randomImage(r, 90, 50)
+	want := image.NewRGBA(image.Rect(0, 0, 40, 30))
+	s := Scaler{Filter: Bilinear}
+	s.Scale(want, src)
+
+	b := &bgra.Image{Pix: src.Pix, Stride: src.Stride, Rect: src.Rect}
+	got := bgra.New(want.Rect)
+	s.ScaleBGRA(got, b)
+	if string(got.Pix) != string(want.Pix) {
+		t.Fatal("ScaleBGRA differs from Scale")
+	}
+}
+
+func TestScaleAllocs(t *testing.T) {
+	pool := parallel.NewPool(4)
+	defer pool.Close()
+	src := image.NewRGBA(image.Rect(0, 0, 300, 200))
+	for _, size := range []image.Point{{150, 100}, {75, 50}, {120, 90}} {
+		dst := image.NewRGBA(image.Rectangle{Max: size})
+		s := Scaler{Pool: pool}
+		s.Scale(dst, src)
+		if n := testing.AllocsPerRun(10, func() { s.Scale(dst, src) }); n != 0 {
+			t.Errorf("scaling to %v: %v allocations, want 0", size, n)
+		}
+	}
+}
+
+func TestAxisWeights(t *testing.T) {
+	for _, f := range []Filter{Area, Bilinear} {
+		for _, size := range [][2]int{{3840, 1280}, {1920, 1366}, {7, 3}, {3, 7}, {1, 5}} {
+			a := newAxis(f, size[0], size[1])
+			for i := range a.start {
+				var sum uint32
+				for _, w := range a.weights[a.off[i]:a.off[i+1]] {
+					sum += w
+				}
+				if sum != 1<<weightBits {
+					t.Fatalf("filter %d %v: weights of %d sum to %d", f, size, i, sum)
+				}
+				if end := a.start[i] + a.off[i+1] - a.off[i]; a.start[i] < 0 || end > size[0] {
+					t.Fatalf("filter %d %v: source of %d is %d to %d", f, size, i, a.start[i], end)
+				}
+			}
+		}
+	}
+}
+
+func benchmarkScale(b *testing.B, f Filter, src, dst image.Point) {
+	img := image.NewRGBA(image.Rectangle{Max: src})
+	rand.New(rand.NewSource(1)).Read(img.Pix)
+	out := image.NewRGBA(image.Rectangle{Max: dst})
+	s := Scaler{Filter: f}
+	b.SetBytes(int64(len(img.Pix)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.Scale(out, img)
+	}
+}
+
+func BenchmarkBox2_4K(b *testing.B) {
+	benchmarkScale(b, Area, image.Pt(3840, 2160), image.Pt(1920, 1080))
+}
+func BenchmarkBox4_4K(b *testing.B) {
+	benchmarkScale(b, Area, image.Pt(3840, 2160), image.Pt(960, 540))
+}
+func BenchmarkArea_4K(b *testing.B) {
+	benchmarkScale(b, Area, image.Pt(3840, 2160), image.Pt(1280, 720))
+}
+func BenchmarkBilinear_4K(b *testing.B) {
+	benchmarkScale(b, Bilinear, image.Pt(3840, 2160), image.Pt(1280, 720))
+}
+func BenchmarkArea_1440p(b *testing.B) {
+	benchmarkScale(b, Area, image.Pt(2560, 1440), image.Pt(1920, 1080))
+}
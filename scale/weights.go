@@ -0,0 +1,107 @@
+package scale
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// Weights are fixed point numbers with weightBits fractional bits,
+// so a pixel weighted along both axes fits into an uint32.
+const weightBits = 12
+
+// axis holds the weights of the source pixels of every destination pixel along one axis.
+type axis struct {
+	// start is the first source index of destination index i, its weights are weights[off[i]:off[i+1]]
+	start   []int
+	off     []int
+	weights []uint32
+	// taps is the largest number of weights of a destination index
+	taps int
+}
+
+func newAxis(f Filter, src, dst int) *axis {
+	a := &axis{start: make([]int, dst), off: make([]int, dst+1)}
+	scale := float64(src) / float64(dst)
+	var w []float64
+	for i := 0; i < dst; i++ {
+		w = w[:0]
+		if f == Bilinear {
+			// the centers of destination pixels are mapped to the source
+			c := math.Max(0, math.Min((float64(i)+0.5)*scale-0.5, float64(src-1)))
+			j := int(c)
+			a.start[i] = j
+			w = append(w, 1-(c-float64(j)))
+			if j+1 < src {
+				w = append(w, c-float64(j))
+			}
+		} else {
+			// the weights are the parts of the source pixels covered by the destination pixel
+			lo, hi := float64(i)*scale, float64(i+1)*scale
+			j := int(lo)
+			a.start[i] = j
+			// slivers left by rounding errors are skipped
+			for ; j < src && float64(j) < hi-1e-9; j++ {
+				w = append(w, (math.Min(hi, float64(j+1))-math.Max(lo, float64(j)))/scale)
+			}
+		}
+		a.weights = appendFixed(a.weights, w)
+		a.off[i+1] = len(a.weights)
+		if len(w) > a.taps {
+			a.taps = len(w)
+		}
+	}
+	return a
+}
+
+// appendFixed appends w as fixed point weights whose sum is exactly 1.
+func appendFixed(dst []uint32, w []float64) []uint32 {
+	first := len(dst)
+	largest, sum := first, 0
+	for i, v := range w {
+		q := uint32(math.Round(v * (1 << weightBits)))
+		dst = append(dst, q)
+		if q > dst[largest] {
+			largest = first + i
+		}
+		sum += int(q)
+	}
+	// the rounding error goes to the largest weight
+	dst[largest] = uint32(int(dst[largest]) + 1<<weightBits - sum)
+	return dst
+}
+
+// row scales a row of src pixels along a, writing fixed point pixels to dst.
+func (a *axis) row(dst []uint32, src []byte) {
+	for i, start := range a.start {
+		w := a.weights[a.off[i]:a.off[i+1]]
+		s := src[start*4 : (start+len(w))*4]
+		// two components per uint64, which cannot overflow 32 bits each
+		var rb, ga uint64
+		for k, wk := range w {
+			p := binary.LittleEndian.Uint32(s[k*4:])
+			rb += (uint64(p&0xff) | uint64(p&0xff0000)<<16) * uint64(wk)
+			ga += (uint64(p>>8&0xff) | uint64(p>>24)<<32) * uint64(wk)
+		}
+		d := dst[i*4 : i*4+4]
+		d[0], d[1], d[2], d[3] = uint32(rb), uint32(ga), uint32(rb>>32), uint32(ga>>32)
+	}
+}
+
+// column combines the fixed point rows of the destination row i into dst, using acc as a buffer of len(dst).
+func (a *axis) column(dst []byte, acc []uint32, rows [][]uint32, i int) {
+	w := a.weights[a.off[i]:a.off[i+1]]
+	acc = acc[:len(dst)]
+	for x := range acc {
+		acc[x] = 1 << (2*weightBits - 1)
+	}
+	for k, row := range rows[:len(w)] {
+		wk := w[k]
+		row = row[:len(acc)]
+		for x, v := range row {
+			acc[x] += v * wk
+		}
+	}
+	for x, v := range acc {
+		dst[x] = byte(v >> (2 * weightBits))
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"syscall"
 	"unsafe"
 
+	"github.com/kirides/screencapture/bgra"
+	"github.com/kirides/screencapture/parallel"
 	"github.com/kirides/screencapture/swizzle"
 
 	"github.com/lxn/win"
@@ -14,11 +16,27 @@ import (
 )
 
 func CaptureImg(img *image.RGBA, x, y, width, height int) error {
-	return captureImg(img, x, y, width, height)
+	return captureImg(x, y, width, height, func(bgrx []byte, stride int, r image.Rectangle) {
+		parallel.Default.Copy(swizzle.CopyBGRX, img.Pix, img.Stride, bgrx, stride, r)
+	})
+}
+
+// CaptureBGRA is like CaptureImg, but keeps the BGRA byte order of the bitmap.
+func CaptureBGRA(img *bgra.Image, x, y, width, height int) error {
+	return captureImg(x, y, width, height, func(bgrx []byte, stride int, r image.Rectangle) {
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			row := img.Pix[y*img.Stride:][:r.Dx()*4]
+			copy(row, bgrx[y*stride:])
+			for i := 3; i < len(row); i += 4 {
+				row[i] = 0xFF
+			}
+		}
+	})
 }
 
 // code is mostly from github.com/kbinani/screenshot
-func captureImg(img *image.RGBA, x, y, width, height int) error {
+// copyTo receives the BGRX pixels of the captured bitmap.
+func captureImg(x, y, width, height int, copyTo func(bgrx []byte, stride int, r image.Rectangle)) error {
 	hWnd := syscall.Handle(thiswin.GetDesktopWindow())
 	hdc := win.GetDC(win.HWND(hWnd))
 	if hdc == 0 {
@@ -70,19 +88,7 @@ func captureImg(img *image.RGBA, x, y, width, height int) error {
 	}
 
 	// using memory interpretation
-	bgra := ((*[1 << 30]byte)(unsafe.Pointer(hMem)))[:bitmapDataSize:bitmapDataSize]
-	swizzle.BGRA(bgra)
-	copy(img.Pix[:bitmapDataSize], bgra)
-
-	// manual swizzle B <-> R, A = 255
-
-	// for i := int32(0); i < bitmapDataSize; i += 4 {
-	// 	v0 := *(*uint8)(unsafe.Pointer(hMem + uintptr(i)))
-	// 	v1 := *(*uint8)(unsafe.Pointer(hMem + uintptr(i) + 1))
-	// 	v2 := *(*uint8)(unsafe.Pointer(hMem + uintptr(i) + 2))
-
-	// 	// BGRA => RGBA, and set A to 255
-	// 	img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = v2, v1, v0, 255
-	// }
+	bgrx := unsafe.Slice((*byte)(unsafe.Pointer(hMem)), bitmapDataSize)
+	copyTo(bgrx, int(bitmapDataSize)/int(bm.BmHeight), image.Rect(0, 0, int(bm.BmWidth), int(bm.BmHeight)))
 	return nil
 }
@@ -0,0 +1,68 @@
+package swizzle
+
+import "image"
+
+// CopyBGRA copies the pixels of r from src to dst, converting between RGBA and BGRA byte orders.
+//
+// Rows start every srcStride bytes in src and every dstStride bytes in dst, so padded rows,
+// e.g. a mapped surface with a pitch larger than its width, are handled.
+// r is given in pixels and addresses the same position in both buffers, pixels outside of it are not touched.
+// It panics if r does not fit into either buffer.
+func CopyBGRA(dst []byte, dstStride int, src []byte, srcStride int, r image.Rectangle) {
+	if !checkCopy(dst, dstStride, src, srcStride, r) {
+		return
+	}
+	n := r.Dx() * 4
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		row := dst[y*dstStride+r.Min.X*4:][:n]
+		copy(row, src[y*srcStride+r.Min.X*4:][:n])
+		BGRA(row)
+	}
+}
+
+// CopyBGRX is like CopyBGRA, but sets the alpha of every pixel to 255 while copying,
+// for sources without an alpha channel like BGRX framebuffers or GDI bitmaps.
+// Every pixel is read and written only once.
+func CopyBGRX(dst []byte, dstStride int, src []byte, srcStride int, r image.Rectangle) {
+	if !checkCopy(dst, dstStride, src, srcStride, r) {
+		return
+	}
+	n := r.Dx() * 4
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		bgrx(dst[y*dstStride+r.Min.X*4:][:n], src[y*srcStride+r.Min.X*4:][:n])
+	}
+}
+
+// checkCopy panics if r does not fit into dst or src and reports whether there is anything to copy.
+func checkCopy(dst []byte, dstStride int, src []byte, srcStride int, r image.Rectangle) bool {
+	if r.Empty() {
+		return false
+	}
+	end := func(stride int) int { return (r.Max.Y-1)*stride + r.Max.X*4 }
+	if r.Min.X < 0 || r.Min.Y < 0 || end(srcStride) > len(src) || end(dstStride) > len(dst) {
+		panic("rectangle is outside of the buffers")
+	}
+	return true
+}
+
+// bgrx converts the BGRX pixels of src into opaque RGBA pixels in dst, which must not be longer than src.
+func bgrx(dst, src []byte) {
+	// Use asm code for 64-, 32- or 16-byte chunks, if supported.
+	if useBGRA64 {
+		n := len(dst) &^ (64 - 1)
+		bgrx64(dst[:n], src[:n])
+		dst, src = dst[n:], src[n:]
+	} else if useBGRA32 {
+		n := len(dst) &^ (32 - 1)
+		bgrx32(dst[:n], src[:n])
+		dst, src = dst[n:], src[n:]
+	}
+	if useBGRA16 {
+		n := len(dst) &^ (16 - 1)
+		bgrx16(dst[:n], src[:n])
+		dst, src = dst[n:], src[n:]
+	}
+	for i := 0; i+3 < len(dst); i += 4 {
+		dst[i+0], dst[i+1], dst[i+2], dst[i+3] = src[i+2], src[i+1], src[i+0], 0xFF
+	}
+}
@@ -0,0 +1,122 @@
+package swizzle
+
+import (
+	"bytes"
+	"image"
+	"math/rand"
+	"testing"
+)
+
+func TestCopyBGRA(t *testing.T) {
+	testCopy(t, CopyBGRA, func(d, s []byte) { d[0], d[1], d[2], d[3] = s[2], s[1], s[0], s[3] })
+}
+
+func TestCopyBGRX(t *testing.T) {
+	testCopy(t, CopyBGRX, func(d, s []byte) { d[0], d[1], d[2], d[3] = s[2], s[1], s[0], 0xFF })
+}
+
+// testCopy compares copyFn for various strides and rectangles to converting every pixel with pixel.
+func testCopy(t *testing.T, copyFn func(dst []byte, dstStride int, src []byte, srcStride int, r image.Rectangle), pixel func(d, s []byte)) {
+	r := rand.New(rand.NewSource(1))
+	const w, h = 37, 11
+	testCases := []struct {
+		srcStride, dstStride int
+		rect                 image.Rectangle
+	}{
+		{w * 4, w * 4, image.Rect(0, 0, w, h)},
+		{w*4 + 3, w * 4, image.Rect(0, 0, w, h)},
+		{w * 4, w*4 + 5, image.Rect(0, 0, w, h)},
+		{w*4 + 61, w*4 + 7, image.Rect(3, 2, 30, 9)},
+		{w*4 + 1, w*4 + 1, image.Rect(w-1, h-1, w, h)},
+		{w * 4, w * 4, image.Rect(5, 5, 5, 9)},
+		// wide enough for all asm kernels, with tails
+		{w*4 + 9, w*4 + 13, image.Rect(1, 0, w, h)},
+	}
+	for _, tc := range testCases {
+		src := make([]byte, (h-1)*tc.srcStride+w*4)
+		dst := make([]byte, (h-1)*tc.dstStride+w*4)
+		r.Read(src)
+		r.Read(dst)
+		want := append([]byte(nil), dst...)
+		for y := tc.rect.Min.Y; y < tc.rect.Max.Y; y++ {
+			for x := tc.rect.Min.X; x < tc.rect.Max.X; x++ {
+				pixel(want[y*tc.dstStride+x*4:], src[y*tc.srcStride+x*4:])
+			}
+		}
+
+		copyFn(dst, tc.dstStride, src, tc.srcStride, tc.rect)
+		if !bytes.Equal(dst, want) {
+			t.Errorf("srcStride=%d dstStride=%d rect=%v: got %v, want %v", tc.srcStride, tc.dstStride, tc.rect, dst, want)
+		}
+	}
+}
+
+func TestCopyBGRAOutOfBounds(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("CopyBGRA did not panic")
+		}
+	}()
+	buf := make([]byte, 4*4*4)
+	CopyBGRA(buf, 16, buf[:len(buf)-1], 16, image.Rect(0, 0, 4, 4))
+}
+
+func TestBGRXKernels(t *testing.T) {
+	kernels := []struct {
+		name      string
+		size      int
+		supported bool
+		f         func(dst, src []byte)
+	}{
+		{"bgrx16", 16, useBGRA16, bgrx16},
+		{"bgrx32", 32, useBGRA32, bgrx32},
+		{"bgrx64", 64, useBGRA64, bgrx64},
+	}
+	r := rand.New(rand.NewSource(2))
+	src := make([]byte, 4096+64)
+	r.Read(src)
+	for _, k := range kernels {
+		if !k.supported {
+			t.Logf("%s is not supported on this CPU", k.name)
+			continue
+		}
+		for i := 0; i < 1000; i++ {
+			so, do := r.Intn(64), r.Intn(64)
+			n := r.Intn(4096) &^ (k.size - 1)
+			dst := make([]byte, n+128)
+			want := append([]byte(nil), dst...)
+			k.f(dst[do:do+n], src[so:so+n])
+			for j := 0; j < n; j += 4 {
+				want[do+j], want[do+j+1], want[do+j+2], want[do+j+3] = src[so+j+2], src[so+j+1], src[so+j], 0xFF
+			}
+			if !bytes.Equal(dst, want) {
+				t.Fatalf("%s: iter %d: converting %d bytes from %d to %d differs", k.name, i, n, so, do)
+			}
+		}
+	}
+}
+
+func benchmarkCopy(b *testing.B, copyFn func(dst []byte, dstStride int, src []byte, srcStride int, r image.Rectangle)) {
+	const w, h = 1920, 1080
+	const pitch = w*4 + 256
+	src := make([]byte, pitch*h)
+	dst := make([]byte, 4*w*h)
+	b.SetBytes(4 * w * h)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		copyFn(dst, 4*w, src, pitch, image.Rect(0, 0, w, h))
+	}
+}
+
+func BenchmarkCopyBGRA(b *testing.B) { benchmarkCopy(b, CopyBGRA) }
+func BenchmarkCopyBGRX(b *testing.B) { benchmarkCopy(b, CopyBGRX) }
+
+// BenchmarkCopyBGRAFillAlpha is what CopyBGRX replaces.
+func BenchmarkCopyBGRAFillAlpha(b *testing.B) {
+	benchmarkCopy(b, func(dst []byte, dstStride int, src []byte, srcStride int, r image.Rectangle) {
+		CopyBGRA(dst, dstStride, src, srcStride, r)
+		for i := 3; i < len(dst); i += 4 {
+			dst[i] = 0xFF
+		}
+	})
+}
@@ -4,14 +4,28 @@
 
 package swizzle
 
+import "golang.org/x/sys/cpu"
+
 // haveSSSE3 returns whether the CPU supports SSSE3 instructions (i.e. PSHUFB).
 //
 // Note that this is SSSE3, not SSE3.
 func haveSSSE3() bool
 
-var useBGRA16 = haveSSSE3()
+var (
+	useBGRA64 = cpu.X86.HasAVX512BW
+	useBGRA32 = cpu.X86.HasAVX2
+	useBGRA16 = haveSSSE3()
+)
 
 const useBGRA4 = true
 
+func bgra64(p []byte)
+func bgra32(p []byte)
 func bgra16(p []byte)
 func bgra4(p []byte)
+
+func bgrx64(dst, src []byte)
+func bgrx32(dst, src []byte)
+func bgrx16(dst, src []byte)
+
+func yuv420x8(y0, y1, u, v, s0, s1 *byte, n int, c *yuvConsts)
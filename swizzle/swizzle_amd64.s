@@ -4,6 +4,28 @@
 
 #include "textflag.h"
 
+// bgraMask is the PSHUFB control mask of bgra16, repeated for every 16-byte lane of a ZMM register.
+DATA bgraMask<>+0x00(SB)/8, $0x0704050603000102
+DATA bgraMask<>+0x08(SB)/8, $0x0f0c0d0e0b08090a
+DATA bgraMask<>+0x10(SB)/8, $0x0704050603000102
+DATA bgraMask<>+0x18(SB)/8, $0x0f0c0d0e0b08090a
+DATA bgraMask<>+0x20(SB)/8, $0x0704050603000102
+DATA bgraMask<>+0x28(SB)/8, $0x0f0c0d0e0b08090a
+DATA bgraMask<>+0x30(SB)/8, $0x0704050603000102
+DATA bgraMask<>+0x38(SB)/8, $0x0f0c0d0e0b08090a
+GLOBL bgraMask<>(SB), (NOPTR+RODATA), $64
+
+// alphaMask sets the alpha byte of every pixel.
+DATA alphaMask<>+0x00(SB)/8, $0xff000000ff000000
+DATA alphaMask<>+0x08(SB)/8, $0xff000000ff000000
+DATA alphaMask<>+0x10(SB)/8, $0xff000000ff000000
+DATA alphaMask<>+0x18(SB)/8, $0xff000000ff000000
+DATA alphaMask<>+0x20(SB)/8, $0xff000000ff000000
+DATA alphaMask<>+0x28(SB)/8, $0xff000000ff000000
+DATA alphaMask<>+0x30(SB)/8, $0xff000000ff000000
+DATA alphaMask<>+0x38(SB)/8, $0xff000000ff000000
+GLOBL alphaMask<>(SB), (NOPTR+RODATA), $64
+
 // func haveSSSE3() bool
 TEXT ·haveSSSE3(SB),NOSPLIT,$0
 	MOVQ	$1, AX
@@ -50,6 +72,107 @@ loop:
 done:
 	RET
 
+// func bgra32(p []byte)
+TEXT ·bgra32(SB),NOSPLIT,$0-24
+	MOVQ	p_base+0(FP), SI
+	MOVQ	p_len+8(FP), DI
+
+	// Sanity check that len is a multiple of 32.
+	MOVQ	DI, AX
+	ANDQ	$31, AX
+	JNZ	done
+
+	// VPSHUFB shuffles within each 16-byte lane, so the mask is the one of bgra16, twice.
+	VMOVDQU	bgraMask<>(SB), Y0
+
+	ADDQ	SI, DI
+	// Swizzle 128 bytes per iteration, as long as there are that many left.
+	MOVQ	DI, BX
+	SUBQ	SI, BX
+	ANDQ	$~127, BX
+	ADDQ	SI, BX
+loop128:
+	CMPQ	SI, BX
+	JEQ	loop
+	VMOVDQU	(SI), Y1
+	VMOVDQU	32(SI), Y2
+	VMOVDQU	64(SI), Y3
+	VMOVDQU	96(SI), Y4
+	VPSHUFB	Y0, Y1, Y1
+	VPSHUFB	Y0, Y2, Y2
+	VPSHUFB	Y0, Y3, Y3
+	VPSHUFB	Y0, Y4, Y4
+	VMOVDQU	Y1, (SI)
+	VMOVDQU	Y2, 32(SI)
+	VMOVDQU	Y3, 64(SI)
+	VMOVDQU	Y4, 96(SI)
+	ADDQ	$128, SI
+	JMP	loop128
+loop:
+	CMPQ	SI, DI
+	JEQ	vzero
+
+	VMOVDQU	(SI), Y1
+	VPSHUFB	Y0, Y1, Y1
+	VMOVDQU	Y1, (SI)
+
+	ADDQ	$32, SI
+	JMP	loop
+vzero:
+	VZEROUPPER
+done:
+	RET
+
+// func bgra64(p []byte)
+TEXT ·bgra64(SB),NOSPLIT,$0-24
+	MOVQ	p_base+0(FP), SI
+	MOVQ	p_len+8(FP), DI
+
+	// Sanity check that len is a multiple of 64.
+	MOVQ	DI, AX
+	ANDQ	$63, AX
+	JNZ	done
+
+	VMOVDQU64	bgraMask<>(SB), Z0
+
+	ADDQ	SI, DI
+	// Swizzle 256 bytes per iteration, as long as there are that many left.
+	MOVQ	DI, BX
+	SUBQ	SI, BX
+	ANDQ	$~255, BX
+	ADDQ	SI, BX
+loop256:
+	CMPQ	SI, BX
+	JEQ	loop
+	VMOVDQU64	(SI), Z1
+	VMOVDQU64	64(SI), Z2
+	VMOVDQU64	128(SI), Z3
+	VMOVDQU64	192(SI), Z4
+	VPSHUFB	Z0, Z1, Z1
+	VPSHUFB	Z0, Z2, Z2
+	VPSHUFB	Z0, Z3, Z3
+	VPSHUFB	Z0, Z4, Z4
+	VMOVDQU64	Z1, (SI)
+	VMOVDQU64	Z2, 64(SI)
+	VMOVDQU64	Z3, 128(SI)
+	VMOVDQU64	Z4, 192(SI)
+	ADDQ	$256, SI
+	JMP	loop256
+loop:
+	CMPQ	SI, DI
+	JEQ	vzero
+
+	VMOVDQU64	(SI), Z1
+	VPSHUFB	Z0, Z1, Z1
+	VMOVDQU64	Z1, (SI)
+
+	ADDQ	$64, SI
+	JMP	loop
+vzero:
+	VZEROUPPER
+done:
+	RET
+
 // func bgra4(p []byte)
 TEXT ·bgra4(SB),NOSPLIT,$0-24
 	MOVQ	p+0(FP), SI
@@ -74,3 +197,249 @@ loop:
 	JMP	loop
 done:
 	RET
+
+// func bgrx16(dst, src []byte)
+TEXT ·bgrx16(SB),NOSPLIT,$0-48
+	MOVQ	dst_base+0(FP), DI
+	MOVQ	dst_len+8(FP), CX
+	MOVQ	src_base+24(FP), SI
+
+	// Sanity check that len is a multiple of 16.
+	MOVQ	CX, AX
+	ANDQ	$15, AX
+	JNZ	done
+
+	MOVOU	bgraMask<>(SB), X0
+	MOVOU	alphaMask<>(SB), X2
+
+	ADDQ	DI, CX
+loop:
+	CMPQ	DI, CX
+	JEQ	done
+
+	MOVOU	(SI), X1
+	PSHUFB	X0, X1
+	POR	X2, X1
+	MOVOU	X1, (DI)
+
+	ADDQ	$16, SI
+	ADDQ	$16, DI
+	JMP	loop
+done:
+	RET
+
+// func bgrx32(dst, src []byte)
+TEXT ·bgrx32(SB),NOSPLIT,$0-48
+	MOVQ	dst_base+0(FP), DI
+	MOVQ	dst_len+8(FP), CX
+	MOVQ	src_base+24(FP), SI
+
+	// Sanity check that len is a multiple of 32.
+	MOVQ	CX, AX
+	ANDQ	$31, AX
+	JNZ	done
+
+	VMOVDQU	bgraMask<>(SB), Y0
+	VMOVDQU	alphaMask<>(SB), Y5
+
+	ADDQ	DI, CX
+	// Convert 128 bytes per iteration, as long as there are that many left.
+	MOVQ	CX, BX
+	SUBQ	DI, BX
+	ANDQ	$~127, BX
+	ADDQ	DI, BX
+loop128:
+	CMPQ	DI, BX
+	JEQ	loop
+	VMOVDQU	(SI), Y1
+	VMOVDQU	32(SI), Y2
+	VMOVDQU	64(SI), Y3
+	VMOVDQU	96(SI), Y4
+	VPSHUFB	Y0, Y1, Y1
+	VPSHUFB	Y0, Y2, Y2
+	VPSHUFB	Y0, Y3, Y3
+	VPSHUFB	Y0, Y4, Y4
+	VPOR	Y5, Y1, Y1
+	VPOR	Y5, Y2, Y2
+	VPOR	Y5, Y3, Y3
+	VPOR	Y5, Y4, Y4
+	VMOVDQU	Y1, (DI)
+	VMOVDQU	Y2, 32(DI)
+	VMOVDQU	Y3, 64(DI)
+	VMOVDQU	Y4, 96(DI)
+	ADDQ	$128, SI
+	ADDQ	$128, DI
+	JMP	loop128
+loop:
+	CMPQ	DI, CX
+	JEQ	vzero
+
+	VMOVDQU	(SI), Y1
+	VPSHUFB	Y0, Y1, Y1
+	VPOR	Y5, Y1, Y1
+	VMOVDQU	Y1, (DI)
+
+	ADDQ	$32, SI
+	ADDQ	$32, DI
+	JMP	loop
+vzero:
+	VZEROUPPER
+done:
+	RET
+
+// func bgrx64(dst, src []byte)
+TEXT ·bgrx64(SB),NOSPLIT,$0-48
+	MOVQ	dst_base+0(FP), DI
+	MOVQ	dst_len+8(FP), CX
+	MOVQ	src_base+24(FP), SI
+
+	// Sanity check that len is a multiple of 64.
+	MOVQ	CX, AX
+	ANDQ	$63, AX
+	JNZ	done
+
+	VMOVDQU64	bgraMask<>(SB), Z0
+	VMOVDQU64	alphaMask<>(SB), Z5
+
+	ADDQ	DI, CX
+	// Convert 256 bytes per iteration, as long as there are that many left.
+	MOVQ	CX, BX
+	SUBQ	DI, BX
+	ANDQ	$~255, BX
+	ADDQ	DI, BX
+loop256:
+	CMPQ	DI, BX
+	JEQ	loop
+	VMOVDQU64	(SI), Z1
+	VMOVDQU64	64(SI), Z2
+	VMOVDQU64	128(SI), Z3
+	VMOVDQU64	192(SI), Z4
+	VPSHUFB	Z0, Z1, Z1
+	VPSHUFB	Z0, Z2, Z2
+	VPSHUFB	Z0, Z3, Z3
+	VPSHUFB	Z0, Z4, Z4
+	VPORD	Z5, Z1, Z1
+	VPORD	Z5, Z2, Z2
+	VPORD	Z5, Z3, Z3
+	VPORD	Z5, Z4, Z4
+	VMOVDQU64	Z1, (DI)
+	VMOVDQU64	Z2, 64(DI)
+	VMOVDQU64	Z3, 128(DI)
+	VMOVDQU64	Z4, 192(DI)
+	ADDQ	$256, SI
+	ADDQ	$256, DI
+	JMP	loop256
+loop:
+	CMPQ	DI, CX
+	JEQ	vzero
+
+	VMOVDQU64	(SI), Z1
+	VPSHUFB	Z0, Z1, Z1
+	VPORD	Z5, Z1, Z1
+	VMOVDQU64	Z1, (DI)
+
+	ADDQ	$64, SI
+	ADDQ	$64, DI
+	JMP	loop
+vzero:
+	VZEROUPPER
+done:
+	RET
+
+// func yuv420x8(y0, y1, u, v, s0, s1 *byte, n int, c *yuvConsts)
+//
+// yuv420x8 converts n blocks of 2x8 pixels from the rows s0 and s1 into 8 Y values of y0 and y1 each,
+// and 4 Cb and Cr values, written to u and v, or interleaved to u. It requires AVX2.
+TEXT ·yuv420x8(SB),NOSPLIT,$0-64
+	MOVQ	y0+0(FP), DI
+	MOVQ	y1+8(FP), R8
+	MOVQ	u+16(FP), R9
+	MOVQ	v+24(FP), R10
+	MOVQ	s0+32(FP), SI
+	MOVQ	s1+40(FP), R11
+	MOVQ	n+48(FP), CX
+	MOVQ	c+56(FP), AX
+
+	VMOVDQU	0(AX), Y8    // Y coefficients
+	VMOVDQU	32(AX), Y9   // Cb coefficients
+	VMOVDQU	64(AX), Y10  // Cr coefficients
+	VMOVDQU	96(AX), Y11  // Y offset and rounding
+	VMOVDQU	128(AX), Y12 // Cb and Cr offset and rounding
+	VMOVDQU	160(AX), Y13 // Cb and Cr order
+	MOVL	192(AX), DX  // interleaved
+
+loop:
+	TESTQ	CX, CX
+	JZ	done
+
+	// Widen the pixels to words, 4 pixels per register.
+	VPMOVZXBW	(SI), Y0
+	VPMOVZXBW	16(SI), Y1
+	VPMOVZXBW	(R11), Y2
+	VPMOVZXBW	16(R11), Y3
+
+	// Y of row 0. VPHADDD works per 128-bit lane,
+	// so the dot products are ordered p0 p1 p4 p5 p2 p3 p6 p7 and VPERMQ restores the order.
+	VPMADDWD	Y8, Y0, Y4
+	VPMADDWD	Y8, Y1, Y5
+	VPHADDD	Y5, Y4, Y4
+	VPADDD	Y11, Y4, Y4
+	VPSRAD	$15, Y4, Y4
+	VPERMQ	$0xD8, Y4, Y4
+	VEXTRACTI128	$1, Y4, X5
+	VPACKSSDW	X5, X4, X4
+	VPACKUSWB	X4, X4, X4
+	MOVQ	X4, (DI)
+
+	// Y of row 1
+	VPMADDWD	Y8, Y2, Y4
+	VPMADDWD	Y8, Y3, Y5
+	VPHADDD	Y5, Y4, Y4
+	VPADDD	Y11, Y4, Y4
+	VPSRAD	$15, Y4, Y4
+	VPERMQ	$0xD8, Y4, Y4
+	VEXTRACTI128	$1, Y4, X5
+	VPACKSSDW	X5, X4, X4
+	VPACKUSWB	X4, X4, X4
+	MOVQ	X4, (R8)
+
+	// Cb and Cr of the sums of both rows, ordered p0 p1 p4 p5 p2 p3 p6 p7.
+	VPADDW	Y2, Y0, Y0
+	VPADDW	Y3, Y1, Y1
+	VPMADDWD	Y9, Y0, Y4
+	VPMADDWD	Y9, Y1, Y5
+	VPHADDD	Y5, Y4, Y4
+	VPMADDWD	Y10, Y0, Y6
+	VPMADDWD	Y10, Y1, Y7
+	VPHADDD	Y7, Y6, Y6
+	// Sum horizontal pairs of the 2x2 blocks b0-b3: Cb b0 b2, Cr b0 b2, Cb b1 b3, Cr b1 b3.
+	VPHADDD	Y6, Y4, Y4
+	VPADDD	Y12, Y4, Y4
+	VPSRAD	$17, Y4, Y4
+	VPERMD	Y4, Y13, Y4
+	VEXTRACTI128	$1, Y4, X5
+	VPACKSSDW	X5, X4, X4
+	VPACKUSWB	X4, X4, X4
+
+	TESTL	DX, DX
+	JNZ	interleaved
+	MOVQ	X4, BX
+	MOVL	BX, (R9)
+	SHRQ	$32, BX
+	MOVL	BX, (R10)
+	ADDQ	$4, R9
+	ADDQ	$4, R10
+	JMP	next
+interleaved:
+	MOVQ	X4, (R9)
+	ADDQ	$8, R9
+next:
+	ADDQ	$32, SI
+	ADDQ	$32, R11
+	ADDQ	$8, DI
+	ADDQ	$8, R8
+	DECQ	CX
+	JMP	loop
+done:
+	VZEROUPPER
+	RET
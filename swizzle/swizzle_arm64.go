@@ -0,0 +1,20 @@
+package swizzle
+
+// NEON is part of every arm64 CPU, so bgra16 is always used.
+const (
+	useBGRA64 = false
+	useBGRA32 = false
+	useBGRA16 = true
+	useBGRA4  = false
+)
+
+func bgra64(p []byte) { panic("unreachable") }
+func bgra32(p []byte) { panic("unreachable") }
+func bgra16(p []byte)
+func bgra4(p []byte) { panic("unreachable") }
+
+func bgrx64(dst, src []byte) { panic("unreachable") }
+func bgrx32(dst, src []byte) { panic("unreachable") }
+func bgrx16(dst, src []byte)
+
+func yuv420x8(y0, y1, u, v, s0, s1 *byte, n int, c *yuvConsts) { panic("unreachable") }
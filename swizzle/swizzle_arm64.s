@@ -0,0 +1,93 @@
+#include "textflag.h"
+
+// bgraMask holds the VTBL indices swapping the bytes 0 and 2 of every pixel,
+// REV32 alone would reverse all four bytes.
+DATA bgraMask<>+0x00(SB)/8, $0x0704050603000102
+DATA bgraMask<>+0x08(SB)/8, $0x0f0c0d0e0b08090a
+GLOBL bgraMask<>(SB), (NOPTR+RODATA), $16
+
+// alphaMask sets the alpha byte of every pixel.
+DATA alphaMask<>+0x00(SB)/8, $0xff000000ff000000
+DATA alphaMask<>+0x08(SB)/8, $0xff000000ff000000
+GLOBL alphaMask<>(SB), (NOPTR+RODATA), $16
+
+// func bgra16(p []byte)
+TEXT ·bgra16(SB),NOSPLIT,$0-24
+	MOVD	p_base+0(FP), R0
+	MOVD	p_len+8(FP), R2
+
+	// Sanity check that len is a multiple of 16.
+	ANDS	$15, R2, R3
+	BNE	done
+
+	MOVD	$bgraMask<>(SB), R3
+	VLD1	(R3), [V0.B16]
+
+	// R0 reads and R1 writes, R4 is the end of the 64-byte blocks and R2 the end of p.
+	MOVD	R0, R1
+	BIC	$63, R2, R4
+	ADD	R0, R4, R4
+	ADD	R0, R2, R2
+loop64:
+	CMP	R0, R4
+	BEQ	loop16
+	VLD1.P	64(R0), [V1.B16, V2.B16, V3.B16, V4.B16]
+	VTBL	V0.B16, [V1.B16], V5.B16
+	VTBL	V0.B16, [V2.B16], V6.B16
+	VTBL	V0.B16, [V3.B16], V7.B16
+	VTBL	V0.B16, [V4.B16], V8.B16
+	VST1.P	[V5.B16, V6.B16, V7.B16, V8.B16], 64(R1)
+	B	loop64
+loop16:
+	CMP	R0, R2
+	BEQ	done
+	VLD1.P	16(R0), [V1.B16]
+	VTBL	V0.B16, [V1.B16], V5.B16
+	VST1.P	[V5.B16], 16(R1)
+	B	loop16
+done:
+	RET
+
+// func bgrx16(dst, src []byte)
+TEXT ·bgrx16(SB),NOSPLIT,$0-48
+	MOVD	dst_base+0(FP), R1
+	MOVD	dst_len+8(FP), R2
+	MOVD	src_base+24(FP), R0
+
+	// Sanity check that len is a multiple of 16.
+	ANDS	$15, R2, R3
+	BNE	done
+
+	MOVD	$bgraMask<>(SB), R3
+	VLD1	(R3), [V0.B16]
+	MOVD	$alphaMask<>(SB), R3
+	VLD1	(R3), [V9.B16]
+
+	// R0 reads and R1 writes, R4 is the end of the 64-byte blocks and R2 the end of dst.
+	BIC	$63, R2, R4
+	ADD	R1, R4, R4
+	ADD	R1, R2, R2
+loop64:
+	CMP	R1, R4
+	BEQ	loop16
+	VLD1.P	64(R0), [V1.B16, V2.B16, V3.B16, V4.B16]
+	VTBL	V0.B16, [V1.B16], V5.B16
+	VTBL	V0.B16, [V2.B16], V6.B16
+	VTBL	V0.B16, [V3.B16], V7.B16
+	VTBL	V0.B16, [V4.B16], V8.B16
+	VORR	V9.B16, V5.B16, V5.B16
+	VORR	V9.B16, V6.B16, V6.B16
+	VORR	V9.B16, V7.B16, V7.B16
+	VORR	V9.B16, V8.B16, V8.B16
+	VST1.P	[V5.B16, V6.B16, V7.B16, V8.B16], 64(R1)
+	B	loop64
+loop16:
+	CMP	R1, R2
+	BEQ	done
+	VLD1.P	16(R0), [V1.B16]
+	VTBL	V0.B16, [V1.B16], V5.B16
+	VORR	V9.B16, V5.B16, V5.B16
+	VST1.P	[V5.B16], 16(R1)
+	B	loop16
+done:
+	RET
@@ -15,7 +15,16 @@ func BGRA(p []byte) {
 		panic("input slice length is not a multiple of 4")
 	}
 
-	// Use asm code for 16- or 4-byte chunks, if supported.
+	// Use asm code for 64-, 32-, 16- or 4-byte chunks, if supported.
+	if useBGRA64 {
+		n := len(p) &^ (64 - 1)
+		bgra64(p[:n])
+		p = p[n:]
+	} else if useBGRA32 {
+		n := len(p) &^ (32 - 1)
+		bgra32(p[:n])
+		p = p[n:]
+	}
 	if useBGRA16 {
 		n := len(p) &^ (16 - 1)
 		bgra16(p[:n])
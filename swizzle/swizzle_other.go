@@ -2,14 +2,25 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-// +build !amd64
+//go:build !amd64 && !arm64
+// +build !amd64,!arm64
 
 package swizzle
 
 const (
+	useBGRA64 = false
+	useBGRA32 = false
 	useBGRA16 = false
 	useBGRA4  = false
 )
 
+func bgra64(p []byte) { panic("unreachable") }
+func bgra32(p []byte) { panic("unreachable") }
 func bgra16(p []byte) { panic("unreachable") }
 func bgra4(p []byte)  { panic("unreachable") }
+
+func bgrx64(dst, src []byte) { panic("unreachable") }
+func bgrx32(dst, src []byte) { panic("unreachable") }
+func bgrx16(dst, src []byte) { panic("unreachable") }
+
+func yuv420x8(y0, y1, u, v, s0, s1 *byte, n int, c *yuvConsts) { panic("unreachable") }
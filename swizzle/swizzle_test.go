@@ -38,8 +38,8 @@ func TestBGRAShortInput(t *testing.T) {
 
 func TestBGRARandomInput(t *testing.T) {
 	r := rand.New(rand.NewSource(1))
-	fastBuf := make([]byte, 1024)
-	slowBuf := make([]byte, 1024)
+	fastBuf := make([]byte, 4096)
+	slowBuf := make([]byte, 4096)
 	for i := range fastBuf {
 		fastBuf[i] = uint8(r.Intn(256))
 	}
@@ -48,6 +48,11 @@ func TestBGRARandomInput(t *testing.T) {
 	for i := 0; i < 100000; i++ {
 		o := r.Intn(len(fastBuf))
 		n := r.Intn(len(fastBuf)-o) &^ 0x03
+		if i%4 == 0 {
+			// lengths around the block sizes of the asm kernels, from unaligned offsets
+			n = (64*r.Intn(8) + 4*r.Intn(32)) &^ 0x03
+			o = r.Intn(len(fastBuf) - n)
+		}
 		BGRA(fastBuf[o : o+n])
 		pureGoBGRA(slowBuf[o : o+n])
 		if bytes.Equal(fastBuf, slowBuf) {
@@ -64,6 +69,39 @@ func TestBGRARandomInput(t *testing.T) {
 	}
 }
 
+func TestBGRAKernels(t *testing.T) {
+	kernels := []struct {
+		name      string
+		size      int
+		supported bool
+		f         func([]byte)
+	}{
+		{"bgra16", 16, useBGRA16, bgra16},
+		{"bgra32", 32, useBGRA32, bgra32},
+		{"bgra64", 64, useBGRA64, bgra64},
+	}
+	r := rand.New(rand.NewSource(2))
+	for _, k := range kernels {
+		if !k.supported {
+			t.Logf("%s is not supported on this CPU", k.name)
+			continue
+		}
+		fastBuf := make([]byte, 4096+64)
+		slowBuf := make([]byte, len(fastBuf))
+		r.Read(fastBuf)
+		copy(slowBuf, fastBuf)
+		for i := 0; i < 10000; i++ {
+			o := r.Intn(len(fastBuf))
+			n := r.Intn(len(fastBuf)-o) &^ (k.size - 1)
+			k.f(fastBuf[o : o+n])
+			pureGoBGRA(slowBuf[o : o+n])
+			if !bytes.Equal(fastBuf, slowBuf) {
+				t.Fatalf("%s: iter %d: swizzling [%d:%d+%d] differs from pureGoBGRA", k.name, i, o, o, n)
+			}
+		}
+	}
+}
+
 func pureGoBGRA(p []byte) {
 	if len(p)%4 != 0 {
 		return
@@ -74,13 +112,30 @@ func pureGoBGRA(p []byte) {
 }
 
 func benchmarkBGRA(b *testing.B, f func([]byte)) {
-	const w, h = 1920, 1080 // 1080p RGBA.
+	benchmarkBGRASize(b, f, 1920, 1080) // 1080p RGBA.
+}
+
+func benchmarkBGRASize(b *testing.B, f func([]byte), w, h int) {
 	buf := make([]byte, 4*w*h)
+	b.SetBytes(int64(len(buf)))
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		f(buf)
 	}
 }
 
+// benchmarkKernel benchmarks a single asm kernel, if the CPU supports it.
+func benchmarkKernel(b *testing.B, supported bool, f func([]byte)) {
+	if !supported {
+		b.Skip("not supported on this CPU")
+	}
+	benchmarkBGRA(b, f)
+}
+
 func BenchmarkBGRA(b *testing.B)       { benchmarkBGRA(b, BGRA) }
 func BenchmarkPureGoBGRA(b *testing.B) { benchmarkBGRA(b, pureGoBGRA) }
+func BenchmarkBGRA16(b *testing.B)     { benchmarkKernel(b, useBGRA16, bgra16) }
+func BenchmarkBGRA32(b *testing.B)     { benchmarkKernel(b, useBGRA32, bgra32) }
+func BenchmarkBGRA64(b *testing.B)     { benchmarkKernel(b, useBGRA64, bgra64) }
+func BenchmarkBGRA4K(b *testing.B)     { benchmarkBGRASize(b, BGRA, 3840, 2160) }
+func BenchmarkBGRA5K(b *testing.B)     { benchmarkBGRASize(b, BGRA, 5120, 2880) }
@@ -0,0 +1,307 @@
+package swizzle
+
+import (
+	"image"
+	"math"
+)
+
+// Matrix selects the coefficients used to convert RGB to YCbCr.
+type Matrix int
+
+const (
+	// BT601 is used by SD video and JPEG.
+	BT601 Matrix = iota
+	// BT709 is used by HD video.
+	BT709
+)
+
+// YUVOptions configures the conversion to YCbCr.
+type YUVOptions struct {
+	Matrix Matrix
+	// FullRange uses 0-255 for all components, like JPEG,
+	// instead of the limited range 16-235 for Y and 16-240 for Cb and Cr, which video encoders expect.
+	FullRange bool
+}
+
+// NV12 is a YCbCr 4:2:0 image with a full resolution Y plane
+// and a half resolution plane of interleaved Cb and Cr samples.
+type NV12 struct {
+	Y, UV             []byte
+	YStride, UVStride int
+	Rect              image.Rectangle
+}
+
+// NewNV12 returns an NV12 image whose planes are stored in a single slice, Y first.
+func NewNV12(r image.Rectangle) *NV12 {
+	w, h := r.Dx(), r.Dy()
+	cw, ch := (w+1)/2, (h+1)/2
+	buf := make([]byte, w*h+2*cw*ch)
+	return &NV12{
+		Y:        buf[:w*h:w*h],
+		UV:       buf[w*h:],
+		YStride:  w,
+		UVStride: 2 * cw,
+		Rect:     r,
+	}
+}
+
+// RGBAToI420 converts the RGBA pixels of src, whose rows start every srcStride bytes, into dst.
+// dst must have a 4:2:0 subsample ratio and determines the number of converted pixels.
+// Every Cb and Cr sample is the average of a 2x2 block of pixels.
+func RGBAToI420(dst *image.YCbCr, src []byte, srcStride int, opts YUVOptions) {
+	toI420(dst, src, srcStride, yuvConstants(opts, false, false))
+}
+
+// BGRAToI420 is like RGBAToI420, but for BGRA pixels.
+func BGRAToI420(dst *image.YCbCr, src []byte, srcStride int, opts YUVOptions) {
+	toI420(dst, src, srcStride, yuvConstants(opts, true, false))
+}
+
+// RGBAToYCbCr is like RGBAToI420, but dst can have a 4:4:4, 4:2:2 or 4:2:0 subsample ratio.
+// Every Cb and Cr sample is the average of the pixels it covers.
+func RGBAToYCbCr(dst *image.YCbCr, src []byte, srcStride int, opts YUVOptions) {
+	toYCbCr(dst, src, srcStride, yuvConstants(opts, false, false))
+}
+
+// BGRAToYCbCr is like RGBAToYCbCr, but for BGRA pixels.
+func BGRAToYCbCr(dst *image.YCbCr, src []byte, srcStride int, opts YUVOptions) {
+	toYCbCr(dst, src, srcStride, yuvConstants(opts, true, false))
+}
+
+// RGBAToNV12 is like RGBAToI420, but writes an NV12 image.
+func RGBAToNV12(dst *NV12, src []byte, srcStride int, opts YUVOptions) {
+	toNV12(dst, src, srcStride, yuvConstants(opts, false, true))
+}
+
+// BGRAToNV12 is like RGBAToNV12, but for BGRA pixels.
+func BGRAToNV12(dst *NV12, src []byte, srcStride int, opts YUVOptions) {
+	toNV12(dst, src, srcStride, yuvConstants(opts, true, true))
+}
+
+func toI420(dst *image.YCbCr, src []byte, srcStride int, c *yuvConsts) {
+	if dst.SubsampleRatio != image.YCbCrSubsampleRatio420 {
+		panic("destination is not YCbCr 4:2:0")
+	}
+	r := dst.Rect
+	cOff := dst.COffset(r.Min.X, r.Min.Y)
+	yuv420(dst.Y[dst.YOffset(r.Min.X, r.Min.Y):], dst.YStride, dst.Cb[cOff:], dst.Cr[cOff:], dst.CStride, src, srcStride, r.Dx(), r.Dy(), c)
+}
+
+func toYCbCr(dst *image.YCbCr, src []byte, srcStride int, c *yuvConsts) {
+	hs := 1
+	switch dst.SubsampleRatio {
+	case image.YCbCrSubsampleRatio420:
+		toI420(dst, src, srcStride, c)
+		return
+	case image.YCbCrSubsampleRatio422:
+		hs = 2
+	case image.YCbCrSubsampleRatio444:
+	default:
+		panic("destination is not YCbCr 4:4:4, 4:2:2 or 4:2:0")
+	}
+	r := dst.Rect
+	cOff := dst.COffset(r.Min.X, r.Min.Y)
+	yuvRows(dst.Y[dst.YOffset(r.Min.X, r.Min.Y):], dst.YStride, dst.Cb[cOff:], dst.Cr[cOff:], dst.CStride, src, srcStride, r.Dx(), r.Dy(), hs, c)
+}
+
+func toNV12(dst *NV12, src []byte, srcStride int, c *yuvConsts) {
+	yuv420(dst.Y, dst.YStride, dst.UV, dst.UV, dst.UVStride, src, srcStride, dst.Rect.Dx(), dst.Rect.Dy(), c)
+}
+
+// yuvConsts holds the fixed-point conversion coefficients for one source byte order.
+// The first fields are read by the asm kernels, keep their layout in sync.
+type yuvConsts struct {
+	// coefficients of Y, Cb and Cr for the four bytes of a pixel, repeated for four pixels
+	y, cb, cr [16]int16
+	// yAdd and cAdd add the offset and round Y and the sum of four Cb or Cr terms
+	yAdd, cAdd [8]int32
+	// perm orders the Cb and Cr results of the kernel for the destination planes
+	perm [8]int32
+	// interleaved is set for NV12, where Cb and Cr are written to u only
+	interleaved uint32
+}
+
+const (
+	yuvShift = 15
+	// chroma is computed from the sum of four pixels
+	yuvChromaShift = yuvShift + 2
+)
+
+// yuvTable holds the yuvConsts of every Matrix, range, byte order and chroma layout.
+var yuvTable [2][2][2][2]yuvConsts
+
+func init() {
+	for m := range yuvTable {
+		for full := range yuvTable[m] {
+			for bgr := range yuvTable[m][full] {
+				for nv12 := range yuvTable[m][full][bgr] {
+					yuvTable[m][full][bgr][nv12] = newYUVConsts(Matrix(m), full == 1, bgr == 1, nv12 == 1)
+				}
+			}
+		}
+	}
+}
+
+func yuvConstants(opts YUVOptions, bgr, nv12 bool) *yuvConsts {
+	if opts.Matrix != BT601 && opts.Matrix != BT709 {
+		panic("unknown YCbCr matrix")
+	}
+	return &yuvTable[opts.Matrix][b2i(opts.FullRange)][b2i(bgr)][b2i(nv12)]
+}
+
+func b2i(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func newYUVConsts(m Matrix, full, bgr, nv12 bool) yuvConsts {
+	kr, kb := 0.299, 0.114
+	if m == BT709 {
+		kr, kb = 0.2126, 0.0722
+	}
+	kg := 1 - kr - kb
+	yScale, cScale, yOffset := 219.0/255, 224.0/255, 16
+	if full {
+		yScale, cScale, yOffset = 1, 1, 0
+	}
+	fix := func(v float64) int16 { return int16(math.Round(v * (1 << yuvShift))) }
+
+	// per channel R, G, B, gray maps to Cb = Cr = 128 exactly
+	ky := [3]int16{fix(kr * yScale), fix(kg * yScale), fix(kb * yScale)}
+	kcb := [3]int16{fix(-kr / (2 * (1 - kb)) * cScale), 0, fix(0.5 * cScale)}
+	kcb[1] = -kcb[0] - kcb[2]
+	kcr := [3]int16{fix(0.5 * cScale), 0, fix(-kb / (2 * (1 - kr)) * cScale)}
+	kcr[1] = -kcr[0] - kcr[2]
+	if bgr {
+		ky[0], ky[2] = ky[2], ky[0]
+		kcb[0], kcb[2] = kcb[2], kcb[0]
+		kcr[0], kcr[2] = kcr[2], kcr[0]
+	}
+
+	var c yuvConsts
+	for i := 0; i < 16; i += 4 {
+		copy(c.y[i:], ky[:])
+		copy(c.cb[i:], kcb[:])
+		copy(c.cr[i:], kcr[:])
+	}
+	for i := range c.yAdd {
+		c.yAdd[i] = int32(yOffset)<<yuvShift + 1<<(yuvShift-1)
+		c.cAdd[i] = 128<<yuvChromaShift + 1<<(yuvChromaShift-1)
+	}
+	// see yuv420x8 for the order of the kernel results
+	c.perm = [8]int32{0, 4, 1, 5, 2, 6, 3, 7}
+	if nv12 {
+		c.perm = [8]int32{0, 2, 4, 6, 1, 3, 5, 7}
+		c.interleaved = 1
+	}
+	return c
+}
+
+// yuv420 converts w x h pixels of src into the Y plane y and the chroma planes u and v,
+// or the interleaved chroma plane u if c.interleaved is set.
+func yuv420(y []byte, yStride int, u, v []byte, uvStride int, src []byte, srcStride int, w, h int, c *yuvConsts) {
+	if w <= 0 || h <= 0 {
+		return
+	}
+	cw, ch := (w+1)/2, (h+1)/2
+	uvWidth := cw
+	if c.interleaved != 0 {
+		uvWidth = 2 * cw
+	}
+	if (h-1)*srcStride+w*4 > len(src) || (h-1)*yStride+w > len(y) ||
+		(ch-1)*uvStride+uvWidth > len(u) || (ch-1)*uvStride+uvWidth > len(v) {
+		panic("image is outside of the buffers")
+	}
+
+	for row := 0; row < h; row += 2 {
+		// the last row of an odd height is used twice
+		row1 := row + 1
+		if row1 == h {
+			row1 = row
+		}
+		s0, s1 := src[row*srcStride:], src[row1*srcStride:]
+		y0, y1 := y[row*yStride:], y[row1*yStride:]
+		cu, cv := u[row/2*uvStride:], v[row/2*uvStride:]
+
+		x := 0
+		if useBGRA32 && w >= 8 {
+			n := w / 8
+			yuv420x8(&y0[0], &y1[0], &cu[0], &cv[0], &s0[0], &s1[0], n, c)
+			x = n * 8
+		}
+		for ; x < w; x += 2 {
+			// the last column of an odd width is used twice
+			x1 := x + 1
+			if x1 == w {
+				x1 = x
+			}
+			p00, p01 := s0[x*4:x*4+4], s0[x1*4:x1*4+4]
+			p10, p11 := s1[x*4:x*4+4], s1[x1*4:x1*4+4]
+			y0[x], y0[x1] = c.luma(p00), c.luma(p01)
+			y1[x], y1[x1] = c.luma(p10), c.luma(p11)
+
+			var sum [3]int32
+			for i := range sum {
+				sum[i] = int32(p00[i]) + int32(p01[i]) + int32(p10[i]) + int32(p11[i])
+			}
+			cb := clampByte((sum[0]*int32(c.cb[0]) + sum[1]*int32(c.cb[1]) + sum[2]*int32(c.cb[2]) + c.cAdd[0]) >> yuvChromaShift)
+			cr := clampByte((sum[0]*int32(c.cr[0]) + sum[1]*int32(c.cr[1]) + sum[2]*int32(c.cr[2]) + c.cAdd[0]) >> yuvChromaShift)
+			if c.interleaved != 0 {
+				cu[x], cu[x+1] = cb, cr
+			} else {
+				cu[x/2], cv[x/2] = cb, cr
+			}
+		}
+	}
+}
+
+// yuvRows converts w x h pixels of src into the Y plane y and the chroma planes u and v,
+// which have one sample for every hs pixels of a row.
+func yuvRows(y []byte, yStride int, u, v []byte, uvStride int, src []byte, srcStride int, w, h, hs int, c *yuvConsts) {
+	if w <= 0 || h <= 0 {
+		return
+	}
+	cw := (w + hs - 1) / hs
+	if (h-1)*srcStride+w*4 > len(src) || (h-1)*yStride+w > len(y) ||
+		(h-1)*uvStride+cw > len(u) || (h-1)*uvStride+cw > len(v) {
+		panic("image is outside of the buffers")
+	}
+	for row := 0; row < h; row++ {
+		s, yr := src[row*srcStride:][:w*4], y[row*yStride:][:w]
+		cu, cv := u[row*uvStride:][:cw], v[row*uvStride:][:cw]
+		for x := range yr {
+			yr[x] = c.luma(s[x*4:])
+		}
+		for x := range cu {
+			x0 := x * hs
+			x1 := x0 + hs - 1
+			if x1 == w {
+				// the last column of an odd width is used twice
+				x1 = x0
+			}
+			// the chroma constants are scaled for the sum of four pixels
+			var sum [3]int32
+			for i := range sum {
+				sum[i] = (int32(s[x0*4+i]) + int32(s[x1*4+i])) * 2
+			}
+			cu[x] = clampByte((sum[0]*int32(c.cb[0]) + sum[1]*int32(c.cb[1]) + sum[2]*int32(c.cb[2]) + c.cAdd[0]) >> yuvChromaShift)
+			cv[x] = clampByte((sum[0]*int32(c.cr[0]) + sum[1]*int32(c.cr[1]) + sum[2]*int32(c.cr[2]) + c.cAdd[0]) >> yuvChromaShift)
+		}
+	}
+}
+
+func (c *yuvConsts) luma(p []byte) byte {
+	return clampByte((int32(p[0])*int32(c.y[0]) + int32(p[1])*int32(c.y[1]) + int32(p[2])*int32(c.y[2]) + c.yAdd[0]) >> yuvShift)
+}
+
+func clampByte(v int32) byte {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return byte(v)
+}
@@ -0,0 +1,283 @@
+package swizzle
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+	"testing"
+	"unsafe"
+)
+
+func TestYUVConstsLayout(t *testing.T) {
+	// the asm kernels load the fields by offset
+	var c yuvConsts
+	offsets := []uintptr{
+		unsafe.Offsetof(c.y), unsafe.Offsetof(c.cb), unsafe.Offsetof(c.cr),
+		unsafe.Offsetof(c.yAdd), unsafe.Offsetof(c.cAdd), unsafe.Offsetof(c.perm), unsafe.Offsetof(c.interleaved),
+	}
+	want := []uintptr{0, 32, 64, 96, 128, 160, 192}
+	for i := range want {
+		if offsets[i] != want[i] {
+			t.Fatalf("field offsets = %v, want %v", offsets, want)
+		}
+	}
+}
+
+// referenceYUV converts a single pixel with floating point math.
+func referenceYUV(r, g, b float64, opts YUVOptions) (y, cb, cr float64) {
+	kr, kb := 0.299, 0.114
+	if opts.Matrix == BT709 {
+		kr, kb = 0.2126, 0.0722
+	}
+	luma := kr*r + (1-kr-kb)*g + kb*b
+	cb = (b - luma) / (2 * (1 - kb))
+	cr = (r - luma) / (2 * (1 - kr))
+	if opts.FullRange {
+		return luma, 128 + cb, 128 + cr
+	}
+	return 16 + luma*219/255, 128 + cb*224/255, 128 + cr*224/255
+}
+
+// yuvImage is a converted image, with accessors independent of the chroma layout.
+type yuvImage interface {
+	at(x, y int) (uint8, uint8, uint8)
+}
+
+type i420 struct{ *image.YCbCr }
+
+func (p i420) at(x, y int) (uint8, uint8, uint8) {
+	c := p.YCbCrAt(p.Rect.Min.X+x, p.Rect.Min.Y+y)
+	return c.Y, c.Cb, c.Cr
+}
+
+type nv12 struct{ *NV12 }
+
+func (p nv12) at(x, y int) (uint8, uint8, uint8) {
+	uv := y/2*p.UVStride + x/2*2
+	return p.Y[y*p.YStride+x], p.UV[uv], p.UV[uv+1]
+}
+
+// checkYUV compares img to the conversion of src with floating point math, allowing an error of 1,
+// and requires pixels that are converted by the asm kernels and pure Go to match exactly.
+func checkYUV(t *testing.T, name string, img yuvImage, src []byte, stride, w, h int, bgr bool, opts YUVOptions) {
+	t.Helper()
+	pixel := func(x, y int) (r, g, b float64) {
+		x, y = minInt(x, w-1), minInt(y, h-1)
+		p := src[y*stride+x*4:]
+		if bgr {
+			return float64(p[2]), float64(p[1]), float64(p[0])
+		}
+		return float64(p[0]), float64(p[1]), float64(p[2])
+	}
+	near := func(got uint8, want float64) bool { return math.Abs(float64(got)-want) <= 1 }
+	c := yuvConstants(opts, bgr, false)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			gotY, gotCb, gotCr := img.at(x, y)
+			pr, pg, pb := pixel(x, y)
+			wantY, _, _ := referenceYUV(pr, pg, pb, opts)
+			// chroma is the average of the 2x2 block
+			var r, g, b float64
+			var sum [3]int32
+			for _, p := range [][2]int{{x &^ 1, y &^ 1}, {x | 1, y &^ 1}, {x &^ 1, y | 1}, {x | 1, y | 1}} {
+				pr, pg, pb := pixel(p[0], p[1])
+				r, g, b = r+pr/4, g+pg/4, b+pb/4
+				px := src[minInt(p[1], h-1)*stride+minInt(p[0], w-1)*4:]
+				for i := range sum {
+					sum[i] += int32(px[i])
+				}
+			}
+			_, wantCb, wantCr := referenceYUV(r, g, b, opts)
+			if !near(gotY, wantY) || !near(gotCb, wantCb) || !near(gotCr, wantCr) {
+				t.Fatalf("%s: pixel (%d, %d) = %d %d %d, want %.2f %.2f %.2f", name, x, y, gotY, gotCb, gotCr, wantY, wantCb, wantCr)
+			}
+
+			// the fixed point math of the pure Go path
+			exactY := c.luma(src[y*stride+x*4:])
+			exactCb := clampByte((sum[0]*int32(c.cb[0]) + sum[1]*int32(c.cb[1]) + sum[2]*int32(c.cb[2]) + c.cAdd[0]) >> yuvChromaShift)
+			exactCr := clampByte((sum[0]*int32(c.cr[0]) + sum[1]*int32(c.cr[1]) + sum[2]*int32(c.cr[2]) + c.cAdd[0]) >> yuvChromaShift)
+			if gotY != exactY || gotCb != exactCb || gotCr != exactCr {
+				t.Fatalf("%s: pixel (%d, %d) = %d %d %d, want exactly %d %d %d", name, x, y, gotY, gotCb, gotCr, exactY, exactCb, exactCr)
+			}
+		}
+	}
+}
+
+func (o YUVOptions) String() string {
+	m := "BT601"
+	if o.Matrix == BT709 {
+		m = "BT709"
+	}
+	if o.FullRange {
+		return m + "/full"
+	}
+	return m + "/limited"
+}
+
+func TestYUV420(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	sizes := []image.Point{{1, 1}, {2, 2}, {7, 3}, {8, 2}, {37, 11}, {64, 4}, {131, 5}}
+	for _, size := range sizes {
+		w, h := size.X, size.Y
+		stride := w*4 + r.Intn(3)*4 + 1
+		src := make([]byte, (h-1)*stride+w*4)
+		r.Read(src)
+		for _, opts := range []YUVOptions{{BT601, false}, {BT601, true}, {BT709, false}, {BT709, true}} {
+			for _, bgr := range []bool{false, true} {
+				name := fmt.Sprintf("%dx%d %v bgr=%t", w, h, opts, bgr)
+
+				// a sub image, so the planes have padded rows and offsets
+				yuv := image.NewYCbCr(image.Rect(0, 0, w+6, h+4), image.YCbCrSubsampleRatio420).SubImage(image.Rect(2, 2, w+2, h+2)).(*image.YCbCr)
+				n := NewNV12(image.Rect(0, 0, w, h))
+				if bgr {
+					BGRAToI420(yuv, src, stride, opts)
+					BGRAToNV12(n, src, stride, opts)
+				} else {
+					RGBAToI420(yuv, src, stride, opts)
+					RGBAToNV12(n, src, stride, opts)
+				}
+				checkYUV(t, "I420 "+name, i420{yuv}, src, stride, w, h, bgr, opts)
+				checkYUV(t, "NV12 "+name, nv12{n}, src, stride, w, h, bgr, opts)
+			}
+		}
+	}
+}
+
+func TestYCbCrSubsampling(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	for _, size := range []image.Point{{1, 1}, {7, 3}, {16, 2}, {37, 11}} {
+		w, h := size.X, size.Y
+		stride := w*4 + 4
+		src := make([]byte, (h-1)*stride+w*4)
+		r.Read(src)
+		opts := YUVOptions{Matrix: BT601, FullRange: true}
+		for _, ratio := range []image.YCbCrSubsampleRatio{image.YCbCrSubsampleRatio444, image.YCbCrSubsampleRatio422, image.YCbCrSubsampleRatio420} {
+			for _, bgr := range []bool{false, true} {
+				name := fmt.Sprintf("%dx%d %v bgr=%t", w, h, ratio, bgr)
+				yuv := image.NewYCbCr(image.Rect(0, 0, w+6, h+4), ratio).SubImage(image.Rect(2, 2, w+2, h+2)).(*image.YCbCr)
+				if bgr {
+					BGRAToYCbCr(yuv, src, stride, opts)
+				} else {
+					RGBAToYCbCr(yuv, src, stride, opts)
+				}
+				// chroma is the average of the pixels of the sample
+				hs, vs := 1, 1
+				switch ratio {
+				case image.YCbCrSubsampleRatio422:
+					hs = 2
+				case image.YCbCrSubsampleRatio420:
+					hs, vs = 2, 2
+				}
+				for y := 0; y < h; y++ {
+					for x := 0; x < w; x++ {
+						var sum [3]float64
+						for dy := 0; dy < vs; dy++ {
+							for dx := 0; dx < hs; dx++ {
+								sx, sy := minInt(x/hs*hs+dx, w-1), minInt(y/vs*vs+dy, h-1)
+								p := src[sy*stride+sx*4:]
+								for i := range sum {
+									sum[i] += float64(p[i]) / float64(hs*vs)
+								}
+							}
+						}
+						if bgr {
+							sum[0], sum[2] = sum[2], sum[0]
+						}
+						p := src[y*stride+x*4:]
+						pr, pg, pb := float64(p[0]), float64(p[1]), float64(p[2])
+						if bgr {
+							pr, pb = pb, pr
+						}
+						wantY, _, _ := referenceYUV(pr, pg, pb, opts)
+						_, wantCb, wantCr := referenceYUV(sum[0], sum[1], sum[2], opts)
+						c := yuv.YCbCrAt(x+2, y+2)
+						for _, d := range []float64{float64(c.Y) - wantY, float64(c.Cb) - wantCb, float64(c.Cr) - wantCr} {
+							if math.Abs(d) > 1 {
+								t.Fatalf("%s: pixel (%d, %d) = %v, want %.2f %.2f %.2f", name, x, y, c, wantY, wantCb, wantCr)
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestYUV420Colors(t *testing.T) {
+	// well known values of limited range BT.709
+	testCases := []struct {
+		rgb        color.RGBA
+		y, cb, cr  uint8
+		fullY      uint8
+		fullCbCrOK bool
+	}{
+		{color.RGBA{0, 0, 0, 255}, 16, 128, 128, 0, true},
+		{color.RGBA{255, 255, 255, 255}, 235, 128, 128, 255, true},
+		{color.RGBA{128, 128, 128, 255}, 126, 128, 128, 128, true},
+		{color.RGBA{255, 0, 0, 255}, 63, 102, 240, 54, false},
+		{color.RGBA{0, 255, 0, 255}, 173, 42, 26, 182, false},
+		{color.RGBA{0, 0, 255, 255}, 32, 240, 118, 18, false},
+	}
+	for _, tc := range testCases {
+		img := image.NewRGBA(image.Rect(0, 0, 16, 2))
+		for i := 0; i < len(img.Pix); i += 4 {
+			img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = tc.rgb.R, tc.rgb.G, tc.rgb.B, tc.rgb.A
+		}
+		yuv := image.NewYCbCr(img.Rect, image.YCbCrSubsampleRatio420)
+		RGBAToI420(yuv, img.Pix, img.Stride, YUVOptions{Matrix: BT709})
+		for _, x := range []int{0, 15} {
+			if c := yuv.YCbCrAt(x, 1); c.Y != tc.y || c.Cb != tc.cb || c.Cr != tc.cr {
+				t.Errorf("%v at %d: got %d %d %d, want %d %d %d", tc.rgb, x, c.Y, c.Cb, c.Cr, tc.y, tc.cb, tc.cr)
+			}
+		}
+		RGBAToI420(yuv, img.Pix, img.Stride, YUVOptions{Matrix: BT709, FullRange: true})
+		if c := yuv.YCbCrAt(0, 0); c.Y != tc.fullY || (tc.fullCbCrOK && (c.Cb != 128 || c.Cr != 128)) {
+			t.Errorf("%v full range: got %d %d %d, want Y %d", tc.rgb, c.Y, c.Cb, c.Cr, tc.fullY)
+		}
+	}
+}
+
+func TestYUV420MatchesJPEG(t *testing.T) {
+	// image/color converts like JPEG, which is full range BT.601
+	r := rand.New(rand.NewSource(2))
+	img := image.NewRGBA(image.Rect(0, 0, 32, 2))
+	r.Read(img.Pix)
+	yuv := image.NewYCbCr(img.Rect, image.YCbCrSubsampleRatio420)
+	RGBAToI420(yuv, img.Pix, img.Stride, YUVOptions{Matrix: BT601, FullRange: true})
+	for x := 0; x < 32; x++ {
+		p := img.RGBAAt(x, 0)
+		want, _, _ := color.RGBToYCbCr(p.R, p.G, p.B)
+		if got := yuv.YCbCrAt(x, 0).Y; got < want-1 || got > want+1 {
+			t.Errorf("Y at %d = %d, want %d", x, got, want)
+		}
+	}
+}
+
+func benchmarkYUV(b *testing.B, convert func(img *image.RGBA)) {
+	img := image.NewRGBA(image.Rect(0, 0, 1920, 1080))
+	rand.New(rand.NewSource(1)).Read(img.Pix)
+	b.SetBytes(int64(len(img.Pix)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		convert(img)
+	}
+}
+
+func BenchmarkRGBAToI420(b *testing.B) {
+	yuv := image.NewYCbCr(image.Rect(0, 0, 1920, 1080), image.YCbCrSubsampleRatio420)
+	benchmarkYUV(b, func(img *image.RGBA) { RGBAToI420(yuv, img.Pix, img.Stride, YUVOptions{Matrix: BT709}) })
+}
+
+func BenchmarkBGRAToNV12(b *testing.B) {
+	n := NewNV12(image.Rect(0, 0, 1920, 1080))
+	benchmarkYUV(b, func(img *image.RGBA) { BGRAToNV12(n, img.Pix, img.Stride, YUVOptions{Matrix: BT709}) })
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -74,6 +74,9 @@ const (
 //sys	HeapFree(hHeap syscall.Handle, dwFlags uint32, lpMem uintptr) (err error) = Kernel32.HeapFree
 //sys	heapSize(hHeap syscall.Handle, dwFlags uint32, lpMem uintptr) (size uintptr, err error) [failretval==^uintptr(r0)] = Kernel32.HeapSize
 
+//sys	QueryPerformanceCounter(counter *int64) (err error) = Kernel32.QueryPerformanceCounter
+//sys	QueryPerformanceFrequency(frequency *int64) (err error) = Kernel32.QueryPerformanceFrequency
+
 //sys	dragQueryFile(hDrop syscall.Handle, iFile int, buf *uint16, len uint32) (n int, err error) = Shell32.DragQueryFileW
 
 const (
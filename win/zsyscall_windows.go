@@ -49,6 +49,8 @@ var (
 	procHeapAlloc                     = modKernel32.NewProc("HeapAlloc")
 	procHeapFree                      = modKernel32.NewProc("HeapFree")
 	procHeapSize                      = modKernel32.NewProc("HeapSize")
+	procQueryPerformanceCounter       = modKernel32.NewProc("QueryPerformanceCounter")
+	procQueryPerformanceFrequency     = modKernel32.NewProc("QueryPerformanceFrequency")
 	procDragQueryFileW                = modShell32.NewProc("DragQueryFileW")
 	procAddClipboardFormatListener    = modUser32.NewProc("AddClipboardFormatListener")
 	procCloseClipboard                = modUser32.NewProc("CloseClipboard")
@@ -117,6 +119,22 @@ func heapSize(hHeap syscall.Handle, dwFlags uint32, lpMem uintptr) (size uintptr
 	return
 }
 
+func QueryPerformanceCounter(counter *int64) (err error) {
+	r1, _, e1 := syscall.Syscall(procQueryPerformanceCounter.Addr(), 1, uintptr(unsafe.Pointer(counter)), 0, 0)
+	if r1 == 0 {
+		err = errnoErr(e1)
+	}
+	return
+}
+
+func QueryPerformanceFrequency(frequency *int64) (err error) {
+	r1, _, e1 := syscall.Syscall(procQueryPerformanceFrequency.Addr(), 1, uintptr(unsafe.Pointer(frequency)), 0, 0)
+	if r1 == 0 {
+		err = errnoErr(e1)
+	}
+	return
+}
+
 func dragQueryFile(hDrop syscall.Handle, iFile int, buf *uint16, len uint32) (n int, err error) {
 	r0, _, e1 := syscall.Syscall6(procDragQueryFileW.Addr(), 4, uintptr(hDrop), uintptr(iFile), uintptr(unsafe.Pointer(buf)), uintptr(len), 0, 0)
 	n = int(r0)